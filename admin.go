@@ -0,0 +1,284 @@
+package ahimsarest
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/gorilla/mux"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// The largest signed blacklist that can be imported.
+const maxImportBytes = 16 << 20
+
+// A BlacklistReq holds the reason a bulletin is being censored.
+type BlacklistReq struct {
+	Reason string `json:"reason"`
+}
+
+// A SubscribeReq holds where the signed blacklist of an issuer is fetched
+// from. It may be left out when lists are only ever imported by hand.
+type SubscribeReq struct {
+	URL string `json:"url"`
+}
+
+// operator returns the name of the operator that owns the bearer token of the
+// request. The tokens are compared in constant time.
+func operator(tokens map[string]string, request *http.Request) (string, bool) {
+
+	auth := request.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	given := []byte(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+
+	name, found := "", false
+	for token, op := range tokens {
+		if subtle.ConstantTimeCompare(given, []byte(token)) == 1 {
+			name, found = op, true
+		}
+	}
+	return name, found
+}
+
+// adminOnly lets through the requests made by an operator, along with the
+// name of the operator.
+func adminOnly(o *BlacklistOverlay, tokens map[string]string, h func(http.ResponseWriter, *http.Request, string)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		if o == nil || len(tokens) == 0 {
+			writeError(w, request, 501, "This api is not administered")
+			return
+		}
+
+		name, ok := operator(tokens, request)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, request, 401, "A valid operator token is required")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		h(w, request, name)
+	}
+}
+
+// Handles an operator censoring a bulletin. The body is a BlacklistReq.
+func BlacklistAddHandler(o *BlacklistOverlay, tokens map[string]string) func(http.ResponseWriter, *http.Request) {
+	return adminOnly(o, tokens, func(w http.ResponseWriter, request *http.Request, name string) {
+
+		txid := mux.Vars(request)["txid"]
+
+		var req BlacklistReq
+		body := http.MaxBytesReader(w, request.Body, maxPostBytes)
+		if err := json.NewDecoder(body).Decode(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+			writeError(w, request, 400, "A reason must be given")
+			return
+		}
+
+		if _, err := o.Add(name, txid, req.Reason); err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeJson(w, request, &ombjson.BlacklistEntry{Txid: txid, Reason: req.Reason})
+	})
+}
+
+// Handles an operator lifting the censorship of a bulletin.
+func BlacklistRemoveHandler(o *BlacklistOverlay, tokens map[string]string) func(http.ResponseWriter, *http.Request) {
+	return adminOnly(o, tokens, func(w http.ResponseWriter, request *http.Request, name string) {
+
+		txid := mux.Vars(request)["txid"]
+
+		_, err := o.Remove(name, txid)
+		if err == errNotListed {
+			writeError(w, request, 404, err.Error())
+			return
+		}
+		if err == errStoreEntry {
+			writeError(w, request, 409, err.Error())
+			return
+		}
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+}
+
+// Serves every change made to the blacklist to operators.
+func AuditHandler(o *BlacklistOverlay, tokens map[string]string) func(http.ResponseWriter, *http.Request) {
+	return adminOnly(o, tokens, func(w http.ResponseWriter, request *http.Request, name string) {
+
+		log, err := o.Audit()
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeJson(w, request, log)
+	})
+}
+
+// Handles an operator importing the signed blacklist of an issuer they are
+// subscribed to. The body is a SignedBlacklist.
+func ImportBlacklistHandler(o *BlacklistOverlay, tokens map[string]string, net *chaincfg.Params) func(http.ResponseWriter, *http.Request) {
+	return adminOnly(o, tokens, func(w http.ResponseWriter, request *http.Request, name string) {
+
+		var list SignedBlacklist
+		body := http.MaxBytesReader(w, request.Body, maxImportBytes)
+		if err := json.NewDecoder(body).Decode(&list); err != nil {
+			writeError(w, request, 400, "The body is not a signed blacklist")
+			return
+		}
+
+		_, err := o.Import(name, &list, net)
+		switch err {
+		case nil:
+		case errBadSignature, errUnsupportedVersion, errBadEntry:
+			writeError(w, request, 400, err.Error())
+			return
+		case errNotSubscribed:
+			writeError(w, request, 403, err.Error())
+			return
+		case errStaleList:
+			writeError(w, request, 409, err.Error())
+			return
+		default:
+			serverError(w, request, err)
+			return
+		}
+
+		writeJson(w, request, o.subscription(list.Issuer))
+	})
+}
+
+// Serves the issuers that the operators subscribe to.
+func SubscriptionsHandler(o *BlacklistOverlay, tokens map[string]string) func(http.ResponseWriter, *http.Request) {
+	return adminOnly(o, tokens, func(w http.ResponseWriter, request *http.Request, name string) {
+		writeJson(w, request, o.Subscriptions())
+	})
+}
+
+// Handles an operator subscribing to the blacklists of an issuer. The body is
+// an optional SubscribeReq.
+func SubscribeHandler(o *BlacklistOverlay, tokens map[string]string) func(http.ResponseWriter, *http.Request) {
+	return adminOnly(o, tokens, func(w http.ResponseWriter, request *http.Request, name string) {
+
+		var req SubscribeReq
+		body := http.MaxBytesReader(w, request.Body, maxPostBytes)
+		if err := json.NewDecoder(body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, request, 400, "The body is not a subscription")
+			return
+		}
+		if req.URL != "" {
+			if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				writeError(w, request, 400, "The url must be an http or https url")
+				return
+			}
+		}
+
+		if _, err := o.Subscribe(name, mux.Vars(request)["issuer"], req.URL); err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+}
+
+// Handles an operator fetching the latest blacklist of an issuer from the url
+// they subscribed with.
+func RefreshHandler(o *BlacklistOverlay, tokens map[string]string, net *chaincfg.Params) func(http.ResponseWriter, *http.Request) {
+	return adminOnly(o, tokens, func(w http.ResponseWriter, request *http.Request, name string) {
+
+		sub, err := o.Refresh(request.Context(), name, mux.Vars(request)["issuer"], net)
+		switch err {
+		case nil:
+		case errNotSubscribed:
+			writeError(w, request, 404, err.Error())
+			return
+		case errNoListURL:
+			writeError(w, request, 409, err.Error())
+			return
+		case errBadSignature, errUnsupportedVersion, errBadEntry, errWrongIssuer:
+			writeError(w, request, 502, err.Error())
+			return
+		default:
+			switch err.(type) {
+			case *ErrServer, *ErrNotFound, *ErrCensored:
+				writeError(w, request, 502, err.Error())
+			default:
+				serverError(w, request, err)
+			}
+			return
+		}
+
+		writeJson(w, request, sub)
+	})
+}
+
+// Handles an operator dropping the blacklist of an issuer.
+func UnsubscribeHandler(o *BlacklistOverlay, tokens map[string]string) func(http.ResponseWriter, *http.Request) {
+	return adminOnly(o, tokens, func(w http.ResponseWriter, request *http.Request, name string) {
+
+		_, err := o.Unsubscribe(name, mux.Vars(request)["issuer"])
+		if err == errNotSubscribed {
+			writeError(w, request, 404, err.Error())
+			return
+		}
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+}
+
+// Handles an operator overriding the lists they subscribe to for a single
+// bulletin. The body is a BlacklistReq holding why the bulletin is allowed.
+func AllowHandler(o *BlacklistOverlay, tokens map[string]string) func(http.ResponseWriter, *http.Request) {
+	return adminOnly(o, tokens, func(w http.ResponseWriter, request *http.Request, name string) {
+
+		var req BlacklistReq
+		body := http.MaxBytesReader(w, request.Body, maxPostBytes)
+		if err := json.NewDecoder(body).Decode(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+			writeError(w, request, 400, "A reason must be given")
+			return
+		}
+
+		if _, err := o.Allow(name, mux.Vars(request)["txid"], req.Reason); err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+}
+
+// Handles an operator dropping an override.
+func DisallowHandler(o *BlacklistOverlay, tokens map[string]string) func(http.ResponseWriter, *http.Request) {
+	return adminOnly(o, tokens, func(w http.ResponseWriter, request *http.Request, name string) {
+
+		_, err := o.Disallow(name, mux.Vars(request)["txid"])
+		if err == errNotAllowed {
+			writeError(w, request, 404, err.Error())
+			return
+		}
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+}
@@ -0,0 +1,161 @@
+package ahimsarest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func doAdmin(t *testing.T, method, url, token, body string) *http.Response {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestBlacklistAdmin(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ahimsarest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "audit.log")
+
+	store := newTestMemStore()
+	overlay, err := NewBlacklistOverlay(store, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions
+	opts.Blacklist = overlay
+	opts.AdminTokens = map[string]string{"s3cret": "alice"}
+
+	ts := httptest.NewServer(NewHandler("/", store, opts))
+	defer ts.Close()
+
+	txid := "933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9"
+	block := ts.URL + "/block/00000000000016b6ff59b9fffcade68943bb02270b46d2a001054d95c56ca8ad"
+	ban := ts.URL + "/admin/blacklist/" + txid
+
+	// Warm the cache and the search index so that both have to be invalidated.
+	get(t, ts.URL+"/bulletin/"+txid)
+	if !strings.Contains(get(t, ts.URL+"/search?q=medium"), txid) {
+		t.Fatalf("The bulletin should be searchable")
+	}
+
+	var adminTests = []struct {
+		method, url, token, body string
+		statuscode               int
+	}{
+		{"PUT", ban, "", `{"reason": "No reason"}`, 401},
+		{"PUT", ban, "wrong", `{"reason": "No reason"}`, 401},
+		{"PUT", ban, "s3cret", `{}`, 400},
+		{"GET", ts.URL + "/admin/audit", "", "", 401},
+		{"PUT", ban, "s3cret", `{"reason": "Spam"}`, 200},
+		{"GET", ts.URL + "/bulletin/" + txid, "", "", 451},
+		{"GET", ts.URL + "/v1/bulletin/" + txid, "", "", 451},
+	}
+
+	for _, test := range adminTests {
+		res := doAdmin(t, test.method, test.url, test.token, test.body)
+		if res.StatusCode != test.statuscode {
+			t.Errorf("%s %s: got %d wanted %d", test.method, test.url, res.StatusCode, test.statuscode)
+		}
+	}
+
+	for _, url := range []string{block, ts.URL + "/board/ahimsa-dev", ts.URL + "/author/miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH"} {
+		if body := get(t, url); !strings.Contains(body, `"bannedReason":"Spam"`) || strings.Contains(body, "the mind is our medium") {
+			t.Errorf("%s still serves the censored bulletin", url)
+		}
+	}
+	if strings.Contains(get(t, ts.URL+"/search?q=medium"), txid) {
+		t.Errorf("The censored bulletin is still searchable")
+	}
+
+	var blacklist []map[string]string
+	json.Unmarshal([]byte(get(t, ts.URL+"/blacklist")), &blacklist)
+	if len(blacklist) != 2 {
+		t.Errorf("Expected both blacklists to be served: %v", blacklist)
+	}
+
+	var liftTests = []struct {
+		txid       string
+		statuscode int
+	}{
+		{txid, 204},
+		{txid, 404},
+		{"b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be", 409},
+	}
+	for _, test := range liftTests {
+		res := doAdmin(t, "DELETE", ts.URL+"/admin/blacklist/"+test.txid, "s3cret", "")
+		if res.StatusCode != test.statuscode {
+			t.Errorf("DELETE %s: got %d wanted %d", test.txid, res.StatusCode, test.statuscode)
+		}
+	}
+	if res := doGet(t, ts.URL+"/bulletin/"+txid, nil); res.StatusCode != 200 {
+		t.Errorf("The lifted bulletin is still censored: %d", res.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/admin/audit", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log []AuditEntry
+	err = json.NewDecoder(res.Body).Decode(&log)
+	res.Body.Close()
+	if err != nil || len(log) != 2 {
+		t.Fatalf("Expected 2 audit entries: %v %v", log, err)
+	}
+	if log[0].Actor != "alice" || log[0].Action != AuditAdd || log[0].Reason != "Spam" || log[1].Action != AuditRemove {
+		t.Errorf("Unexpected audit log %+v", log)
+	}
+}
+
+// Asserts that the blacklist survives a restart.
+func TestBlacklistReplay(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ahimsarest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "audit.log")
+
+	txid := "f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf"
+	overlay, err := NewBlacklistOverlay(newTestMemStore(), logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overlay.Add("bob", txid, "Off topic"); err != nil {
+		t.Fatal(err)
+	}
+
+	overlay, err = NewBlacklistOverlay(newTestMemStore(), logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overlay.GetJsonBltn(txid); err == nil {
+		t.Errorf("The blacklist was not replayed")
+	}
+	unconf, _ := overlay.GetUnconfirmed()
+	for _, bltn := range unconf {
+		if bltn.Txid == txid && bltn.BannedReason != "Off topic" {
+			t.Errorf("Unconfirmed bulletins are not censored: %+v", bltn)
+		}
+	}
+}
@@ -0,0 +1,1034 @@
+package ahimsarest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// The header that names the upstreams that could not be reached when a
+// response was merged from the others.
+const unavailableHeader = "X-Unavailable-Sources"
+
+// An Upstream is an api that an aggregator fans requests out to. Everything it
+// serves is tagged with its name.
+type Upstream struct {
+	Name   string
+	Client *Client
+}
+
+// LocalUpstream serves db in process so that a public record held on this
+// machine can be aggregated along with remote apis.
+func LocalUpstream(name string, db Store) Upstream {
+	cli := NewClientPrefix("http://local", "/")
+	cli.httpCli = &http.Client{Transport: handlerTransport{Handler("/", db)}}
+	return Upstream{Name: name, Client: cli}
+}
+
+// A handlerTransport answers requests with a handler instead of the network.
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Handlers see the requests of servers, which carry their RequestURI.
+	r := req.WithContext(req.Context())
+	r.RequestURI = req.URL.RequestURI()
+
+	rec := &responseRecorder{header: make(http.Header)}
+	t.h.ServeHTTP(rec, r)
+	return rec.response(req), nil
+}
+
+// A responseRecorder holds on to what a handler wrote so that it can be read
+// back as if it came over the network.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = 200
+	}
+	return r.body.Write(p)
+}
+
+// response returns what was written as the response to req.
+func (r *responseRecorder) response(req *http.Request) *http.Response {
+	status := r.status
+	if status == 0 {
+		status = 200
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.header,
+		Body:          ioutil.NopCloser(&r.body),
+		ContentLength: int64(r.body.Len()),
+		Request:       req,
+	}
+}
+
+// A SourcedBulletin is a bulletin along with the upstream that served it.
+type SourcedBulletin struct {
+	*Bulletin
+	Source string `json:"source"`
+}
+
+// A SourcedBlkHead is the head of a block along with the upstream that served
+// it.
+type SourcedBlkHead struct {
+	*ombjson.JsonBlkHead
+	Source string `json:"source"`
+}
+
+// A SourcedBlock is a block along with the upstream that served it.
+type SourcedBlock struct {
+	*BlockResp
+	Source string `json:"source"`
+}
+
+// A SourcedBoard is a board as every upstream that holds it sees it together.
+type SourcedBoard struct {
+	*ombjson.BoardSummary
+	Sources []string `json:"sources"`
+}
+
+// A SourcedAuthor is an author as every upstream that holds them sees them
+// together.
+type SourcedAuthor struct {
+	*ombjson.AuthorSummary
+	Sources []string `json:"sources"`
+}
+
+// A SourcedEntry is an entry of the blacklist along with the upstreams that
+// hold it.
+type SourcedEntry struct {
+	*ombjson.BlacklistEntry
+	Sources []string `json:"sources"`
+}
+
+// A SourcedBoardResp is a board along with every bulletin any upstream holds
+// for it.
+type SourcedBoardResp struct {
+	Summary *ombjson.BoardSummary `json:"summary"`
+	Bltns   []*SourcedBulletin    `json:"bltns"`
+	Sources []string              `json:"sources"`
+}
+
+// A SourcedAuthorResp is an author along with every bulletin any upstream
+// holds for them.
+type SourcedAuthorResp struct {
+	Author  *ombjson.AuthorSummary `json:"author"`
+	Bltns   []*SourcedBulletin     `json:"bltns"`
+	Sources []string               `json:"sources"`
+}
+
+// A SourcedBoardPage is a single page of a SourcedBoardResp.
+type SourcedBoardPage struct {
+	Summary *ombjson.BoardSummary `json:"summary"`
+	Bltns   []*SourcedBulletin    `json:"bltns"`
+	Sources []string              `json:"sources"`
+	Next    string                `json:"next,omitempty"`
+}
+
+// A SourcedAuthorPage is a single page of a SourcedAuthorResp.
+type SourcedAuthorPage struct {
+	Author  *ombjson.AuthorSummary `json:"author"`
+	Bltns   []*SourcedBulletin     `json:"bltns"`
+	Sources []string               `json:"sources"`
+	Next    string                 `json:"next,omitempty"`
+}
+
+// A SourceStatus is the status of a single upstream or why it could not be
+// reached.
+type SourceStatus struct {
+	Name   string      `json:"name"`
+	Status *StatusResp `json:"status,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type sourcedByKey []*SourcedBulletin
+
+func (s sourcedByKey) Len() int      { return len(s) }
+func (s sourcedByKey) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sourcedByKey) Less(i, j int) bool {
+	return bltnKey(s[i].JsonBltn) < bltnKey(s[j].JsonBltn)
+}
+
+type sourcedHeadsByKey []*SourcedBlkHead
+
+func (s sourcedHeadsByKey) Len() int      { return len(s) }
+func (s sourcedHeadsByKey) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sourcedHeadsByKey) Less(i, j int) bool {
+	return blkKey(s[i].JsonBlkHead) < blkKey(s[j].JsonBlkHead)
+}
+
+type sourcedBoardsByName []*SourcedBoard
+
+func (s sourcedBoardsByName) Len() int           { return len(s) }
+func (s sourcedBoardsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sourcedBoardsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+type sourcedAuthorsByAddr []*SourcedAuthor
+
+func (s sourcedAuthorsByAddr) Len() int           { return len(s) }
+func (s sourcedAuthorsByAddr) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sourcedAuthorsByAddr) Less(i, j int) bool { return s[i].Address < s[j].Address }
+
+type sourcedEntriesByTxid []*SourcedEntry
+
+func (s sourcedEntriesByTxid) Len() int           { return len(s) }
+func (s sourcedEntriesByTxid) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sourcedEntriesByTxid) Less(i, j int) bool { return s[i].Txid < s[j].Txid }
+
+// An aggResult is what a single upstream answered.
+type aggResult struct {
+	name string
+	v    interface{}
+	err  error
+}
+
+// found reports whether the upstream held what was asked for.
+func (res *aggResult) found() bool {
+	return res.err == nil
+}
+
+// rejected returns the error of an upstream that turned the request down as
+// a bad one.
+func (res *aggResult) rejected() *ErrServer {
+	var e *ErrServer
+	if errors.As(res.err, &e) && e.StatusCode >= 400 && e.StatusCode < 500 {
+		return e
+	}
+	return nil
+}
+
+// unreachable reports whether the upstream could not be reached or failed on
+// its own side.
+func (res *aggResult) unreachable() bool {
+	var notFound *ErrNotFound
+	var censored *ErrCensored
+	return res.err != nil && !errors.As(res.err, &notFound) && !errors.As(res.err, &censored) && res.rejected() == nil
+}
+
+// upstreamPath returns the path of request relative to the prefix of the
+// aggregator along with its query. Lists are merged whole and only filtered
+// and paged once merged, so upstreams are never asked for pages or for a
+// minimum depth.
+func upstreamPath(prefix string, request *http.Request) string {
+	path := strings.TrimPrefix(request.URL.EscapedPath(), prefix)
+	path = strings.TrimPrefix(path, "v1/")
+
+	q := request.URL.Query()
+	q.Del("limit")
+	q.Del("cursor")
+	q.Del("minconf")
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	return path
+}
+
+// fanOut asks every upstream for the path of request at once and decodes each
+// answer into a value made by newV. The results are in the order of ups.
+func fanOut(prefix string, ups []Upstream, request *http.Request, newV func() interface{}) []*aggResult {
+	return fanOutPath(request.Context(), ups, upstreamPath(prefix, request), newV)
+}
+
+// fanOutPath asks every upstream for path at once.
+func fanOutPath(ctx context.Context, ups []Upstream, path string, newV func() interface{}) []*aggResult {
+
+	results := make([]*aggResult, len(ups))
+
+	var wg sync.WaitGroup
+	for i, up := range ups {
+		wg.Add(1)
+		go func(i int, up Upstream) {
+			defer wg.Done()
+			v := newV()
+			err := up.Client.getJson(ctx, path, v)
+			results[i] = &aggResult{name: up.Name, v: v, err: err}
+		}(i, up)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// fanOutCensored is fanOut for requests that serve bulletins. The blacklists
+// of the upstreams are fetched at the same time, so that the reasons returned
+// hold every bulletin that any of them censored.
+func fanOutCensored(prefix string, ups []Upstream, request *http.Request, newV func() interface{}) ([]*aggResult, map[string]string) {
+
+	lists := make(chan []*aggResult, 1)
+	go func() {
+		lists <- fanOutPath(request.Context(), ups, "blacklist", func() interface{} { return &[]*ombjson.BlacklistEntry{} })
+	}()
+	results := fanOut(prefix, ups, request, newV)
+
+	reasons := make(map[string]string)
+	for _, res := range <-lists {
+		if !res.found() {
+			continue
+		}
+		for _, entry := range *res.v.(*[]*ombjson.BlacklistEntry) {
+			if _, ok := reasons[entry.Txid]; !ok {
+				reasons[entry.Txid] = entry.Reason
+			}
+		}
+	}
+	return results, reasons
+}
+
+// redact censors bltn when any upstream censored it, even though the
+// upstream that served it did not.
+func redact(bltn *Bulletin, reasons map[string]string) {
+	reason, ok := reasons[bltn.Txid]
+	if !ok || bltn.BannedReason != "" {
+		return
+	}
+
+	b := *bltn.JsonBltn
+	b.Msg = ""
+	b.BannedReason = reason
+	bltn.JsonBltn = &b
+}
+
+// censored returns the first censorship an upstream answered with.
+func censored(results []*aggResult) *ErrCensored {
+	for _, res := range results {
+		var censored *ErrCensored
+		if errors.As(res.err, &censored) {
+			return censored
+		}
+	}
+	return nil
+}
+
+// markUnavailable names the upstreams that could not be reached in the
+// headers of w.
+func markUnavailable(w http.ResponseWriter, results []*aggResult) {
+	var names []string
+	for _, res := range results {
+		if res.unreachable() {
+			names = append(names, res.name)
+		}
+	}
+	if len(names) > 0 {
+		w.Header().Set(unavailableHeader, strings.Join(names, ", "))
+	}
+}
+
+// writeMerged writes the merged response along with the upstreams that could
+// not be reached.
+func writeMerged(w http.ResponseWriter, request *http.Request, results []*aggResult, v interface{}) {
+	markUnavailable(w, results)
+	writeJson(w, request, v)
+}
+
+// aggListParams parses the paging query and ?minconf= of a request for
+// bulletins, which are applied once the lists of the upstreams are merged. It
+// responds itself if it fails.
+func aggListParams(w http.ResponseWriter, request *http.Request) (pageParams, uint64, bool) {
+
+	pp, err := parsePageParams(request)
+	if err != nil {
+		writeError(w, request, 400, err.Error())
+		return pp, 0, false
+	}
+	minconf, err := parseMinConf(request)
+	if err != nil {
+		writeError(w, request, 400, err.Error())
+		return pp, 0, false
+	}
+
+	return pp, minconf, true
+}
+
+// pageMerged returns the bounds of the page described by pp within a merged
+// list of n items sorted by key.
+func pageMerged(n int, key func(i int) string, pp pageParams) (start, end int, next string) {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = key(i)
+	}
+	return paginate(keys, pp)
+}
+
+// confirmedMerged returns the merged bulletins with at least minconf
+// confirmations as counted by the upstream that served each one.
+func confirmedMerged(merged []*SourcedBulletin, minconf uint64) []*SourcedBulletin {
+	if minconf == 0 {
+		return merged
+	}
+
+	kept := []*SourcedBulletin{}
+	for _, sb := range merged {
+		if sb.Confirmations >= minconf {
+			kept = append(kept, sb)
+		}
+	}
+	return kept
+}
+
+// aggFailed answers a request that no upstream could serve. Items that some
+// upstream censored stay censored and requests that an upstream turned down
+// fail the same way.
+func aggFailed(w http.ResponseWriter, request *http.Request, results []*aggResult) {
+	markUnavailable(w, results)
+
+	if e := censored(results); e != nil {
+		writeError(w, request, 451, e.Reason)
+		return
+	}
+
+	for _, res := range results {
+		if e := res.rejected(); e != nil {
+			writeError(w, request, e.StatusCode, e.Message)
+			return
+		}
+	}
+
+	unreachable := 0
+	for _, res := range results {
+		if res.unreachable() {
+			unreachable++
+		}
+	}
+
+	if unreachable == len(results) {
+		writeError(w, request, 502, "No source could be reached")
+		return
+	}
+	writeError(w, request, 404, "No source holds it")
+}
+
+// aggFirstHandler serves what the first upstream in order that holds it
+// served, tagged with its name by tag. An item that any upstream censored is
+// not served at all.
+func aggFirstHandler(prefix string, ups []Upstream, newV func() interface{}, tag func(v interface{}, name string) interface{}) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		results := fanOut(prefix, ups, request, newV)
+		if censored(results) != nil {
+			aggFailed(w, request, results)
+			return
+		}
+		for _, res := range results {
+			if res.found() {
+				writeMerged(w, request, results, tag(res.v, res.name))
+				return
+			}
+		}
+		aggFailed(w, request, results)
+	}
+}
+
+// aggBlockHandler serves a block as the first upstream in order that holds it
+// served it, with the bulletins that any upstream censored censored.
+func aggBlockHandler(prefix string, ups []Upstream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		results, reasons := fanOutCensored(prefix, ups, request, func() interface{} { return &BlockResp{} })
+		for _, res := range results {
+			if !res.found() {
+				continue
+			}
+			blk := res.v.(*BlockResp)
+			for _, bltn := range blk.Bltns {
+				redact(bltn, reasons)
+			}
+			writeMerged(w, request, results, &SourcedBlock{BlockResp: blk, Source: res.name})
+			return
+		}
+		aggFailed(w, request, results)
+	}
+}
+
+// aggTipHandler serves the highest tip of any upstream.
+func aggTipHandler(prefix string, ups []Upstream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		results := fanOut(prefix, ups, request, func() interface{} { return &ombjson.JsonBlkHead{} })
+
+		var tip *SourcedBlkHead
+		for _, res := range results {
+			if !res.found() {
+				continue
+			}
+			head := res.v.(*ombjson.JsonBlkHead)
+			if tip == nil || head.Height > tip.Height {
+				tip = &SourcedBlkHead{JsonBlkHead: head, Source: res.name}
+			}
+		}
+		if tip == nil {
+			aggFailed(w, request, results)
+			return
+		}
+
+		writeMerged(w, request, results, tip)
+	}
+}
+
+// aggHeadsHandler serves the lists of heads of blocks of every upstream merged
+// into one ordered by height. Where upstreams share a block the first one in
+// order is kept.
+func aggHeadsHandler(prefix string, ups []Upstream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		pp, err := parsePageParams(request)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+
+		results := fanOut(prefix, ups, request, func() interface{} { return &[]*ombjson.JsonBlkHead{} })
+
+		ok := false
+		seen := make(map[string]bool)
+		merged := []*SourcedBlkHead{}
+		for _, res := range results {
+			if !res.found() {
+				continue
+			}
+			ok = true
+			for _, head := range *res.v.(*[]*ombjson.JsonBlkHead) {
+				if !seen[head.Hash] {
+					seen[head.Hash] = true
+					merged = append(merged, &SourcedBlkHead{JsonBlkHead: head, Source: res.name})
+				}
+			}
+		}
+		if !ok {
+			aggFailed(w, request, results)
+			return
+		}
+		sort.Sort(sourcedHeadsByKey(merged))
+
+		if !pp.paged {
+			writeMerged(w, request, results, merged)
+			return
+		}
+		start, end, next := pageMerged(len(merged), func(i int) string { return blkKey(merged[i].JsonBlkHead) }, pp)
+		writeMerged(w, request, results, Page{Items: merged[start:end], Next: next})
+	}
+}
+
+// mergeBltns merges the bulletins of every upstream ordered by time. Where
+// upstreams share a bulletin the first one in order is kept. The reasons of
+// bulletins that came censored are added to reasons.
+func mergeBltns(seen map[string]bool, reasons map[string]string, merged []*SourcedBulletin, bltns []*Bulletin, name string) []*SourcedBulletin {
+	for _, bltn := range bltns {
+		if _, ok := reasons[bltn.Txid]; !ok && bltn.BannedReason != "" {
+			reasons[bltn.Txid] = bltn.BannedReason
+		}
+		if !seen[bltn.Txid] {
+			seen[bltn.Txid] = true
+			merged = append(merged, &SourcedBulletin{Bulletin: bltn, Source: name})
+		}
+	}
+	return merged
+}
+
+// redactMerged censors every merged bulletin that any upstream censored.
+func redactMerged(merged []*SourcedBulletin, reasons map[string]string) {
+	for _, sb := range merged {
+		redact(sb.Bulletin, reasons)
+	}
+}
+
+// aggBltnsHandler serves the lists of bulletins of every upstream merged into
+// one.
+func aggBltnsHandler(prefix string, ups []Upstream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		pp, minconf, ok := aggListParams(w, request)
+		if !ok {
+			return
+		}
+
+		results, reasons := fanOutCensored(prefix, ups, request, func() interface{} { return &[]*Bulletin{} })
+
+		found := false
+		seen := make(map[string]bool)
+		merged := []*SourcedBulletin{}
+		for _, res := range results {
+			if res.found() {
+				found = true
+				merged = mergeBltns(seen, reasons, merged, *res.v.(*[]*Bulletin), res.name)
+			}
+		}
+		if !found {
+			aggFailed(w, request, results)
+			return
+		}
+		redactMerged(merged, reasons)
+		sort.Sort(sourcedByKey(merged))
+		merged = confirmedMerged(merged, minconf)
+
+		if !pp.paged {
+			writeMerged(w, request, results, merged)
+			return
+		}
+		start, end, next := pageMerged(len(merged), func(i int) string { return bltnKey(merged[i].JsonBltn) }, pp)
+		writeMerged(w, request, results, Page{Items: merged[start:end], Next: next})
+	}
+}
+
+// mergeBoard adds what from says of a board to into. Upstreams mostly hold
+// the same bulletins, so the larger count is kept rather than a sum that
+// counts the shared ones twice.
+func mergeBoard(into, from *ombjson.BoardSummary) {
+	if from.NumBltns > into.NumBltns {
+		into.NumBltns = from.NumBltns
+	}
+	if from.CreatedAt != 0 && (into.CreatedAt == 0 || from.CreatedAt < into.CreatedAt) {
+		into.CreatedAt, into.CreatedBy = from.CreatedAt, from.CreatedBy
+	}
+	if from.LastActive > into.LastActive {
+		into.LastActive = from.LastActive
+	}
+}
+
+// mergeAuthor adds what from says of an author to into. Like mergeBoard it
+// keeps the larger count of bulletins.
+func mergeAuthor(into, from *ombjson.AuthorSummary) {
+	if from.NumBltns > into.NumBltns {
+		into.NumBltns = from.NumBltns
+	}
+	if from.FirstBlkTs != 0 && (into.FirstBlkTs == 0 || from.FirstBlkTs < into.FirstBlkTs) {
+		into.FirstBlkTs = from.FirstBlkTs
+	}
+}
+
+// aggBoardHandler serves a board as every upstream that holds it sees it. The
+// bulletins of the board are counted once merged.
+func aggBoardHandler(prefix string, ups []Upstream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		pp, minconf, ok := aggListParams(w, request)
+		if !ok {
+			return
+		}
+
+		results, reasons := fanOutCensored(prefix, ups, request, func() interface{} { return &BoardResp{} })
+
+		var resp *SourcedBoardResp
+		seen := make(map[string]bool)
+		for _, res := range results {
+			if !res.found() {
+				continue
+			}
+			board := res.v.(*BoardResp)
+			if resp == nil {
+				summary := *board.Summary
+				resp = &SourcedBoardResp{Summary: &summary, Bltns: []*SourcedBulletin{}}
+			} else {
+				mergeBoard(resp.Summary, board.Summary)
+			}
+			resp.Bltns = mergeBltns(seen, reasons, resp.Bltns, board.Bltns, res.name)
+			resp.Sources = append(resp.Sources, res.name)
+		}
+		if resp == nil {
+			aggFailed(w, request, results)
+			return
+		}
+		redactMerged(resp.Bltns, reasons)
+		sort.Sort(sourcedByKey(resp.Bltns))
+		resp.Summary.NumBltns = uint64(len(resp.Bltns))
+		resp.Bltns = confirmedMerged(resp.Bltns, minconf)
+
+		if !pp.paged {
+			writeMerged(w, request, results, resp)
+			return
+		}
+		start, end, next := pageMerged(len(resp.Bltns), func(i int) string { return bltnKey(resp.Bltns[i].JsonBltn) }, pp)
+		writeMerged(w, request, results, &SourcedBoardPage{Summary: resp.Summary, Bltns: resp.Bltns[start:end], Sources: resp.Sources, Next: next})
+	}
+}
+
+// aggAuthorHandler serves an author as every upstream that holds them sees
+// them. The bulletins of the author are counted once merged.
+func aggAuthorHandler(prefix string, ups []Upstream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		pp, minconf, ok := aggListParams(w, request)
+		if !ok {
+			return
+		}
+
+		results, reasons := fanOutCensored(prefix, ups, request, func() interface{} { return &AuthorResp{} })
+
+		var resp *SourcedAuthorResp
+		seen := make(map[string]bool)
+		for _, res := range results {
+			if !res.found() {
+				continue
+			}
+			author := res.v.(*AuthorResp)
+			if resp == nil {
+				summary := *author.Author
+				resp = &SourcedAuthorResp{Author: &summary, Bltns: []*SourcedBulletin{}}
+			} else {
+				mergeAuthor(resp.Author, author.Author)
+			}
+			resp.Bltns = mergeBltns(seen, reasons, resp.Bltns, author.Bltns, res.name)
+			resp.Sources = append(resp.Sources, res.name)
+		}
+		if resp == nil {
+			aggFailed(w, request, results)
+			return
+		}
+		redactMerged(resp.Bltns, reasons)
+		sort.Sort(sourcedByKey(resp.Bltns))
+		resp.Author.NumBltns = uint64(len(resp.Bltns))
+		resp.Bltns = confirmedMerged(resp.Bltns, minconf)
+
+		if !pp.paged {
+			writeMerged(w, request, results, resp)
+			return
+		}
+		start, end, next := pageMerged(len(resp.Bltns), func(i int) string { return bltnKey(resp.Bltns[i].JsonBltn) }, pp)
+		writeMerged(w, request, results, &SourcedAuthorPage{Author: resp.Author, Bltns: resp.Bltns[start:end], Sources: resp.Sources, Next: next})
+	}
+}
+
+// aggBoardsHandler serves every board any upstream holds sorted by name.
+func aggBoardsHandler(prefix string, ups []Upstream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		pp, err := parsePageParams(request)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+
+		results := fanOut(prefix, ups, request, func() interface{} { return &[]*ombjson.BoardSummary{} })
+
+		ok := false
+		byName := make(map[string]*SourcedBoard)
+		merged := []*SourcedBoard{}
+		for _, res := range results {
+			if !res.found() {
+				continue
+			}
+			ok = true
+			for _, board := range *res.v.(*[]*ombjson.BoardSummary) {
+				if sb, exists := byName[board.Name]; exists {
+					mergeBoard(sb.BoardSummary, board)
+					sb.Sources = append(sb.Sources, res.name)
+					continue
+				}
+				sb := &SourcedBoard{BoardSummary: board, Sources: []string{res.name}}
+				byName[board.Name] = sb
+				merged = append(merged, sb)
+			}
+		}
+		if !ok {
+			aggFailed(w, request, results)
+			return
+		}
+		sort.Sort(sourcedBoardsByName(merged))
+
+		if !pp.paged {
+			writeMerged(w, request, results, merged)
+			return
+		}
+		start, end, next := pageMerged(len(merged), func(i int) string { return merged[i].Name }, pp)
+		writeMerged(w, request, results, Page{Items: merged[start:end], Next: next})
+	}
+}
+
+// aggAuthorsHandler serves every author any upstream holds sorted by address.
+func aggAuthorsHandler(prefix string, ups []Upstream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		pp, err := parsePageParams(request)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+
+		results := fanOut(prefix, ups, request, func() interface{} { return &[]*ombjson.AuthorSummary{} })
+
+		ok := false
+		byAddr := make(map[string]*SourcedAuthor)
+		merged := []*SourcedAuthor{}
+		for _, res := range results {
+			if !res.found() {
+				continue
+			}
+			ok = true
+			for _, author := range *res.v.(*[]*ombjson.AuthorSummary) {
+				if sa, exists := byAddr[author.Address]; exists {
+					mergeAuthor(sa.AuthorSummary, author)
+					sa.Sources = append(sa.Sources, res.name)
+					continue
+				}
+				sa := &SourcedAuthor{AuthorSummary: author, Sources: []string{res.name}}
+				byAddr[author.Address] = sa
+				merged = append(merged, sa)
+			}
+		}
+		if !ok {
+			aggFailed(w, request, results)
+			return
+		}
+		sort.Sort(sourcedAuthorsByAddr(merged))
+
+		if !pp.paged {
+			writeMerged(w, request, results, merged)
+			return
+		}
+		start, end, next := pageMerged(len(merged), func(i int) string { return merged[i].Address }, pp)
+		writeMerged(w, request, results, Page{Items: merged[start:end], Next: next})
+	}
+}
+
+// aggBlacklistHandler serves every entry of the blacklists of the upstreams
+// sorted by txid.
+func aggBlacklistHandler(prefix string, ups []Upstream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		results := fanOut(prefix, ups, request, func() interface{} { return &[]*ombjson.BlacklistEntry{} })
+
+		ok := false
+		byTxid := make(map[string]*SourcedEntry)
+		merged := []*SourcedEntry{}
+		for _, res := range results {
+			if !res.found() {
+				continue
+			}
+			ok = true
+			for _, entry := range *res.v.(*[]*ombjson.BlacklistEntry) {
+				if se, exists := byTxid[entry.Txid]; exists {
+					se.Sources = append(se.Sources, res.name)
+					continue
+				}
+				se := &SourcedEntry{BlacklistEntry: entry, Sources: []string{res.name}}
+				byTxid[entry.Txid] = se
+				merged = append(merged, se)
+			}
+		}
+		if !ok {
+			aggFailed(w, request, results)
+			return
+		}
+		sort.Sort(sourcedEntriesByTxid(merged))
+
+		writeMerged(w, request, results, merged)
+	}
+}
+
+// aggStatusHandler serves the status of every upstream. It never fails so
+// that it can be used to see which upstreams are down.
+func aggStatusHandler(prefix string, ups []Upstream) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		results := fanOut(prefix, ups, request, func() interface{} { return &StatusResp{} })
+
+		statuses := make([]*SourceStatus, len(results))
+		for i, res := range results {
+			statuses[i] = &SourceStatus{Name: res.name}
+			if res.found() {
+				statuses[i].Status = res.v.(*StatusResp)
+			} else {
+				statuses[i].Error = res.err.Error()
+			}
+		}
+
+		writeMerged(w, request, results, statuses)
+	}
+}
+
+// AggregateHandler serves the public records of several upstreams as one
+// under the same paths that Handler serves a single record at. Requests are
+// fanned out to every upstream at once. Lists are merged and tagged with the
+// upstreams that served each item while single items are served by the first
+// upstream in order that holds them, except for the tip which is the highest
+// one. A bulletin that any upstream censored is served censored. Upstreams
+// that cannot be reached are named in the X-Unavailable-Sources header and
+// only when none of them can be reached does a request fail. A request that an
+// upstream turns down fails with the same status. Lists are sorted, filtered
+// by ?minconf= and paged only once they are merged, in the same order that
+// Handler pages them in, and boards and authors count their merged bulletins.
+//
+// Only the routes that read the public record as json are served: bulletin,
+// author, block, block/height, blockhead, tip, board, nilboard, blacklist,
+// boards, authors, recent, unconfirmed, blocks, blocks/{day}, status and the
+// openapi.json of the aggregator. The other routes of Handler are left out:
+//
+//   - bulletin, bulletin/build and admin act on a single record and its operator.
+//   - blacklist/signed and transparency vouch for the decisions of a single
+//     operator, which cannot be merged without their key.
+//   - stream, ws and reorgs follow the chain of a single record as it changes.
+//   - search ranks with the statistics of a single index, so scores from
+//     several upstreams cannot be compared.
+//   - calendar counts blocks that upstreams share, so counts cannot be summed.
+//   - the atom and rss feeds are not json.
+func AggregateHandler(prefix string, ups ...Upstream) http.Handler {
+
+	first := func(newV func() interface{}, tag func(v interface{}, name string) interface{}) http.HandlerFunc {
+		return aggFirstHandler(prefix, ups, newV, tag)
+	}
+
+	routes := []route{
+		{
+			path:    fmt.Sprintf("bulletin/{txid:%s}", sha2re),
+			summary: "A single bulletin along with the upstream that holds it",
+			resp:    &SourcedBulletin{},
+			errs:    []int{404, 451, 502},
+			handler: first(func() interface{} { return &Bulletin{} }, func(v interface{}, name string) interface{} {
+				return &SourcedBulletin{Bulletin: v.(*Bulletin), Source: name}
+			}),
+		},
+		{
+			path:    fmt.Sprintf("author/{addr:%s}", addrgex),
+			summary: "An author along with every bulletin they wrote in any upstream",
+			resp:    &SourcedAuthorResp{},
+			paged:   &SourcedAuthorPage{},
+			query:   []string{"minconf"},
+			errs:    []int{400, 404, 502},
+			handler: aggAuthorHandler(prefix, ups),
+		},
+		{
+			path:    fmt.Sprintf("block/{hash:%s}", sha2re),
+			summary: "A block along with the upstream that holds it",
+			resp:    &SourcedBlock{},
+			errs:    []int{404, 502},
+			handler: aggBlockHandler(prefix, ups),
+		},
+		{
+			path:    "block/height/{height:[0-9]{1,19}}",
+			summary: "The block at a height along with the upstream that holds it",
+			resp:    &SourcedBlock{},
+			errs:    []int{400, 404, 502},
+			handler: aggBlockHandler(prefix, ups),
+		},
+		{
+			path:    "tip",
+			summary: "The highest head of a block in any upstream",
+			resp:    &SourcedBlkHead{},
+			errs:    []int{404, 502},
+			handler: aggTipHandler(prefix, ups),
+		},
+		{
+			path:    fmt.Sprintf("blockhead/{hash:%s}", sha2re),
+			summary: "The head of a block along with the upstream that holds it",
+			resp:    &SourcedBlkHead{},
+			errs:    []int{404, 502},
+			handler: first(func() interface{} { return &ombjson.JsonBlkHead{} }, func(v interface{}, name string) interface{} {
+				return &SourcedBlkHead{JsonBlkHead: v.(*ombjson.JsonBlkHead), Source: name}
+			}),
+		},
+		{
+			path:    fmt.Sprintf("board/{board:%s}", boardre),
+			summary: "A board along with every bulletin posted to it in any upstream",
+			resp:    &SourcedBoardResp{},
+			paged:   &SourcedBoardPage{},
+			query:   []string{"minconf"},
+			errs:    []int{400, 404, 502},
+			handler: aggBoardHandler(prefix, ups),
+		},
+		{
+			path:    "nilboard",
+			summary: "The bulletins posted without a board in any upstream",
+			resp:    &SourcedBoardResp{},
+			paged:   &SourcedBoardPage{},
+			query:   []string{"minconf"},
+			errs:    []int{400, 404, 502},
+			handler: aggBoardHandler(prefix, ups),
+		},
+		{
+			path:    "blacklist",
+			summary: "The blacklists of every upstream",
+			resp:    []*SourcedEntry{},
+			errs:    []int{502},
+			handler: aggBlacklistHandler(prefix, ups),
+		},
+		{
+			path:    "boards",
+			summary: "A summary of every board in any upstream",
+			resp:    []*SourcedBoard{},
+			paged:   Page{Items: []*SourcedBoard{}},
+			errs:    []int{400, 502},
+			handler: aggBoardsHandler(prefix, ups),
+		},
+		{
+			path:    "authors",
+			summary: "A summary of every author in any upstream",
+			resp:    []*SourcedAuthor{},
+			paged:   Page{Items: []*SourcedAuthor{}},
+			errs:    []int{400, 502},
+			handler: aggAuthorsHandler(prefix, ups),
+		},
+		{
+			path:    "recent",
+			summary: "The recent bulletins of every upstream",
+			resp:    []*SourcedBulletin{},
+			paged:   Page{Items: []*SourcedBulletin{}},
+			query:   []string{"blocks", "since", "unconfirmed", "minconf"},
+			errs:    []int{400, 502},
+			handler: aggBltnsHandler(prefix, ups),
+		},
+		{
+			path:    "unconfirmed",
+			summary: "The unconfirmed bulletins of every upstream",
+			resp:    []*SourcedBulletin{},
+			paged:   Page{Items: []*SourcedBulletin{}},
+			query:   []string{"minconf"},
+			errs:    []int{400, 502},
+			handler: aggBltnsHandler(prefix, ups),
+		},
+		{
+			path:    fmt.Sprintf("blocks/{day:%s}", dayre),
+			summary: "The heads of the blocks found on a day in any upstream",
+			resp:    []*SourcedBlkHead{},
+			paged:   Page{Items: []*SourcedBlkHead{}},
+			query:   []string{"tz"},
+			errs:    []int{400, 404, 502},
+			handler: aggHeadsHandler(prefix, ups),
+		},
+		{
+			path:    "blocks",
+			summary: "The heads of the blocks any upstream holds within a range of heights",
+			resp:    []*SourcedBlkHead{},
+			paged:   Page{Items: []*SourcedBlkHead{}},
+			query:   []string{"from", "to"},
+			errs:    []int{400, 502},
+			handler: aggHeadsHandler(prefix, ups),
+		},
+		{
+			path:    "status",
+			summary: "The status of every upstream",
+			resp:    []*SourceStatus{},
+			handler: aggStatusHandler(prefix, ups),
+		},
+	}
+
+	return newRouter(prefix, routes)
+}
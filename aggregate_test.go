@@ -0,0 +1,249 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+func TestAggregate(t *testing.T) {
+
+	remote := newTestMemStore()
+	remote.AddBltn(&ombjson.JsonBltn{Txid: "e1", Board: "aggregate-test", Author: "miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH", Msg: "elsewhere", Timestamp: 1415862650})
+	opts := DefaultOptions
+	opts.CacheBytes = 0
+	opts.ChainRefresh = 0
+	rs := httptest.NewServer(NewHandler("/api/", remote, opts))
+	defer rs.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	copts := DefaultClientOptions
+	copts.Retries = 0
+
+	ups := []Upstream{
+		LocalUpstream("local", newTestMemStore()),
+		{Name: "remote", Client: NewClient(rs.URL)},
+		{Name: "down", Client: NewClientWith(down.URL, "/api/", copts)},
+	}
+	ts := httptest.NewServer(AggregateHandler("/", ups...))
+	defer ts.Close()
+
+	none := httptest.NewServer(AggregateHandler("/", ups[2]))
+	defer none.Close()
+
+	tests := []struct {
+		url    string
+		status int
+		check  func(body []byte) bool
+	}{
+		{ts.URL + "/boards", 200, func(body []byte) bool {
+			boards := []*SourcedBoard{}
+			json.Unmarshal(body, &boards)
+			sources := map[string]int{}
+			for _, b := range boards {
+				sources[b.Name] = len(b.Sources)
+			}
+			return sources["ahimsa-dev"] == 2 && sources["aggregate-test"] == 1
+		}},
+		{ts.URL + "/v1/unconfirmed", 200, func(body []byte) bool {
+			bltns := []*SourcedBulletin{}
+			json.Unmarshal(body, &bltns)
+			return len(bltns) == 5 && bltns[len(bltns)-1].Txid == "e1" && bltns[len(bltns)-1].Source == "remote"
+		}},
+		{ts.URL + "/bulletin/f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf", 200, func(body []byte) bool {
+			bltn := &SourcedBulletin{}
+			json.Unmarshal(body, bltn)
+			return bltn.Source == "local" && bltn.Msg == "Here comes the sun"
+		}},
+		{ts.URL + "/board/aggregate-test", 200, func(body []byte) bool {
+			board := &SourcedBoardResp{}
+			json.Unmarshal(body, board)
+			return len(board.Bltns) == 1 && len(board.Sources) == 1 && board.Sources[0] == "remote"
+		}},
+		{ts.URL + "/status", 200, func(body []byte) bool {
+			statuses := []*SourceStatus{}
+			json.Unmarshal(body, &statuses)
+			return len(statuses) == 3 && statuses[0].Status != nil && statuses[2].Error != ""
+		}},
+		{ts.URL + "/bulletin/b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be", 451, nil},
+		{ts.URL + "/bulletin/0000000000000000000000000000000000000000000000000000000000000000", 404, nil},
+		{ts.URL + "/tip", 200, func(body []byte) bool {
+			tip := &SourcedBlkHead{}
+			json.Unmarshal(body, tip)
+			return tip.Height == 307012 && tip.Source == "local"
+		}},
+		{ts.URL + "/v1/block/height/305724", 200, func(body []byte) bool {
+			blk := &SourcedBlock{}
+			json.Unmarshal(body, blk)
+			return blk.Source == "local" && len(blk.Bltns) == 1 && blk.Bltns[0].BannedReason != ""
+		}},
+		{ts.URL + "/blocks/2014-11-01", 200, func(body []byte) bool {
+			heads := []*SourcedBlkHead{}
+			json.Unmarshal(body, &heads)
+			return len(heads) == 4 && heads[0].Height == 305694 && heads[3].Height == 305724
+		}},
+		{ts.URL + "/blocks?from=305600&to=305800", 200, func(body []byte) bool {
+			heads := []*SourcedBlkHead{}
+			json.Unmarshal(body, &heads)
+			return len(heads) == 4 && heads[0].Height == 305694 && heads[0].Source == "local"
+		}},
+		{ts.URL + "/blocks/2014-01-01", 404, nil},
+		{ts.URL + "/blocks?from=2&to=1", 400, nil},
+		{ts.URL + "/recent?minconf=abc", 400, nil},
+		{ts.URL + "/v1/board/ahimsa-dev?minconf=-1", 400, nil},
+		{none.URL + "/boards", 502, nil},
+	}
+
+	for _, test := range tests {
+		res, err := http.Get(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body []byte
+		if test.check != nil {
+			body = []byte(get(t, test.url))
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.status {
+			t.Errorf("%s returned %d wanted %d", test.url, res.StatusCode, test.status)
+			continue
+		}
+		// Bad queries are turned down before any upstream is asked.
+		asked := test.status != 502 && !strings.Contains(test.url, "minconf")
+		if asked && res.Header.Get(unavailableHeader) != "down" {
+			t.Errorf("%s named %q as unavailable", test.url, res.Header.Get(unavailableHeader))
+		}
+		if test.check != nil && !test.check(body) {
+			t.Errorf("Unexpected response from %s: %s", test.url, body)
+		}
+	}
+}
+
+// A bulletin that any upstream censored stays censored, even when an upstream
+// ahead of it in order serves the bulletin whole.
+func TestAggregateCensored(t *testing.T) {
+
+	censored := "b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be"
+	open := newTestMemStore()
+	delete(open.blacklist, censored)
+	open.AddBltn(&ombjson.JsonBltn{Txid: "e1", Board: "aggregate-test", Author: "miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH", Msg: "elsewhere", Timestamp: 1415862650})
+
+	// The second upstream does not hold e1 but censors it all the same.
+	strict := newTestMemStore()
+	strict.Blacklist("e1", "Off topic.")
+
+	ups := []Upstream{LocalUpstream("open", open), LocalUpstream("strict", strict)}
+	ts := httptest.NewServer(AggregateHandler("/", ups...))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/bulletin/" + censored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 451 {
+		t.Errorf("The censored bulletin was served with %d", res.StatusCode)
+	}
+
+	reasons := map[string]string{censored: "The Beatles are slanderous.", "e1": "Off topic."}
+	check := func(path string, bltns []*SourcedBulletin) {
+		found := 0
+		for _, bltn := range bltns {
+			reason, ok := reasons[bltn.Txid]
+			if !ok {
+				continue
+			}
+			found++
+			if bltn.Msg != "" || bltn.BannedReason != reason {
+				t.Errorf("%s served %s as %+v", path, bltn.Txid, bltn.JsonBltn)
+			}
+		}
+		if found == 0 {
+			t.Errorf("%s served none of the censored bulletins", path)
+		}
+	}
+
+	unconfirmed := []*SourcedBulletin{}
+	json.Unmarshal([]byte(get(t, ts.URL+"/unconfirmed")), &unconfirmed)
+	check("/unconfirmed", unconfirmed)
+
+	for _, path := range []string{"/board/ahimsa-dev", "/board/aggregate-test"} {
+		board := &SourcedBoardResp{}
+		json.Unmarshal([]byte(get(t, ts.URL+path)), board)
+		check(path, board.Bltns)
+	}
+
+	author := &SourcedAuthorResp{}
+	json.Unmarshal([]byte(get(t, ts.URL+"/author/mnPZBNTrLoCoSkAgSfKeeCujU3129PG6vn")), author)
+	check("/author", author.Bltns)
+
+	blk := &SourcedBlock{}
+	json.Unmarshal([]byte(get(t, ts.URL+"/block/00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d")), blk)
+	if blk.Source != "open" || len(blk.Bltns) != 1 || blk.Bltns[0].BannedReason != reasons[censored] || blk.Bltns[0].Msg != "" {
+		t.Errorf("Unexpected block %+v", blk)
+	}
+}
+
+// Upstreams that hold the same record merge into that record, listed in the
+// order and pages that a single api serves it in.
+func TestAggregateMerge(t *testing.T) {
+
+	single := httptest.NewServer(Handler("/", newTestMemStore()))
+	defer single.Close()
+	ups := []Upstream{LocalUpstream("a", newTestMemStore()), LocalUpstream("b", newTestMemStore())}
+	ts := httptest.NewServer(AggregateHandler("/", ups...))
+	defer ts.Close()
+
+	var want, got []*ombjson.BoardSummary
+	json.Unmarshal([]byte(get(t, single.URL+"/boards")), &want)
+	json.Unmarshal([]byte(get(t, ts.URL+"/boards")), &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merged boards %+v wanted %+v", got, want)
+	}
+
+	var board BoardResp
+	var merged SourcedBoardResp
+	json.Unmarshal([]byte(get(t, single.URL+"/board/ahimsa-dev")), &board)
+	json.Unmarshal([]byte(get(t, ts.URL+"/board/ahimsa-dev")), &merged)
+	if merged.Summary.NumBltns != board.Summary.NumBltns || len(merged.Bltns) != len(board.Bltns) {
+		t.Errorf("Merged board counts %d bulletins wanted %d", merged.Summary.NumBltns, board.Summary.NumBltns)
+	}
+
+	// Walk the merged bulletins of the board a page at a time.
+	var paged []*SourcedBulletin
+	next := ""
+	for i := 0; i == 0 || next != ""; i++ {
+		var page SourcedBoardPage
+		json.Unmarshal([]byte(get(t, ts.URL+"/board/ahimsa-dev?limit=2&cursor="+next)), &page)
+		if len(page.Bltns) > 2 || i > len(board.Bltns) {
+			t.Fatalf("Unexpected page %+v", page)
+		}
+		paged, next = append(paged, page.Bltns...), page.Next
+	}
+	if len(paged) != len(board.Bltns) {
+		t.Errorf("Paged through %d bulletins wanted %d", len(paged), len(board.Bltns))
+	}
+	for i, sb := range paged {
+		if sb.Txid != merged.Bltns[i].Txid {
+			t.Errorf("Page order differs at %d: %s wanted %s", i, sb.Txid, merged.Bltns[i].Txid)
+		}
+	}
+
+	var confirmed SourcedBoardResp
+	json.Unmarshal([]byte(get(t, ts.URL+"/board/ahimsa-dev?minconf=1")), &confirmed)
+	for _, sb := range confirmed.Bltns {
+		if sb.Confirmations < 1 {
+			t.Errorf("Served %s with %d confirmations", sb.Txid, sb.Confirmations)
+		}
+	}
+	if len(confirmed.Bltns) == len(merged.Bltns) {
+		t.Errorf("minconf was not applied")
+	}
+}
@@ -0,0 +1,673 @@
+package ahimsarest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/soapboxsys/ombudslib/ombjson"
+	"github.com/soapboxsys/ombudslib/pubrecdb"
+)
+
+// The actions recorded in the audit log.
+const (
+	AuditAdd         = "add"
+	AuditRemove      = "remove"
+	AuditAllow       = "allow"
+	AuditDisallow    = "disallow"
+	AuditSubscribe   = "subscribe"
+	AuditUnsubscribe = "unsubscribe"
+	AuditImport      = "import"
+)
+
+var (
+	errNotListed     = errors.New("The bulletin is not on the blacklist")
+	errNotAllowed    = errors.New("The bulletin has not been allowed")
+	errStoreEntry    = errors.New("The bulletin is blacklisted by the store and can only be lifted there")
+	errNotSubscribed = errors.New("The issuer is not subscribed to")
+	errStaleList     = errors.New("A newer blacklist of the issuer has already been imported")
+	errNoListURL     = errors.New("The subscription has no url to fetch the blacklist from")
+	errWrongIssuer   = errors.New("The blacklist fetched was not issued by the issuer subscribed to")
+)
+
+// Where the entries of the blacklist come from.
+const (
+	SourceStore    = "store"
+	SourceLocal    = "local"
+	SourceImported = "imported"
+)
+
+// An AuditEntry records a single change that an operator made to the
+// blacklist. Imports carry the whole list that was imported.
+type AuditEntry struct {
+	Time   time.Time        `json:"time"`
+	Actor  string           `json:"actor"`
+	Action string           `json:"action"`
+	Txid   string           `json:"txid,omitempty"`
+	Reason string           `json:"reason,omitempty"`
+	Issuer string           `json:"issuer,omitempty"`
+	URL    string           `json:"url,omitempty"`
+	List   *SignedBlacklist `json:"list,omitempty"`
+}
+
+// A BlacklistSubscription is the latest list imported from an issuer that an operator
+// follows, along with where the list is fetched from when it is refreshed.
+type BlacklistSubscription struct {
+	Issuer   string `json:"issuer"`
+	URL      string `json:"url,omitempty"`
+	Created  int64  `json:"created,omitempty"`
+	Sequence uint64 `json:"sequence,omitempty"`
+	Entries  int    `json:"entries"`
+}
+
+// A BlacklistOverlay is a Store that censors bulletins on top of the blacklist
+// of the store it wraps. Operators censor bulletins themselves and subscribe
+// to the signed blacklists of other operators, overriding the entries they
+// disagree with by allowing them. Every change is appended to an audit log
+// that is replayed when the overlay is opened, so the log is the only state
+// kept.
+type BlacklistOverlay struct {
+	Store
+
+	mu       sync.RWMutex
+	path     string
+	entries  map[string]string
+	added    map[string]time.Time
+	allowed  map[string]string
+	subs     map[string]*SignedBlacklist
+	urls     map[string]string
+	imported map[string]map[string]time.Time
+	censored map[string]string
+	onChange []func()
+}
+
+// NewBlacklistOverlay wraps db with the blacklist recorded in the audit log at
+// path. The log is created if it does not exist.
+func NewBlacklistOverlay(db Store, path string) (*BlacklistOverlay, error) {
+
+	o := &BlacklistOverlay{
+		Store:    db,
+		path:     path,
+		entries:  make(map[string]string),
+		added:    make(map[string]time.Time),
+		allowed:  make(map[string]string),
+		subs:     make(map[string]*SignedBlacklist),
+		urls:     make(map[string]string),
+		imported: make(map[string]map[string]time.Time),
+		censored: make(map[string]string),
+	}
+
+	log, err := o.Audit()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range log {
+		o.apply(entry)
+	}
+	o.merge()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return o, file.Close()
+}
+
+// OnChange registers f to be called after every change to the blacklist.
+func (o *BlacklistOverlay) OnChange(f func()) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.onChange = append(o.onChange, f)
+}
+
+// apply replays entry. The time an entry was first added is kept when its
+// reason changes or when a newer list of the same issuer still holds it. The
+// caller must hold the lock.
+func (o *BlacklistOverlay) apply(entry AuditEntry) {
+	switch entry.Action {
+	case AuditAdd:
+		if _, ok := o.entries[entry.Txid]; !ok {
+			o.added[entry.Txid] = entry.Time
+		}
+		o.entries[entry.Txid] = entry.Reason
+	case AuditRemove:
+		delete(o.entries, entry.Txid)
+		delete(o.added, entry.Txid)
+	case AuditAllow:
+		o.allowed[entry.Txid] = entry.Reason
+	case AuditDisallow:
+		delete(o.allowed, entry.Txid)
+	case AuditSubscribe:
+		if _, ok := o.subs[entry.Issuer]; !ok {
+			o.subs[entry.Issuer] = nil
+		}
+		if entry.URL != "" {
+			o.urls[entry.Issuer] = entry.URL
+		} else {
+			delete(o.urls, entry.Issuer)
+		}
+	case AuditUnsubscribe:
+		delete(o.subs, entry.Issuer)
+		delete(o.urls, entry.Issuer)
+		delete(o.imported, entry.Issuer)
+	case AuditImport:
+		if _, ok := o.subs[entry.Issuer]; !ok {
+			return
+		}
+		o.subs[entry.Issuer] = entry.List
+		held := o.imported[entry.Issuer]
+		added := make(map[string]time.Time)
+		for _, e := range entry.List.Entries {
+			if t, ok := held[e.Txid]; ok {
+				added[e.Txid] = t
+			} else {
+				added[e.Txid] = entry.Time
+			}
+		}
+		o.imported[entry.Issuer] = added
+	}
+}
+
+// merge works out every bulletin that the overlay censors. The entries of the
+// operator come first, then the entries of the lists subscribed to unless the
+// operator allowed them. The caller must hold the lock.
+func (o *BlacklistOverlay) merge() {
+
+	issuers := []string{}
+	for issuer := range o.subs {
+		issuers = append(issuers, issuer)
+	}
+	sort.Strings(issuers)
+
+	o.censored = make(map[string]string)
+	for _, issuer := range issuers {
+		list := o.subs[issuer]
+		if list == nil {
+			continue
+		}
+		for _, entry := range list.Entries {
+			if _, ok := o.allowed[entry.Txid]; ok {
+				continue
+			}
+			if _, ok := o.censored[entry.Txid]; !ok {
+				o.censored[entry.Txid] = entry.Reason
+			}
+		}
+	}
+	for txid, reason := range o.entries {
+		o.censored[txid] = reason
+	}
+}
+
+// record appends entry to the audit log and then applies it. If check is not
+// nil it is called with the lock held first and the entry is dropped if it
+// fails.
+func (o *BlacklistOverlay) record(entry AuditEntry, check func() error) error {
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	if check != nil {
+		if err := check(); err != nil {
+			o.mu.Unlock()
+			return err
+		}
+	}
+	file, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+		if err == nil {
+			err = file.Sync()
+		}
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		o.apply(entry)
+		o.merge()
+	}
+	onChange := o.onChange
+	o.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	for _, f := range onChange {
+		f()
+	}
+	return nil
+}
+
+// Add censors the bulletin with txid on behalf of actor. The bulletin does not
+// need to be in the store yet.
+func (o *BlacklistOverlay) Add(actor, txid, reason string) (AuditEntry, error) {
+	entry := AuditEntry{Time: time.Now().UTC(), Actor: actor, Action: AuditAdd, Txid: txid, Reason: reason}
+	return entry, o.record(entry, nil)
+}
+
+// Remove lifts the censorship of a bulletin that was added to the overlay.
+// Entries of the store beneath cannot be removed.
+func (o *BlacklistOverlay) Remove(actor, txid string) (AuditEntry, error) {
+
+	entry := AuditEntry{Time: time.Now().UTC(), Actor: actor, Action: AuditRemove, Txid: txid}
+
+	blacklist, err := o.Store.GetJsonBlacklist()
+	if err != nil {
+		return entry, err
+	}
+
+	return entry, o.record(entry, func() error {
+		if _, ok := o.entries[txid]; ok {
+			return nil
+		}
+		for _, e := range blacklist {
+			if e.Txid == txid {
+				return errStoreEntry
+			}
+		}
+		return errNotListed
+	})
+}
+
+// Allow overrides the entries for txid in every list subscribed to. The
+// reason explains why the operator disagrees with them.
+func (o *BlacklistOverlay) Allow(actor, txid, reason string) (AuditEntry, error) {
+	entry := AuditEntry{Time: time.Now().UTC(), Actor: actor, Action: AuditAllow, Txid: txid, Reason: reason}
+	return entry, o.record(entry, nil)
+}
+
+// Disallow drops the override for txid.
+func (o *BlacklistOverlay) Disallow(actor, txid string) (AuditEntry, error) {
+	entry := AuditEntry{Time: time.Now().UTC(), Actor: actor, Action: AuditDisallow, Txid: txid}
+	return entry, o.record(entry, func() error {
+		if _, ok := o.allowed[txid]; !ok {
+			return errNotAllowed
+		}
+		return nil
+	})
+}
+
+// Subscribe lets the lists signed by issuer be imported. If url is not empty
+// the list of the issuer can be refreshed from it, which is usually the
+// blacklist/signed route of the issuer's api.
+func (o *BlacklistOverlay) Subscribe(actor, issuer, url string) (AuditEntry, error) {
+	entry := AuditEntry{Time: time.Now().UTC(), Actor: actor, Action: AuditSubscribe, Issuer: issuer, URL: url}
+	return entry, o.record(entry, nil)
+}
+
+// Unsubscribe drops the list of issuer along with every entry it held.
+func (o *BlacklistOverlay) Unsubscribe(actor, issuer string) (AuditEntry, error) {
+	entry := AuditEntry{Time: time.Now().UTC(), Actor: actor, Action: AuditUnsubscribe, Issuer: issuer}
+	return entry, o.record(entry, func() error {
+		if _, ok := o.subs[issuer]; !ok {
+			return errNotSubscribed
+		}
+		return nil
+	})
+}
+
+// Import replaces the list of an issuer that is subscribed to with list once
+// its signature has been checked. Lists whose sequence number is not past the
+// one held are refused.
+func (o *BlacklistOverlay) Import(actor string, list *SignedBlacklist, net *chaincfg.Params) (AuditEntry, error) {
+
+	entry := AuditEntry{Time: time.Now().UTC(), Actor: actor, Action: AuditImport, Issuer: list.Issuer, List: list}
+	if err := list.Verify(net); err != nil {
+		return entry, err
+	}
+
+	return entry, o.record(entry, func() error {
+		held, ok := o.subs[list.Issuer]
+		if !ok {
+			return errNotSubscribed
+		}
+		if held != nil && held.Sequence >= list.Sequence {
+			return errStaleList
+		}
+		return nil
+	})
+}
+
+// Refresh fetches the list of issuer from the url it was subscribed with and
+// imports it. A list that is no newer than the one held leaves the
+// subscription as it is and is not an error.
+func (o *BlacklistOverlay) Refresh(ctx context.Context, actor, issuer string, net *chaincfg.Params) (BlacklistSubscription, error) {
+
+	o.mu.RLock()
+	_, ok := o.subs[issuer]
+	url := o.urls[issuer]
+	o.mu.RUnlock()
+	if !ok {
+		return BlacklistSubscription{}, errNotSubscribed
+	}
+	if url == "" {
+		return BlacklistSubscription{}, errNoListURL
+	}
+
+	var list SignedBlacklist
+	if err := NewClientWith("", "", DefaultClientOptions).getUrl(ctx, url, &list); err != nil {
+		return BlacklistSubscription{}, err
+	}
+	if list.Issuer != issuer {
+		return BlacklistSubscription{}, errWrongIssuer
+	}
+	if _, err := o.Import(actor, &list, net); err != nil && err != errStaleList {
+		return BlacklistSubscription{}, err
+	}
+
+	return o.subscription(issuer), nil
+}
+
+// RefreshAll refreshes every subscription that has a url, returning the first
+// error met after trying each of them.
+func (o *BlacklistOverlay) RefreshAll(ctx context.Context, actor string, net *chaincfg.Params) error {
+
+	o.mu.RLock()
+	issuers := []string{}
+	for issuer := range o.urls {
+		issuers = append(issuers, issuer)
+	}
+	o.mu.RUnlock()
+	sort.Strings(issuers)
+
+	var first error
+	for _, issuer := range issuers {
+		if _, err := o.Refresh(ctx, actor, issuer, net); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// subscription returns the subscription to issuer as Subscriptions lists it.
+func (o *BlacklistOverlay) subscription(issuer string) BlacklistSubscription {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	sub := BlacklistSubscription{Issuer: issuer, URL: o.urls[issuer]}
+	if list := o.subs[issuer]; list != nil {
+		sub.Created, sub.Sequence, sub.Entries = list.Created, list.Sequence, len(list.Entries)
+	}
+	return sub
+}
+
+// Subscriptions returns the issuers that are subscribed to.
+func (o *BlacklistOverlay) Subscriptions() []BlacklistSubscription {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	subs := []BlacklistSubscription{}
+	for issuer, list := range o.subs {
+		sub := BlacklistSubscription{Issuer: issuer, URL: o.urls[issuer]}
+		if list != nil {
+			sub.Created, sub.Sequence, sub.Entries = list.Created, list.Sequence, len(list.Entries)
+		}
+		subs = append(subs, sub)
+	}
+	sort.Sort(subsByIssuer(subs))
+
+	return subs
+}
+
+// Provenance returns a record for every entry of the blacklist saying where it
+// came from and when it was added, sorted by txid. Entries of the store win as
+// they do in GetJsonBlacklist and carry no date.
+func (o *BlacklistOverlay) Provenance() ([]*CensorRecord, error) {
+
+	blacklist, err := o.Store.GetJsonBlacklist()
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	records := []*CensorRecord{}
+	for _, entry := range blacklist {
+		seen[entry.Txid] = true
+		records = append(records, &CensorRecord{Txid: entry.Txid, Reason: entry.Reason, Source: SourceStore})
+	}
+
+	issuers := o.issuers()
+	for txid, reason := range o.censored {
+		if !seen[txid] {
+			records = append(records, o.provenance(txid, reason, issuers))
+		}
+	}
+	sort.Sort(recordsByTxid(records))
+
+	return records, nil
+}
+
+// ProvenanceOf returns the record of the entry of the blacklist for txid as
+// Provenance would. It reports false when the bulletin is not censored.
+func (o *BlacklistOverlay) ProvenanceOf(txid string) (*CensorRecord, bool, error) {
+
+	blacklist, err := o.Store.GetJsonBlacklist()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, entry := range blacklist {
+		if entry.Txid == txid {
+			return &CensorRecord{Txid: txid, Reason: entry.Reason, Source: SourceStore}, true, nil
+		}
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	reason, ok := o.censored[txid]
+	if !ok {
+		return nil, false, nil
+	}
+	return o.provenance(txid, reason, o.issuers()), true, nil
+}
+
+// issuers returns the issuers subscribed to in order. The caller must hold the
+// lock.
+func (o *BlacklistOverlay) issuers() []string {
+	issuers := []string{}
+	for issuer := range o.subs {
+		issuers = append(issuers, issuer)
+	}
+	sort.Strings(issuers)
+	return issuers
+}
+
+// provenance returns the record of an entry the overlay censors. The caller
+// must hold the lock.
+func (o *BlacklistOverlay) provenance(txid, reason string, issuers []string) *CensorRecord {
+	record := &CensorRecord{Txid: txid, Reason: reason, Source: SourceLocal}
+	if added, ok := o.added[txid]; ok {
+		record.Added = added.Unix()
+		return record
+	}
+	// The first issuer in order is the one whose reason merge kept.
+	record.Source = SourceImported
+	for _, issuer := range issuers {
+		if added, ok := o.imported[issuer][txid]; ok {
+			record.Issuer, record.Added = issuer, added.Unix()
+			break
+		}
+	}
+	return record
+}
+
+type subsByIssuer []BlacklistSubscription
+
+func (s subsByIssuer) Len() int           { return len(s) }
+func (s subsByIssuer) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s subsByIssuer) Less(i, j int) bool { return s[i].Issuer < s[j].Issuer }
+
+// Audit returns every change ever made to the blacklist, oldest first.
+func (o *BlacklistOverlay) Audit() ([]AuditEntry, error) {
+
+	file, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	log := []AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		log = append(log, entry)
+	}
+
+	return log, scanner.Err()
+}
+
+// censor returns bltn as it should be served. The caller must hold the lock.
+func (o *BlacklistOverlay) censor(bltn *ombjson.JsonBltn) *ombjson.JsonBltn {
+	reason, ok := o.censored[bltn.Txid]
+	if !ok || bltn.BannedReason != "" {
+		return bltn
+	}
+
+	b := *bltn
+	b.Msg = ""
+	b.BannedReason = reason
+	return &b
+}
+
+func (o *BlacklistOverlay) censorAll(bltns []*ombjson.JsonBltn) []*ombjson.JsonBltn {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	censored := make([]*ombjson.JsonBltn, len(bltns))
+	for i, bltn := range bltns {
+		censored[i] = o.censor(bltn)
+	}
+	return censored
+}
+
+func (o *BlacklistOverlay) GetJsonBltn(txid string) (*ombjson.JsonBltn, error) {
+	o.mu.RLock()
+	_, ok := o.censored[txid]
+	o.mu.RUnlock()
+
+	bltn, err := o.Store.GetJsonBltn(txid)
+	if err == nil && ok {
+		return nil, pubrecdb.ErrBltnCensored
+	}
+	return bltn, err
+}
+
+func (o *BlacklistOverlay) GetJsonBlock(hash string) (*ombjson.JsonBlock, error) {
+	blk, err := o.Store.GetJsonBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &ombjson.JsonBlock{Head: blk.Head, Bltns: o.censorAll(blk.Bltns)}, nil
+}
+
+func (o *BlacklistOverlay) GetJsonAuthor(addr string) (*ombjson.AuthorResp, error) {
+	author, err := o.Store.GetJsonAuthor(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &ombjson.AuthorResp{Author: author.Author, Bltns: o.censorAll(author.Bltns)}, nil
+}
+
+func (o *BlacklistOverlay) GetWholeBoard(board string) (*ombjson.WholeBoard, error) {
+	whole, err := o.Store.GetWholeBoard(board)
+	if err != nil {
+		return nil, err
+	}
+	return &ombjson.WholeBoard{Summary: whole.Summary, Bltns: o.censorAll(whole.Bltns)}, nil
+}
+
+func (o *BlacklistOverlay) GetRecentConf(n int) ([]*ombjson.JsonBltn, error) {
+	bltns, err := o.Store.GetRecentConf(n)
+	if err != nil {
+		return nil, err
+	}
+	return o.censorAll(bltns), nil
+}
+
+func (o *BlacklistOverlay) GetUnconfirmed() ([]*ombjson.JsonBltn, error) {
+	bltns, err := o.Store.GetUnconfirmed()
+	if err != nil {
+		return nil, err
+	}
+	return o.censorAll(bltns), nil
+}
+
+// GetJsonBlacklist merges the entries of the overlay into the blacklist of the
+// store. Entries of the store win.
+func (o *BlacklistOverlay) GetJsonBlacklist() ([]*ombjson.BlacklistEntry, error) {
+
+	blacklist, err := o.Store.GetJsonBlacklist()
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, entry := range blacklist {
+		seen[entry.Txid] = true
+	}
+	for txid, reason := range o.censored {
+		if !seen[txid] {
+			blacklist = append(blacklist, &ombjson.BlacklistEntry{Txid: txid, Reason: reason})
+		}
+	}
+	sort.Sort(blacklistByTxid(blacklist))
+
+	return blacklist, nil
+}
+
+// OwnBlacklist returns the blacklist of the store along with the entries the
+// operator added. Entries imported from other issuers are left out since the
+// operator does not vouch for them.
+func (o *BlacklistOverlay) OwnBlacklist() ([]*ombjson.BlacklistEntry, error) {
+
+	blacklist, err := o.Store.GetJsonBlacklist()
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, entry := range blacklist {
+		seen[entry.Txid] = true
+	}
+	for txid, reason := range o.entries {
+		if !seen[txid] {
+			blacklist = append(blacklist, &ombjson.BlacklistEntry{Txid: txid, Reason: reason})
+		}
+	}
+	sort.Sort(blacklistByTxid(blacklist))
+
+	return blacklist, nil
+}
+
+type blacklistByTxid []*ombjson.BlacklistEntry
+
+func (s blacklistByTxid) Len() int           { return len(s) }
+func (s blacklistByTxid) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s blacklistByTxid) Less(i, j int) bool { return s[i].Txid < s[j].Txid }
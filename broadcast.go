@@ -0,0 +1,181 @@
+package ahimsarest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/soapboxsys/ombudslib/ombjson"
+	"github.com/soapboxsys/ombudslib/protocol/ombproto"
+)
+
+// The largest body that a posted bulletin may be sent in. Bitcoin nodes will
+// not relay a standard transaction larger than 100kB.
+const maxPostBytes = 200 << 10
+
+// A Broadcaster relays signed transactions to the bitcoin network.
+type Broadcaster interface {
+	Broadcast(tx *wire.MsgTx) error
+}
+
+// A FileBroadcaster queues every transaction it is given by appending it to a
+// file as a line of hex. It stands in for a node in tests and lets a separate
+// process relay the transactions.
+type FileBroadcaster struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileBroadcaster returns a broadcaster that queues transactions in the file
+// at path. The file is created if it does not exist.
+func NewFileBroadcaster(path string) *FileBroadcaster {
+	return &FileBroadcaster{path: path}
+}
+
+// Broadcast appends tx to the queue.
+func (b *FileBroadcaster) Broadcast(tx *wire.MsgTx) error {
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	file, err := os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(hex.EncodeToString(buf.Bytes()) + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Queued returns every transaction in the queue in the order they were
+// broadcast.
+func (b *FileBroadcaster) Queued() ([]*wire.MsgTx, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	file, err := os.Open(b.path)
+	if os.IsNotExist(err) {
+		return []*wire.MsgTx{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	txs := []*wire.MsgTx{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), 2*maxPostBytes+1)
+	for scanner.Scan() {
+		raw, err := hex.DecodeString(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		tx := wire.NewMsgTx()
+		if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+
+	return txs, scanner.Err()
+}
+
+// A PostBltnReq holds a fully signed bulletin as a hex encoded transaction.
+type PostBltnReq struct {
+	RawTx string `json:"rawTx"`
+}
+
+// A PostBltnResp holds a bulletin that has been relayed as it will be served
+// once the store has seen it.
+type PostBltnResp struct {
+	Txid string    `json:"txid"`
+	Bltn *Bulletin `json:"bltn"`
+}
+
+// decodeBulletin checks that raw is a transaction that holds a bulletin on net.
+func decodeBulletin(raw []byte, net *chaincfg.Params) (*wire.MsgTx, *ombproto.Bulletin, error) {
+
+	tx := wire.NewMsgTx()
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, nil, err
+	}
+
+	bltn, err := ombproto.NewBulletin(tx, nil, net)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tx, bltn, nil
+}
+
+// Handles the publication of a new bulletin. The body is a PostBltnReq that is
+// checked to hold a bulletin before it is handed to the broadcaster.
+func PostBulletinHandler(b Broadcaster, net *chaincfg.Params) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		if b == nil {
+			writeError(w, request, 501, "This api does not relay bulletins")
+			return
+		}
+
+		var req PostBltnReq
+		body := http.MaxBytesReader(w, request.Body, maxPostBytes)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			writeError(w, request, 400, "The body is not a valid request")
+			return
+		}
+
+		raw, err := hex.DecodeString(req.RawTx)
+		if err != nil || len(raw) == 0 {
+			writeError(w, request, 400, "rawTx is not hex")
+			return
+		}
+
+		tx, bltn, err := decodeBulletin(raw, net)
+		if err != nil {
+			writeError(w, request, 400, "rawTx is not a bulletin: "+err.Error())
+			return
+		}
+
+		// What the node said is no business of the client.
+		if err := b.Broadcast(tx); err != nil {
+			logError(request, err)
+			writeError(w, request, 502, "The bulletin could not be relayed")
+			return
+		}
+
+		ts := bltn.Timestamp
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		txid := tx.TxSha().String()
+		resp := PostBltnResp{
+			Txid: txid,
+			Bltn: &Bulletin{JsonBltn: &ombjson.JsonBltn{
+				Txid:      txid,
+				Board:     bltn.Board,
+				Author:    bltn.Author,
+				Msg:       bltn.Message,
+				Timestamp: ts.Unix(),
+			}},
+		}
+
+		writeJsonStatus(w, request, 202, resp)
+	}
+}
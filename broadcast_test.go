@@ -0,0 +1,174 @@
+package ahimsarest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/soapboxsys/ombudslib/protocol/ombproto"
+)
+
+// newSignedTx builds a transaction that spends a made up output with the
+// given outputs. The signature is never checked by the api.
+func newSignedTx(t *testing.T, outs []*wire.TxOut) *wire.MsgTx {
+
+	_, pub := btcec.PrivKeyFromBytes(btcec.S256(), bytes.Repeat([]byte{0x42}, 32))
+	sig := bytes.Repeat([]byte{0x30}, 71)
+	script, err := txscript.NewScriptBuilder().AddData(sig).AddData(pub.SerializeCompressed()).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := wire.ShaHash{0x01}
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prev, 0), script))
+	for _, out := range outs {
+		tx.AddTxOut(out)
+	}
+	return tx
+}
+
+func newBltnTx(t *testing.T, board, msg string) *wire.MsgTx {
+
+	bltn, err := ombproto.NewBulletinFromStr("", board, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outs, err := bltn.TxOuts(567, &chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSignedTx(t, outs)
+}
+
+func rawTx(tx *wire.MsgTx) string {
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func post(t *testing.T, url, body string) *http.Response {
+	res, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestPostBulletin(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ahimsarest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := NewFileBroadcaster(filepath.Join(dir, "queue"))
+	opts := DefaultOptions
+	opts.Broadcaster, opts.Net = b, &chaincfg.TestNet3Params
+
+	ts := httptest.NewServer(NewHandler("/", newTestMemStore(), opts))
+	defer ts.Close()
+
+	tx := newBltnTx(t, "ahimsa-dev", "Posted over http")
+	body, _ := json.Marshal(PostBltnReq{RawTx: rawTx(tx)})
+	res := post(t, ts.URL+"/bulletin", string(body))
+	defer res.Body.Close()
+
+	if res.StatusCode != 202 {
+		t.Fatalf("Posting a bulletin: got %d", res.StatusCode)
+	}
+	var resp PostBltnResp
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	txid := tx.TxSha().String()
+	if resp.Txid != txid || resp.Bltn.Txid != txid {
+		t.Errorf("Wrong txid %s wanted %s", resp.Txid, txid)
+	}
+	if resp.Bltn.Board != "ahimsa-dev" || resp.Bltn.Msg != "Posted over http" || resp.Bltn.Author == "" {
+		t.Errorf("Unexpected bulletin %+v", resp.Bltn)
+	}
+
+	queued, err := b.Queued()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].TxSha().String() != txid {
+		t.Errorf("The bulletin was not broadcast: %v", queued)
+	}
+
+	var badPostTests = []struct {
+		body       string
+		statuscode int
+	}{
+		{"not json", 400},
+		{`{"rawTx": "not hex"}`, 400},
+		{`{"rawTx": ""}`, 400},
+		{`{"rawTx": "deadbeef"}`, 400},
+		{`{"rawTx": "` + rawTx(newSignedTx(t, []*wire.TxOut{wire.NewTxOut(1000, []byte{0x51})})) + `"}`, 400},
+	}
+
+	for _, test := range badPostTests {
+		res := post(t, ts.URL+"/v1/bulletin", test.body)
+		res.Body.Close()
+		if res.StatusCode != test.statuscode {
+			t.Errorf("%s: got %d wanted %d", test.body, res.StatusCode, test.statuscode)
+		}
+	}
+
+	if queued, _ := b.Queued(); len(queued) != 1 {
+		t.Errorf("Bad bulletins were broadcast")
+	}
+}
+
+// Asserts that an api without a broadcaster refuses new bulletins.
+func TestPostBulletinDisabled(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	body, _ := json.Marshal(PostBltnReq{RawTx: rawTx(newBltnTx(t, "", "Nobody will see this"))})
+	res := post(t, ts.URL+"/bulletin", string(body))
+	res.Body.Close()
+
+	if res.StatusCode != 501 {
+		t.Errorf("Got %d wanted 501", res.StatusCode)
+	}
+}
+
+// A failingBroadcaster fails with an error that must never reach clients.
+type failingBroadcaster struct{}
+
+func (failingBroadcaster) Broadcast(tx *wire.MsgTx) error {
+	return errors.New("rpc 10.0.0.7:18332: connection refused")
+}
+
+func TestPostBulletinRelayFailed(t *testing.T) {
+
+	opts := DefaultOptions
+	opts.Broadcaster, opts.Net = failingBroadcaster{}, &chaincfg.TestNet3Params
+	ts := httptest.NewServer(NewHandler("/", newTestMemStore(), opts))
+	defer ts.Close()
+
+	body, _ := json.Marshal(PostBltnReq{RawTx: rawTx(newBltnTx(t, "", "Nobody will see this"))})
+	res := post(t, ts.URL+"/v1/bulletin", string(body))
+	defer res.Body.Close()
+
+	blob, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != 502 || strings.Contains(string(blob), "10.0.0.7") {
+		t.Errorf("Got %d with body %s", res.StatusCode, blob)
+	}
+}
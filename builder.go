@@ -0,0 +1,343 @@
+package ahimsarest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/soapboxsys/ombudslib/protocol/ombproto"
+)
+
+const (
+	// The amount in satoshis that each output holding the bulletin carries
+	// unless the client asks for more.
+	defaultBurn = 567
+	// The fee rate in satoshis per byte used when the client does not give one.
+	defaultFeeRate = 10
+	// Change below this many satoshis is given to the miners instead.
+	dustLimit = 546
+	// The size of the signature script that spends a pay to pubkey hash output
+	// with a compressed key.
+	p2pkhSigScriptSize = 107
+	// The size of a pay to pubkey hash output.
+	p2pkhOutSize = 34
+)
+
+var errInsufficientFunds = errors.New("The outputs do not cover the bulletin and its fee")
+
+// A Utxo is an unspent output of the author that can fund a bulletin. Signers
+// check the amounts of what they spend against the transactions that created
+// it, so unless the api has a TxSource those are sent along as RawTx in hex.
+type Utxo struct {
+	Txid  string `json:"txid"`
+	Vout  uint32 `json:"vout"`
+	Value int64  `json:"value"`
+	RawTx string `json:"rawTx,omitempty"`
+}
+
+// A TxSource looks up the transactions that the outputs spent by built
+// bulletins were created by. FetchTx returns ErrTxNotFound for transactions
+// that do not exist, any other error is a failure of the source itself.
+type TxSource interface {
+	FetchTx(hash *wire.ShaHash) (*wire.MsgTx, error)
+}
+
+// ErrTxNotFound is returned by a TxSource that knows of no such transaction.
+var ErrTxNotFound = errors.New("Transaction not found")
+
+// A txSourceError is a TxSource that failed, which is no fault of the client.
+type txSourceError struct {
+	txid string
+	err  error
+}
+
+func (e *txSourceError) Error() string {
+	return fmt.Sprintf("Looking up %s: %s", e.txid, e.err)
+}
+
+// A BuildBltnReq asks for a bulletin to be built. Utxos are spent in the order
+// given until the bulletin and its fee are covered. Change goes back to the
+// author unless a Change address is given.
+type BuildBltnReq struct {
+	Board   string `json:"board"`
+	Msg     string `json:"msg"`
+	Author  string `json:"author"`
+	Utxos   []Utxo `json:"utxos"`
+	FeeRate int64  `json:"feeRate,omitempty"`
+	Burn    int64  `json:"burn,omitempty"`
+	Change  string `json:"change,omitempty"`
+}
+
+// A BuildBltnResp holds an unsigned bulletin both as a raw transaction and as a
+// PSBT. Size and Fee are estimates for the transaction once it is signed.
+type BuildBltnResp struct {
+	RawTx   string `json:"rawTx"`
+	Psbt    string `json:"psbt"`
+	Inputs  []Utxo `json:"inputs"`
+	Size    int    `json:"size"`
+	Fee     int64  `json:"fee"`
+	FeeRate int64  `json:"feeRate"`
+	Burned  int64  `json:"burned"`
+	Change  int64  `json:"change"`
+}
+
+// An unsignedBltn is a bulletin transaction along with the outputs it spends.
+type unsignedBltn struct {
+	tx      *wire.MsgTx
+	spent   []*wire.TxOut
+	inputs  []Utxo
+	size    int
+	fee     int64
+	feeRate int64
+	burned  int64
+	change  int64
+}
+
+// buildBulletin funds the bulletin outputs with utxos. Every utxo must pay to
+// author since the bulletin is attributed to whoever signs its first input.
+func buildBulletin(req *BuildBltnReq, net *chaincfg.Params) (*unsignedBltn, error) {
+
+	author, err := btcutil.DecodeAddress(req.Author, net)
+	if err != nil || !author.IsForNet(net) {
+		return nil, errors.New("author is not a valid address")
+	}
+	change := author
+	if req.Change != "" {
+		change, err = btcutil.DecodeAddress(req.Change, net)
+		if err != nil || !change.IsForNet(net) {
+			return nil, errors.New("change is not a valid address")
+		}
+	}
+	pkScript, err := txscript.PayToAddrScript(author)
+	if err != nil {
+		return nil, err
+	}
+	changeScript, err := txscript.PayToAddrScript(change)
+	if err != nil {
+		return nil, err
+	}
+
+	feeRate, burn := req.FeeRate, req.Burn
+	if feeRate == 0 {
+		feeRate = defaultFeeRate
+	}
+	if burn == 0 {
+		burn = defaultBurn
+	}
+	if feeRate < 0 || burn < 0 {
+		return nil, errors.New("feeRate and burn cannot be negative")
+	}
+
+	bltn, err := ombproto.NewBulletinFromStr(req.Author, req.Board, req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	outs, err := bltn.TxOuts(burn, net)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &unsignedBltn{tx: wire.NewMsgTx(), feeRate: feeRate}
+	for _, out := range outs {
+		b.tx.AddTxOut(out)
+		b.burned += out.Value
+	}
+
+	var total int64
+	for _, utxo := range req.Utxos {
+		hash, err := wire.NewShaHashFromStr(utxo.Txid)
+		if err != nil || utxo.Value <= 0 {
+			return nil, errors.New("utxos must have a txid and a value")
+		}
+		b.tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, utxo.Vout), nil))
+		b.spent = append(b.spent, wire.NewTxOut(utxo.Value, pkScript))
+		b.inputs = append(b.inputs, utxo)
+		total += utxo.Value
+
+		// The signature scripts are empty until the transaction is signed.
+		size := b.tx.SerializeSize() + len(b.tx.TxIn)*p2pkhSigScriptSize
+		withChange := int64(size+p2pkhOutSize) * feeRate
+		if left := total - b.burned - withChange; left >= dustLimit {
+			b.tx.AddTxOut(wire.NewTxOut(left, changeScript))
+			b.size, b.fee, b.change = size+p2pkhOutSize, withChange, left
+			return b, nil
+		}
+		if fee := int64(size) * feeRate; total-b.burned >= fee {
+			b.size, b.fee = size, total-b.burned
+			return b, nil
+		}
+	}
+
+	return nil, errInsufficientFunds
+}
+
+// writeVarInt writes n in the compact size encoding used by bitcoin.
+func writeVarInt(w io.Writer, n uint64) {
+	var buf [9]byte
+	switch {
+	case n < 0xfd:
+		w.Write([]byte{byte(n)})
+	case n <= 0xffff:
+		buf[0] = 0xfd
+		binary.LittleEndian.PutUint16(buf[1:], uint16(n))
+		w.Write(buf[:3])
+	case n <= 0xffffffff:
+		buf[0] = 0xfe
+		binary.LittleEndian.PutUint32(buf[1:], uint32(n))
+		w.Write(buf[:5])
+	default:
+		buf[0] = 0xff
+		binary.LittleEndian.PutUint64(buf[1:], n)
+		w.Write(buf[:])
+	}
+}
+
+func writePsbtPair(w *bytes.Buffer, key, value []byte) {
+	writeVarInt(w, uint64(len(key)))
+	w.Write(key)
+	writeVarInt(w, uint64(len(value)))
+	w.Write(value)
+}
+
+// prevTxs returns the transactions that created the outputs b spends, either
+// as sent by the client or as looked up in src. Each must hold the output it
+// was given for.
+func (b *unsignedBltn) prevTxs(src TxSource) ([]*wire.MsgTx, error) {
+
+	prevs := make([]*wire.MsgTx, len(b.inputs))
+	for i, utxo := range b.inputs {
+		hash := &b.tx.TxIn[i].PreviousOutPoint.Hash
+
+		prev := wire.NewMsgTx()
+		switch {
+		case utxo.RawTx != "":
+			raw, err := hex.DecodeString(utxo.RawTx)
+			if err != nil {
+				return nil, fmt.Errorf("The rawTx of %s is not hex", utxo.Txid)
+			}
+			if err := prev.Deserialize(bytes.NewReader(raw)); err != nil {
+				return nil, fmt.Errorf("The rawTx of %s is not a transaction", utxo.Txid)
+			}
+		case src != nil:
+			var err error
+			prev, err = src.FetchTx(hash)
+			if err == ErrTxNotFound {
+				return nil, fmt.Errorf("The transaction %s could not be found", utxo.Txid)
+			}
+			if err != nil {
+				return nil, &txSourceError{txid: utxo.Txid, err: err}
+			}
+		default:
+			return nil, fmt.Errorf("The transaction %s must be sent as the rawTx of its utxo", utxo.Txid)
+		}
+
+		if prev.TxSha() != *hash {
+			return nil, fmt.Errorf("The rawTx of %s is a different transaction", utxo.Txid)
+		}
+		spent := b.spent[i]
+		if int(utxo.Vout) >= len(prev.TxOut) || prev.TxOut[utxo.Vout].Value != spent.Value ||
+			!bytes.Equal(prev.TxOut[utxo.Vout].PkScript, spent.PkScript) {
+			return nil, fmt.Errorf("Output %d of %s does not pay %d to the author", utxo.Vout, utxo.Txid, utxo.Value)
+		}
+		prevs[i] = prev
+	}
+
+	return prevs, nil
+}
+
+// psbt encodes b as a partially signed bitcoin transaction as described in
+// BIP 174. The inputs of bulletins do not spend segwit outputs, so each one
+// carries the whole transaction it spends as a non witness utxo for the signer
+// to check the amounts against.
+func (b *unsignedBltn) psbt(prevs []*wire.MsgTx) ([]byte, error) {
+
+	var tx bytes.Buffer
+	if err := b.tx.Serialize(&tx); err != nil {
+		return nil, err
+	}
+
+	var w bytes.Buffer
+	w.Write([]byte{'p', 's', 'b', 't', 0xff})
+	writePsbtPair(&w, []byte{0x00}, tx.Bytes())
+	w.WriteByte(0x00)
+
+	for _, prev := range prevs {
+		var utxo bytes.Buffer
+		if err := prev.Serialize(&utxo); err != nil {
+			return nil, err
+		}
+
+		writePsbtPair(&w, []byte{0x00}, utxo.Bytes())
+		w.WriteByte(0x00)
+	}
+	for range b.tx.TxOut {
+		w.WriteByte(0x00)
+	}
+
+	return w.Bytes(), nil
+}
+
+// Builds an unsigned bulletin for clients without a wallet of their own. The
+// body is a BuildBltnReq. The transaction that is returned can be signed and
+// then posted to the bulletin endpoint. The transactions that the spent utxos
+// were created by are looked up in src when the client did not send them.
+func BuildBulletinHandler(net *chaincfg.Params, src TxSource) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		var req BuildBltnReq
+		body := http.MaxBytesReader(w, request.Body, maxPostBytes)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			writeError(w, request, 400, "The body is not a valid request")
+			return
+		}
+
+		b, err := buildBulletin(&req, net)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+
+		var raw bytes.Buffer
+		if err := b.tx.Serialize(&raw); err != nil {
+			serverError(w, request, err)
+			return
+		}
+		prevs, err := b.prevTxs(src)
+		var srcErr *txSourceError
+		if errors.As(err, &srcErr) {
+			logError(request, err)
+			writeError(w, request, 502, "The spent transactions could not be looked up")
+			return
+		}
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+		psbt, err := b.psbt(prevs)
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeJson(w, request, BuildBltnResp{
+			RawTx:   hex.EncodeToString(raw.Bytes()),
+			Psbt:    base64.StdEncoding.EncodeToString(psbt),
+			Inputs:  b.inputs,
+			Size:    b.size,
+			Fee:     b.fee,
+			FeeRate: b.feeRate,
+			Burned:  b.burned,
+			Change:  b.change,
+		})
+	}
+}
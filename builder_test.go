@@ -0,0 +1,223 @@
+package ahimsarest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	testAuthor = "mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c"
+	testUtxo   = "f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf"
+)
+
+// newPrevTx returns a transaction whose outputs pay values to the test author
+// along with its hex.
+func newPrevTx(t *testing.T, values ...int64) (*wire.MsgTx, string) {
+	addr, err := btcutil.DecodeAddress(testAuthor, &chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, _ := txscript.PayToAddrScript(addr)
+
+	hash, _ := wire.NewShaHashFromStr(testUtxo)
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, 0), nil))
+	for _, value := range values {
+		tx.AddTxOut(wire.NewTxOut(value, pkScript))
+	}
+
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	return tx, hex.EncodeToString(buf.Bytes())
+}
+
+// A mapTxSource holds the transactions it can find.
+type mapTxSource map[wire.ShaHash]*wire.MsgTx
+
+func (m mapTxSource) FetchTx(hash *wire.ShaHash) (*wire.MsgTx, error) {
+	if tx, ok := m[*hash]; ok {
+		return tx, nil
+	}
+	return nil, ErrTxNotFound
+}
+
+// A downTxSource stands for a node that cannot be reached.
+type downTxSource struct{}
+
+func (downTxSource) FetchTx(hash *wire.ShaHash) (*wire.MsgTx, error) {
+	return nil, errors.New("dial tcp 10.0.0.7:18332: connection refused")
+}
+
+func TestBuildBulletin(t *testing.T) {
+
+	opts := DefaultOptions
+	opts.Net = &chaincfg.TestNet3Params
+	ts := httptest.NewServer(NewHandler("/", NewMemStore(), opts))
+	defer ts.Close()
+
+	prev, rawPrev := newPrevTx(t, 0, 20000, 100000)
+	txid := prev.TxSha().String()
+	req := BuildBltnReq{
+		Board:  "ahimsa-dev",
+		Msg:    "Built by the api",
+		Author: testAuthor,
+		Utxos:  []Utxo{{Txid: txid, Vout: 1, Value: 20000, RawTx: rawPrev}, {Txid: txid, Vout: 2, Value: 100000, RawTx: rawPrev}},
+	}
+	body, _ := json.Marshal(req)
+	res := post(t, ts.URL+"/bulletin/build", string(body))
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Building a bulletin: got %d", res.StatusCode)
+	}
+	var resp BuildBltnResp
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	var total int64
+	for _, utxo := range resp.Inputs {
+		total += utxo.Value
+	}
+	if resp.Burned+resp.Fee+resp.Change != total {
+		t.Errorf("%d burned + %d fee + %d change does not add up to %d", resp.Burned, resp.Fee, resp.Change, total)
+	}
+	if resp.FeeRate != defaultFeeRate || resp.Fee < int64(resp.Size)*resp.FeeRate {
+		t.Errorf("A fee of %d is too low for %d bytes", resp.Fee, resp.Size)
+	}
+
+	raw, err := hex.DecodeString(resp.RawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := wire.NewMsgTx()
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.TxIn) != len(resp.Inputs) || resp.Change == 0 {
+		t.Errorf("Spent %d inputs with %d change", len(tx.TxIn), resp.Change)
+	}
+
+	psbt, err := base64.StdEncoding.DecodeString(resp.Psbt)
+	if err != nil || !bytes.HasPrefix(psbt, []byte("psbt\xff")) {
+		t.Errorf("Not a psbt: %s", resp.Psbt)
+	}
+	// Every input carries the transaction it spends as a non witness utxo.
+	var prevBuf, pair bytes.Buffer
+	prev.Serialize(&prevBuf)
+	writePsbtPair(&pair, []byte{0x00}, prevBuf.Bytes())
+	if n := bytes.Count(psbt, pair.Bytes()); n != len(tx.TxIn) {
+		t.Errorf("%d of %d inputs carry the transaction they spend", n, len(tx.TxIn))
+	}
+
+	// Once signed the transaction must hold the bulletin.
+	signed := newSignedTx(t, tx.TxOut)
+	var buf bytes.Buffer
+	signed.Serialize(&buf)
+	if _, bltn, err := decodeBulletin(buf.Bytes(), opts.Net); err != nil || bltn.Message != req.Msg {
+		t.Errorf("The built transaction does not hold the bulletin: %v", err)
+	}
+}
+
+func TestBuildBulletinFunding(t *testing.T) {
+
+	net := &chaincfg.TestNet3Params
+	req := &BuildBltnReq{Msg: "Funding", Author: testAuthor}
+
+	// Without any utxos nothing can be built.
+	if _, err := buildBulletin(req, net); err != errInsufficientFunds {
+		t.Errorf("Got %v wanted %v", err, errInsufficientFunds)
+	}
+
+	// Find what the bulletin costs without change, then leave less than dust.
+	req.Utxos = []Utxo{{Txid: testUtxo, Value: 1e8}}
+	b, err := buildBulletin(req, net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exact := b.burned + int64(b.size-p2pkhOutSize)*b.feeRate
+
+	req.Utxos = []Utxo{{Txid: testUtxo, Value: exact + dustLimit - 1}}
+	b, err = buildBulletin(req, net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.change != 0 || b.fee != dustLimit-1+exact-b.burned {
+		t.Errorf("Dust should go to the miners: change %d fee %d", b.change, b.fee)
+	}
+
+	req.Utxos = []Utxo{{Txid: testUtxo, Value: exact - 1}}
+	if _, err := buildBulletin(req, net); err != errInsufficientFunds {
+		t.Errorf("Got %v wanted %v", err, errInsufficientFunds)
+	}
+
+	var badBuildTests = []*BuildBltnReq{
+		{Msg: "No author", Utxos: req.Utxos},
+		{Msg: "Bad change", Author: testAuthor, Change: "nope", Utxos: req.Utxos},
+		{Msg: "Bad utxo", Author: testAuthor, Utxos: []Utxo{{Txid: "beef", Value: 1e8}}},
+		{Msg: "Bad fee", Author: testAuthor, FeeRate: -1, Utxos: req.Utxos},
+	}
+	for _, test := range badBuildTests {
+		if _, err := buildBulletin(test, net); err == nil {
+			t.Errorf("%s: expected an error", test.Msg)
+		}
+	}
+}
+
+func TestBuildBulletinPrevTxs(t *testing.T) {
+
+	prev, rawPrev := newPrevTx(t, 100000)
+	txid := prev.TxSha().String()
+	other, _ := newPrevTx(t, 100000, 1)
+
+	opts := DefaultOptions
+	opts.Net = &chaincfg.TestNet3Params
+	ts := httptest.NewServer(NewHandler("/", NewMemStore(), opts))
+	defer ts.Close()
+	opts.TxSource = mapTxSource{prev.TxSha(): prev}
+	withSrc := httptest.NewServer(NewHandler("/", NewMemStore(), opts))
+	defer withSrc.Close()
+	opts.TxSource = downTxSource{}
+	down := httptest.NewServer(NewHandler("/", NewMemStore(), opts))
+	defer down.Close()
+
+	var prevTxTests = []struct {
+		url    string
+		utxo   Utxo
+		status int
+	}{
+		{ts.URL, Utxo{Txid: txid, Value: 100000, RawTx: rawPrev}, 200},
+		// Nothing to embed in the psbt.
+		{ts.URL, Utxo{Txid: txid, Value: 100000}, 400},
+		{ts.URL, Utxo{Txid: txid, Value: 100000, RawTx: "nothex"}, 400},
+		{ts.URL, Utxo{Txid: other.TxSha().String(), Value: 100000, RawTx: rawPrev}, 400},
+		{ts.URL, Utxo{Txid: txid, Value: 200000, RawTx: rawPrev}, 400},
+		{ts.URL, Utxo{Txid: txid, Vout: 1, Value: 100000, RawTx: rawPrev}, 400},
+		{withSrc.URL, Utxo{Txid: txid, Value: 100000}, 200},
+		{withSrc.URL, Utxo{Txid: other.TxSha().String(), Value: 100000}, 400},
+		{down.URL, Utxo{Txid: txid, Value: 100000}, 502},
+		// What the client sent is used without asking the source.
+		{down.URL, Utxo{Txid: txid, Value: 100000, RawTx: rawPrev}, 200},
+	}
+
+	for _, test := range prevTxTests {
+		req := BuildBltnReq{Msg: "Spends", Author: testAuthor, Utxos: []Utxo{test.utxo}}
+		body, _ := json.Marshal(req)
+		res := post(t, test.url+"/bulletin/build", string(body))
+		res.Body.Close()
+
+		if res.StatusCode != test.status {
+			t.Errorf("Spending %+v: got %d wanted %d", test.utxo, res.StatusCode, test.status)
+		}
+	}
+}
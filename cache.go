@@ -0,0 +1,265 @@
+package ahimsarest
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// CacheStats are the counters of a ResponseCache. They are served as part of
+// the response of the StatusHandler.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Purges    uint64 `json:"purges"`
+	Entries   int    `json:"entries"`
+	Bytes     int    `json:"bytes"`
+	MaxBytes  int    `json:"maxBytes"`
+}
+
+// The state of the store that every cached response depends on. The epoch
+// moves forward on every purge.
+type cacheGen struct {
+	status ombjson.Status
+	unconf int
+	epoch  uint64
+}
+
+type cacheEntry struct {
+	key    string
+	header http.Header
+	body   []byte
+}
+
+func (e *cacheEntry) size() int {
+	n := len(e.key) + len(e.body)
+	for k, vs := range e.header {
+		n += len(k)
+		for _, v := range vs {
+			n += len(v)
+		}
+	}
+	return n
+}
+
+// A ResponseCache is a read through cache of successful responses keyed on the
+// requested host and uri. Every entry is dropped as soon as the store reports a new chain
+// tip or a change in the number of unconfirmed bulletins. Least recently used
+// entries are evicted to stay within the memory limit.
+type ResponseCache struct {
+	db       Store
+	maxBytes int
+	check    time.Duration
+	// Called whenever the cache sees the store change.
+	onChange func()
+
+	mu       sync.Mutex
+	checked  time.Time
+	modified time.Time
+	gen      cacheGen
+	entries  map[string]*list.Element
+	lru      *list.List
+	stats    CacheStats
+}
+
+// NewResponseCache creates a cache in front of db that holds at most maxBytes
+// of responses. The store is asked for its state at most once per check, so a
+// response can be stale for that long.
+func NewResponseCache(db Store, maxBytes int, check time.Duration) *ResponseCache {
+	return &ResponseCache{
+		db:       db,
+		maxBytes: maxBytes,
+		check:    check,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		stats:    CacheStats{MaxBytes: maxBytes},
+	}
+}
+
+// Stats returns a snapshot of the cache's counters.
+func (c *ResponseCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// Purge drops every entry in the cache.
+func (c *ResponseCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purge()
+}
+
+// purge must be called with the lock held.
+func (c *ResponseCache) purge() {
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.stats.Bytes = 0
+	c.stats.Purges++
+	c.gen.epoch++
+	c.modified = time.Now()
+}
+
+// LastModified returns the last time the cache saw the store change or was
+// purged.
+func (c *ResponseCache) LastModified() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.modified
+}
+
+// validate purges the cache if the store has changed since the last check and
+// returns the current generation.
+func (c *ResponseCache) validate() (cacheGen, error) {
+	c.mu.Lock()
+	gen, fresh := c.gen, time.Since(c.checked) < c.check
+	c.mu.Unlock()
+	if fresh {
+		return gen, nil
+	}
+
+	status, err := c.db.GetDBStatus()
+	if err != nil {
+		return gen, err
+	}
+	unconf, err := c.db.GetUnconfirmed()
+	if err != nil {
+		return gen, err
+	}
+	gen = cacheGen{status: *status, unconf: len(unconf)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checked = time.Now()
+	gen.epoch = c.gen.epoch
+	if gen != c.gen {
+		c.gen = gen
+		c.purge()
+		if c.onChange != nil {
+			c.onChange()
+		}
+	}
+
+	return c.gen, nil
+}
+
+func (c *ResponseCache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+
+	return el.Value.(*cacheEntry), true
+}
+
+// put stores entry unless the cache has moved on from gen while the response
+// was being built.
+func (c *ResponseCache) put(entry *cacheEntry, gen cacheGen) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := entry.size()
+	if size > c.maxBytes || gen != c.gen {
+		return
+	}
+	if el, ok := c.entries[entry.key]; ok {
+		c.stats.Bytes -= el.Value.(*cacheEntry).size()
+		c.lru.Remove(el)
+	}
+
+	for c.stats.Bytes+size > c.maxBytes {
+		oldest := c.lru.Back()
+		old := oldest.Value.(*cacheEntry)
+		c.lru.Remove(oldest)
+		delete(c.entries, old.key)
+		c.stats.Bytes -= old.size()
+		c.stats.Evictions++
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.stats.Bytes += size
+}
+
+// recorder captures a response while it is written to the client. The handler
+// writes into headers of its own so that only those are cached and headers
+// that belong to the request, such as its id, are never replayed.
+type recorder struct {
+	http.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	for k, vs := range r.header {
+		r.ResponseWriter.Header()[k] = vs
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(200)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Wrap serves GET requests out of the cache and stores every successful
+// response of h.
+func (c *ResponseCache) Wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		if request.Method != "GET" {
+			h(w, request)
+			return
+		}
+		gen, err := c.validate()
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		// Responses such as feeds refer back to the host they were served on.
+		key := request.Host + request.URL.RequestURI()
+		if entry, ok := c.get(key); ok {
+			for k, vs := range entry.header {
+				w.Header()[k] = vs
+			}
+			w.Write(entry.body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, header: make(http.Header)}
+		h(rec, request)
+		if rec.status == 0 {
+			rec.WriteHeader(200)
+		}
+
+		if rec.status == 200 {
+			c.put(&cacheEntry{key: key, header: rec.header, body: rec.body.Bytes()}, gen)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package ahimsarest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+func get(t *testing.T, url string) string {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// Asserts that the cache serves repeated requests and is invalidated when a new
+// bulletin arrives.
+func TestResponseCache(t *testing.T) {
+
+	store := newTestMemStore()
+	cache := NewResponseCache(store, 1<<20, 0)
+	ts := httptest.NewServer(cache.Wrap(AllBoardsHandler(store)))
+	defer ts.Close()
+
+	first := get(t, ts.URL)
+	if second := get(t, ts.URL); second != first {
+		t.Errorf("Cached response differs:\n%s\n%s", first, second)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+
+	store.AddBltn(&ombjson.JsonBltn{Txid: "aa", Board: "a-new-board", Author: "mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c", Timestamp: 1415000000})
+
+	if third := get(t, ts.URL); !strings.Contains(third, "a-new-board") {
+		t.Errorf("Cache was not invalidated by a new bulletin:\n%s", third)
+	}
+	if stats := cache.Stats(); stats.Misses != 2 {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+
+	store := newTestMemStore()
+	cache := NewResponseCache(store, 1<<20, 0)
+	ts := httptest.NewServer(cache.Wrap(AllAuthorsHandler(store)))
+	defer ts.Close()
+
+	get(t, ts.URL+"?a=1")
+	size := cache.Stats().Bytes
+
+	// Only two responses of the same size fit.
+	cache.maxBytes = 2*size + 1
+	get(t, ts.URL+"?a=2")
+	get(t, ts.URL+"?a=3")
+
+	stats := cache.Stats()
+	if stats.Evictions != 1 || stats.Entries != 2 || stats.Bytes > cache.maxBytes {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+}
+
+// Asserts that a response served out of the cache carries the id of the request
+// it answers rather than the one of the request that filled the cache.
+func TestResponseCacheRequestID(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	for _, id := range []string{"aaaaaaaa", "bbbbbbbb"} {
+		req, _ := http.NewRequest("GET", ts.URL+"/v1/boards", nil)
+		req.Header.Set("X-Request-Id", id)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if got := res.Header.Get("X-Request-Id"); got != id {
+			t.Errorf("Request %s was answered with the id %s", id, got)
+		}
+		if ct := res.Header.Get("Content-Type"); ct == "" {
+			t.Errorf("Request %s was answered without a content type", id)
+		}
+	}
+}
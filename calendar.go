@@ -0,0 +1,134 @@
+package ahimsarest
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+var (
+	errBadDay  = errors.New("day must be given as YYYY-MM-DD or DD-MM-YYYY")
+	errBadZone = errors.New("tz must be a zone name like Europe/Berlin or an offset like +02:00")
+)
+
+// The layouts a day may be given in. The first one is ISO 8601 and the second
+// the format the api has always accepted.
+var dayLayouts = []string{"2006-01-02", "02-01-2006"}
+
+// A CalendarDay counts the blocks found on a day and the bulletins they
+// confirmed.
+type CalendarDay struct {
+	Day    string `json:"day"`
+	Blocks int    `json:"blocks"`
+	Bltns  uint64 `json:"bltns"`
+}
+
+// A Calendar counts the blocks and bulletins of every day of a month.
+type Calendar struct {
+	Year  int            `json:"year"`
+	Month int            `json:"month"`
+	Zone  string         `json:"tz"`
+	Days  []*CalendarDay `json:"days"`
+}
+
+// parseZone reads ?tz= from the request. Days start at midnight UTC without
+// it.
+func parseZone(request *http.Request) (*time.Location, error) {
+	s := request.URL.Query().Get("tz")
+	if s == "" {
+		return time.UTC, nil
+	}
+
+	if loc, err := time.LoadLocation(s); err == nil {
+		return loc, nil
+	}
+	for _, layout := range []string{"-07:00", "-0700"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			_, offset := t.Zone()
+			return time.FixedZone(s, offset), nil
+		}
+	}
+	return nil, errBadZone
+}
+
+// parseDay returns the start of the day s in loc.
+func parseDay(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range dayLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errBadDay
+}
+
+// blocksWithin returns the heads of the blocks found from start up to end
+// ordered by height. The store only lists blocks 24 hours at a time, so days
+// that do not start at midnight UTC or last longer take more than one lookup.
+func blocksWithin(db Store, start, end time.Time) ([]*ombjson.JsonBlkHead, error) {
+
+	heads := []*ombjson.JsonBlkHead{}
+	for d := start; d.Before(end); d = d.Add(24 * time.Hour) {
+		blks, err := db.GetBlocksByDay(d)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, blk := range blks {
+			if blk.Timestamp < end.Unix() {
+				heads = append(heads, blk)
+			}
+		}
+	}
+	sort.Sort(blksByKey(heads))
+
+	return heads, nil
+}
+
+// Handles requests for the number of blocks and bulletins on every day of
+// a month. Days are counted in the zone given by ?tz=.
+func CalendarHandler(db Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		vars := mux.Vars(request)
+		year, _ := strconv.Atoi(vars["year"])
+		month, err := strconv.Atoi(vars["month"])
+		if err != nil || month < 1 || month > 12 {
+			writeError(w, request, 400, "Not a valid month")
+			return
+		}
+
+		loc, err := parseZone(request)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+
+		first := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, loc)
+		next := first.AddDate(0, 1, 0)
+		heads, err := blocksWithin(db, first, next)
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		cal := &Calendar{Year: year, Month: month, Zone: loc.String(), Days: []*CalendarDay{}}
+		for d := first; d.Before(next); d = d.AddDate(0, 0, 1) {
+			cal.Days = append(cal.Days, &CalendarDay{Day: d.Format(dayLayouts[0])})
+		}
+		for _, head := range heads {
+			day := cal.Days[time.Unix(head.Timestamp, 0).In(loc).Day()-1]
+			day.Blocks++
+			day.Bltns += head.NumBltns
+		}
+
+		writeJson(w, request, cal)
+	}
+}
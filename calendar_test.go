@@ -0,0 +1,99 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+func TestBlockDays(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	var dayTests = []struct {
+		url        string
+		statuscode int
+		blocks     int
+	}{
+		{"/blocks/2014-11-01", 200, 4},
+		{"/blocks/01-11-2014", 200, 4},
+		{"/blocks/2014-11-1", 400, 0},
+		{"/blocks/2014-11-01?tz=America/New_York", 404, 0},
+		{"/blocks/2014-10-31?tz=America/New_York", 200, 4},
+		{"/blocks/2014-10-22", 200, 1},
+		{"/blocks/2014-10-23?tz=%2B05:00", 200, 1},
+		{"/blocks/2014-10-22?tz=%2B05:00", 404, 0},
+		{"/blocks/2014-13-01", 400, 0},
+		{"/blocks/31-02-2014", 400, 0},
+		{"/blocks/2014-11-01?tz=Mars/Olympus", 400, 0},
+		{"/v1/blocks/2014-11-01?tz=UTC", 200, 4},
+	}
+	for _, test := range dayTests {
+		res := doGet(t, ts.URL+test.url, nil)
+		if res.StatusCode != test.statuscode {
+			t.Errorf("%s: got %d wanted %d", test.url, res.StatusCode, test.statuscode)
+			continue
+		}
+		if test.statuscode != 200 {
+			continue
+		}
+		var heads []*ombjson.JsonBlkHead
+		if err := json.Unmarshal([]byte(get(t, ts.URL+test.url)), &heads); err != nil {
+			t.Fatal(err)
+		}
+		if len(heads) != test.blocks {
+			t.Errorf("%s: got %d blocks wanted %d", test.url, len(heads), test.blocks)
+		}
+	}
+}
+
+func TestCalendar(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	var calTests = []struct {
+		url    string
+		days   int
+		day    int
+		blocks int
+		bltns  uint64
+	}{
+		{"/calendar/2014/11", 30, 1, 4, 1},
+		{"/calendar/2014/11", 30, 13, 1, 1},
+		{"/calendar/2014/11", 30, 2, 0, 0},
+		{"/calendar/2014/10", 31, 22, 1, 1},
+		{"/calendar/2014/10?tz=Asia/Tokyo", 31, 23, 1, 1},
+		{"/v1/calendar/2014/02", 28, 1, 0, 0},
+	}
+	for _, test := range calTests {
+		var cal Calendar
+		if err := json.Unmarshal([]byte(get(t, ts.URL+test.url)), &cal); err != nil {
+			t.Fatal(err)
+		}
+		if len(cal.Days) != test.days {
+			t.Errorf("%s: got %d days wanted %d", test.url, len(cal.Days), test.days)
+			continue
+		}
+		if d := cal.Days[test.day-1]; d.Blocks != test.blocks || d.Bltns != test.bltns {
+			t.Errorf("%s: got %+v on day %d", test.url, d, test.day)
+		}
+	}
+
+	var statusTests = []struct {
+		url        string
+		statuscode int
+	}{
+		{"/calendar/2014/13", 400},
+		{"/calendar/2014/0", 400},
+		{"/calendar/2014/11?tz=bogus", 400},
+		{"/calendar/14/11", 404},
+	}
+	for _, test := range statusTests {
+		if res := doGet(t, ts.URL+test.url, nil); res.StatusCode != test.statuscode {
+			t.Errorf("%s: got %d wanted %d", test.url, res.StatusCode, test.statuscode)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package ahimsarest
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+var (
+	// The index is checked against the status of the db at most this often.
+	// A ResponseCache that sees the store change expires the index right away,
+	// so responses holding confirmations are never cached with a stale depth.
+	chainRefresh = time.Second
+	// No block can be older than the genesis block of bitcoin.
+	genesisTime = time.Unix(1231006505, 0).UTC()
+)
+
+var errBadRange = errors.New("from and to must be heights with from at most to")
+
+// utcDay returns the start of the UTC day holding the unix time ts.
+func utcDay(ts int64) time.Time {
+	t := time.Unix(ts, 0).UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// A ChainIndex finds the blocks of the public record by height. The store can
+// only list blocks by day, so the index walks back day by day from the latest
+// block until it has seen every block the store holds. Once built, the index
+// is only extended from the day before the tip it last saw.
+type ChainIndex struct {
+	db    Store
+	every time.Duration
+
+	mu       sync.Mutex
+	checked  time.Time
+	status   ombjson.Status
+	built    bool
+	byHash   map[string]*ombjson.JsonBlkHead
+	byHeight []*ombjson.JsonBlkHead
+	// The heights of the blocks on the main chain by hash. It is replaced
+	// rather than changed so that views can hold on to it.
+	heights map[string]uint64
+
+	// The blocks that left the main chain by hash and the reorgs that moved
+	// them, oldest first. reorgSeq counts every reorg ever recorded.
+	stale    map[string]*ombjson.JsonBlkHead
+	reorgs   []*Reorg
+	reorgSeq uint64
+	// The txids confirmed by the blocks near the tip by hash, so that the
+	// bulletins a reorg reverted are known once the store dropped the block.
+	confirmed map[string][]string
+}
+
+// NewChainIndex creates an index over db. Nothing is indexed until the first
+// lookup.
+func NewChainIndex(db Store) *ChainIndex {
+	return &ChainIndex{db: db, every: chainRefresh}
+}
+
+// expire makes the next lookup check the db for changes.
+func (ci *ChainIndex) expire() {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	ci.checked = time.Time{}
+}
+
+// refresh updates the index if the db has changed since it was last built.
+// The caller must hold the lock.
+func (ci *ChainIndex) refresh() error {
+
+	if ci.built && time.Since(ci.checked) < ci.every {
+		return nil
+	}
+
+	status, err := ci.db.GetDBStatus()
+	if err != nil {
+		return err
+	}
+	ci.checked = time.Now()
+	if ci.built && *status == ci.status {
+		return nil
+	}
+
+	// Timestamps of blocks may be off by a couple of hours, so the day before
+	// the latest block seen is walked again. Older blocks are kept as they are
+	// even if the store disagrees on how many it holds, otherwise every refresh
+	// would walk back to the genesis block.
+	floor := genesisTime
+	heads := make(map[string]*ombjson.JsonBlkHead)
+	if ci.built {
+		floor = utcDay(ci.status.LatestBlk).AddDate(0, 0, -1)
+		for hash, head := range ci.byHash {
+			if head.Timestamp < floor.Unix() {
+				heads[hash] = head
+			}
+		}
+	}
+	if err := ci.walk(status, floor, heads); err != nil {
+		return err
+	}
+	byHeight, heights := mainChain(heads)
+	reorgs, err := ci.reorged(heads, byHeight, heights)
+	if err != nil {
+		return err
+	}
+	confirmed, err := ci.nearTip(byHeight)
+	if err != nil {
+		return err
+	}
+
+	ci.byHash, ci.byHeight, ci.heights = heads, byHeight, heights
+	ci.status, ci.built, ci.confirmed = *status, true, confirmed
+	ci.record(reorgs)
+
+	return nil
+}
+
+// walk adds the heads of the blocks found from the day after the latest block
+// back to floor, stopping early once every block of the store is in heads.
+func (ci *ChainIndex) walk(status *ombjson.Status, floor time.Time, heads map[string]*ombjson.JsonBlkHead) error {
+
+	if status.BlkCount == 0 {
+		return nil
+	}
+
+	latest := utcDay(status.LatestBlk).AddDate(0, 0, 1)
+	for d := latest; !d.Before(floor) && uint64(len(heads)) < status.BlkCount; d = d.AddDate(0, 0, -1) {
+		blks, err := ci.db.GetBlocksByDay(d)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, blk := range blks {
+			heads[blk.Hash] = blk
+		}
+	}
+
+	return nil
+}
+
+// mainChain returns the blocks of heads on the main chain lowest first along
+// with their heights by hash. Where two blocks share a height the one the
+// block above points to wins.
+func mainChain(heads map[string]*ombjson.JsonBlkHead) ([]*ombjson.JsonBlkHead, map[string]uint64) {
+
+	sorted := make([]*ombjson.JsonBlkHead, 0, len(heads))
+	for _, head := range heads {
+		sorted = append(sorted, head)
+	}
+	sort.Sort(blksByKey(sorted))
+
+	// Walk down from the highest block following the previous hashes.
+	var byHeight []*ombjson.JsonBlkHead
+	prev := ""
+	for i := len(sorted) - 1; i >= 0; i-- {
+		head := sorted[i]
+		n := len(byHeight)
+		if n > 0 && byHeight[n-1].Height == head.Height {
+			if head.Hash == prev {
+				byHeight[n-1] = head
+			}
+			continue
+		}
+		if n > 0 {
+			prev = byHeight[n-1].PrevHash
+		}
+		byHeight = append(byHeight, head)
+	}
+	for i, j := 0, len(byHeight)-1; i < j; i, j = i+1, j-1 {
+		byHeight[i], byHeight[j] = byHeight[j], byHeight[i]
+	}
+
+	heights := make(map[string]uint64, len(byHeight))
+	for _, head := range byHeight {
+		heights[head.Hash] = head.Height
+	}
+
+	return byHeight, heights
+}
+
+// Tip returns the head of the highest block, or nil if the store holds none.
+func (ci *ChainIndex) Tip() (*ombjson.JsonBlkHead, error) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if err := ci.refresh(); err != nil {
+		return nil, err
+	}
+	if len(ci.byHeight) == 0 {
+		return nil, nil
+	}
+	return ci.byHeight[len(ci.byHeight)-1], nil
+}
+
+// Range returns the heads of the blocks the store holds with heights from
+// from to to inclusive, lowest first.
+func (ci *ChainIndex) Range(from, to uint64) ([]*ombjson.JsonBlkHead, error) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if err := ci.refresh(); err != nil {
+		return nil, err
+	}
+
+	start := sort.Search(len(ci.byHeight), func(i int) bool { return ci.byHeight[i].Height >= from })
+	heads := []*ombjson.JsonBlkHead{}
+	for _, head := range ci.byHeight[start:] {
+		if head.Height > to {
+			break
+		}
+		heads = append(heads, head)
+	}
+	return heads, nil
+}
+
+// Handles requests for the highest block in the public record.
+func TipHandler(ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		tip, err := ci.Tip()
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+		if tip == nil {
+			writeError(w, request, 404, "There are no blocks")
+			return
+		}
+
+		writeJson(w, request, tip)
+	}
+}
+
+// Handles requests for the block at a height. Only blocks that the public
+// record holds can be found.
+func BlockHeightHandler(db Store, ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		height, err := strconv.ParseUint(mux.Vars(request)["height"], 10, 64)
+		if err != nil {
+			writeError(w, request, 400, "Not a valid height")
+			return
+		}
+
+		heads, err := ci.Range(height, height)
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+		if len(heads) == 0 {
+			writeError(w, request, 404, "Block does not exist")
+			return
+		}
+
+		blockH, err := db.GetJsonBlock(heads[0].Hash)
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		v, err := ci.view()
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeJson(w, request, BlockResp{Head: blockH.Head, Bltns: v.bulletins(blockH.Bltns)})
+	}
+}
+
+// Handles requests for the heads of the blocks between the heights ?from= and
+// ?to=. Without to the range ends at the tip, so a from beyond the tip holds
+// nothing yet, and without from it holds the last defaultPageLimit heights.
+func BlockRangeHandler(ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		q := request.URL.Query()
+
+		tip, err := ci.Tip()
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		var to uint64
+		if tip != nil {
+			to = tip.Height
+		}
+		toTip := q.Get("to") == ""
+		if s := q.Get("to"); s != "" {
+			if to, err = strconv.ParseUint(s, 10, 64); err != nil {
+				writeError(w, request, 400, errBadRange.Error())
+				return
+			}
+		}
+
+		var from uint64
+		if to >= defaultPageLimit {
+			from = to - defaultPageLimit + 1
+		}
+		if s := q.Get("from"); s != "" {
+			if from, err = strconv.ParseUint(s, 10, 64); err != nil {
+				writeError(w, request, 400, errBadRange.Error())
+				return
+			}
+		}
+
+		if from > to && toTip {
+			writeJson(w, request, []*ombjson.JsonBlkHead{})
+			return
+		}
+		if from > to {
+			writeError(w, request, 400, errBadRange.Error())
+			return
+		}
+		if to-from >= maxPageLimit {
+			writeError(w, request, 400, "A range can span at most "+strconv.Itoa(maxPageLimit)+" heights")
+			return
+		}
+
+		heads, err := ci.Range(from, to)
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeJson(w, request, heads)
+	}
+}
@@ -0,0 +1,174 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+func TestBlockHeights(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	var tip ombjson.JsonBlkHead
+	if err := json.Unmarshal([]byte(get(t, ts.URL+"/tip")), &tip); err != nil {
+		t.Fatal(err)
+	}
+	if tip.Height != 307012 || tip.Hash != "00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff" {
+		t.Errorf("Unexpected tip %+v", tip)
+	}
+
+	var block ombjson.JsonBlock
+	if err := json.Unmarshal([]byte(get(t, ts.URL+"/block/height/304529")), &block); err != nil {
+		t.Fatal(err)
+	}
+	if block.Head.Hash != "00000000000016b6ff59b9fffcade68943bb02270b46d2a001054d95c56ca8ad" || len(block.Bltns) != 1 {
+		t.Errorf("Unexpected block %+v", block)
+	}
+
+	var rangeTests = []struct {
+		query   string
+		heights []uint64
+	}{
+		{"?from=305690&to=305700", []uint64{305694, 305698, 305699}},
+		{"?from=305699&to=305699", []uint64{305699}},
+		{"?from=307000", []uint64{307012}},
+		{"?to=304400&from=304000", nil},
+		// Nothing has been found beyond the tip yet.
+		{"?from=307100", nil},
+		{"", []uint64{307012}},
+	}
+	for _, test := range rangeTests {
+		var heads []*ombjson.JsonBlkHead
+		if err := json.Unmarshal([]byte(get(t, ts.URL+"/blocks"+test.query)), &heads); err != nil {
+			t.Fatal(err)
+		}
+		if len(heads) != len(test.heights) {
+			t.Errorf("%s: got %d heads wanted %v", test.query, len(heads), test.heights)
+			continue
+		}
+		for i, head := range heads {
+			if head.Height != test.heights[i] {
+				t.Errorf("%s: got height %d wanted %d", test.query, head.Height, test.heights[i])
+			}
+		}
+	}
+
+	var statusTests = []struct {
+		url        string
+		statuscode int
+	}{
+		{"/block/height/305700", 404},
+		{"/block/height/99999999999999999999", 404},
+		{"/blocks?from=10&to=5", 400},
+		{"/blocks?from=-1", 400},
+		{"/blocks?from=0&to=1000", 400},
+		{"/v1/tip", 200},
+		{"/v1/block/height/307012", 200},
+	}
+	for _, test := range statusTests {
+		if res := doGet(t, ts.URL+test.url, nil); res.StatusCode != test.statuscode {
+			t.Errorf("%s: got %d wanted %d", test.url, res.StatusCode, test.statuscode)
+		}
+	}
+
+	empty := httptest.NewServer(Handler("/", NewMemStore()))
+	defer empty.Close()
+	if res := doGet(t, empty.URL+"/tip", nil); res.StatusCode != 404 {
+		t.Errorf("An empty store should have no tip: %d", res.StatusCode)
+	}
+}
+
+// Asserts that the index follows new blocks and picks the chain the tip
+// builds on where blocks share a height.
+func TestChainIndexRefresh(t *testing.T) {
+
+	store := newTestMemStore()
+	ci := NewChainIndex(store)
+	ci.every = 0
+	if tip, err := ci.Tip(); err != nil || tip.Height != 307012 {
+		t.Fatalf("Unexpected tip %+v %v", tip, err)
+	}
+
+	tipHash := "00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff"
+	store.AddBlock(&ombjson.JsonBlkHead{Hash: "ff", PrevHash: "00", Timestamp: 1415862600, Height: 307013})
+	store.AddBlock(&ombjson.JsonBlkHead{Hash: "aa", PrevHash: tipHash, Timestamp: 1415862700, Height: 307013})
+	store.AddBlock(&ombjson.JsonBlkHead{Hash: "bb", PrevHash: "aa", Timestamp: 1415862800, Height: 307014})
+
+	heads, err := ci.Range(307012, 307014)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(heads) != 3 || heads[0].Hash != tipHash || heads[1].Hash != "aa" || heads[2].Hash != "bb" {
+		t.Errorf("Unexpected chain %+v", heads)
+	}
+}
+
+// A brokenCountStore claims to hold a block that cannot be found by day and
+// counts how many days were looked up.
+type brokenCountStore struct {
+	Store
+	days int
+}
+
+func (s *brokenCountStore) GetDBStatus() (*ombjson.Status, error) {
+	status, err := s.Store.GetDBStatus()
+	if err != nil {
+		return nil, err
+	}
+	status.BlkCount++
+	return status, nil
+}
+
+func (s *brokenCountStore) GetBlocksByDay(day time.Time) ([]*ombjson.JsonBlkHead, error) {
+	s.days++
+	return s.Store.GetBlocksByDay(day)
+}
+
+// Asserts that the index is extended from the last tip instead of being walked
+// back to the genesis block whenever the store changes.
+func TestChainIndexExtend(t *testing.T) {
+
+	mem := newTestMemStore()
+	store := &brokenCountStore{Store: mem}
+	ci := NewChainIndex(store)
+	ci.every = 0
+	if _, err := ci.Tip(); err != nil {
+		t.Fatal(err)
+	}
+
+	store.days = 0
+	tipHash := "00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff"
+	mem.AddBlock(&ombjson.JsonBlkHead{Hash: "aa", PrevHash: tipHash, Timestamp: 1415862700, Height: 307013})
+	if tip, err := ci.Tip(); err != nil || tip.Hash != "aa" {
+		t.Errorf("Unexpected tip %+v %v", tip, err)
+	}
+	if store.days > 3 {
+		t.Errorf("Looked up %d days to add a single block", store.days)
+	}
+}
+
+// Asserts that a cache that sees the store change brings the index up to date
+// before it serves anything.
+func TestChainIndexExpired(t *testing.T) {
+
+	store := newTestMemStore()
+	opts := DefaultOptions
+	opts.CacheCheck = 0
+	opts.ChainRefresh = time.Hour
+	ts := httptest.NewServer(NewHandler("/", store, opts))
+	defer ts.Close()
+
+	get(t, ts.URL+"/tip")
+	tipHash := "00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff"
+	store.AddBlock(&ombjson.JsonBlkHead{Hash: "aa", PrevHash: tipHash, Timestamp: 1415862700, Height: 307013})
+
+	var tip ombjson.JsonBlkHead
+	if err := json.Unmarshal([]byte(get(t, ts.URL+"/tip")), &tip); err != nil || tip.Hash != "aa" {
+		t.Errorf("Unexpected tip %+v %v", tip, err)
+	}
+}
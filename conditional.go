@@ -0,0 +1,113 @@
+package ahimsarest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The Cache-Control policies of the api. Blocks looked up by hash only stop
+// changing once they are buried too deep in the main chain for a reorg to
+// reach, lists of fresh bulletins change constantly.
+const (
+	cacheLong    = "public, max-age=86400"
+	cacheDefault = "public, max-age=60"
+	cacheShort   = "public, max-age=5"
+	cacheNone    = "no-cache"
+)
+
+// bufferedWriter holds a response back so that it can be validated before it
+// is sent.
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = 200
+	}
+	return b.body.Write(p)
+}
+
+// etagMatch reports whether etag is listed in an If-None-Match header. The
+// comparison is weak as RFC 7232 asks for.
+func etagMatch(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// conditional sets a strong ETag computed from the body of every successful
+// response of h along with the given Cache-Control policy, unless h chose a
+// policy of its own for the response. If modified is not
+// nil it reports when the store last changed and is served as Last-Modified.
+// Requests whose validators still match are answered with a 304.
+func conditional(policy string, modified func() time.Time, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		buf := &bufferedWriter{ResponseWriter: w}
+		h(buf, request)
+
+		if buf.status != 200 {
+			if buf.status != 0 {
+				w.WriteHeader(buf.status)
+			}
+			w.Write(buf.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(buf.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+		header := w.Header()
+		header.Set("ETag", etag)
+		if header.Get("Cache-Control") == "" {
+			header.Set("Cache-Control", policy)
+		}
+
+		var lastMod time.Time
+		if modified != nil {
+			lastMod = modified().UTC().Truncate(time.Second)
+		}
+		if !lastMod.IsZero() {
+			header.Set("Last-Modified", lastMod.Format(http.TimeFormat))
+		}
+
+		if request.Method == "GET" || request.Method == "HEAD" {
+			notModified := false
+			if inm := request.Header.Get("If-None-Match"); inm != "" {
+				notModified = etagMatch(inm, etag)
+			} else if ims := request.Header.Get("If-Modified-Since"); ims != "" && !lastMod.IsZero() {
+				t, err := http.ParseTime(ims)
+				notModified = err == nil && !lastMod.After(t)
+			}
+
+			if notModified {
+				header.Del("Content-Type")
+				header.Del("Content-Length")
+				w.WriteHeader(304)
+				return
+			}
+		}
+
+		w.Write(buf.body.Bytes())
+	}
+}
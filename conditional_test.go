@@ -0,0 +1,78 @@
+package ahimsarest
+
+import (
+	"net/http"
+	"testing"
+)
+
+func doGet(t *testing.T, url string, header map[string]string) *http.Response {
+	req, _ := http.NewRequest("GET", url, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+// Asserts that validators are set and honoured.
+func TestConditionalRequests(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	block := ts.URL + "/block/00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d"
+	res := doGet(t, block, nil)
+	etag, lastMod := res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+	if etag == "" || lastMod == "" {
+		t.Fatalf("Missing validators: %v", res.Header)
+	}
+	// The block is buried too deep for a reorg to reach.
+	if cc := res.Header.Get("Cache-Control"); cc != cacheLong {
+		t.Errorf("Wrong Cache-Control for a block: %s", cc)
+	}
+	head := doGet(t, ts.URL+"/blockhead/00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d", nil)
+	if cc := head.Header.Get("Cache-Control"); cc != cacheLong {
+		t.Errorf("Wrong Cache-Control for a block head: %s", cc)
+	}
+	// A reorg can still move the tip off the main chain.
+	for _, path := range []string{"/block/", "/blockhead/"} {
+		tip := doGet(t, ts.URL+path+"00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff", nil)
+		if cc := tip.Header.Get("Cache-Control"); cc != cacheDefault {
+			t.Errorf("Wrong Cache-Control for the tip at %s: %s", path, cc)
+		}
+	}
+
+	var conditionalTests = []struct {
+		header     map[string]string
+		statuscode int
+	}{
+		{map[string]string{"If-None-Match": etag}, 304},
+		{map[string]string{"If-None-Match": `"abc", W/` + etag}, 304},
+		{map[string]string{"If-None-Match": `"abc"`}, 200},
+		{map[string]string{"If-Modified-Since": lastMod}, 304},
+		{map[string]string{"If-Modified-Since": "Sat, 01 Nov 2014 00:00:00 GMT"}, 200},
+		// If-None-Match wins over If-Modified-Since
+		{map[string]string{"If-None-Match": `"abc"`, "If-Modified-Since": lastMod}, 200},
+	}
+	for _, testCase := range conditionalTests {
+		res := doGet(t, block, testCase.header)
+		if res.StatusCode != testCase.statuscode {
+			t.Errorf("With %v expected %d, got %d", testCase.header, testCase.statuscode, res.StatusCode)
+		}
+	}
+
+	res = doGet(t, ts.URL+"/unconfirmed", nil)
+	if cc := res.Header.Get("Cache-Control"); cc != cacheShort {
+		t.Errorf("Wrong Cache-Control for unconfirmed bulletins: %s", cc)
+	}
+
+	// Errors are passed through without validators.
+	res = doGet(t, ts.URL+"/board/this-One-Isnt-Real", nil)
+	if res.StatusCode != 404 || res.Header.Get("ETag") != "" {
+		t.Errorf("Error response was changed: %d %v", res.StatusCode, res.Header)
+	}
+}
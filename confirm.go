@@ -0,0 +1,112 @@
+package ahimsarest
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+var errBadMinConf = errors.New("minconf must be a number of confirmations")
+
+// A Bulletin is a bulletin as the api serves it, along with how deeply it is
+// buried in the main chain. Unconfirmed bulletins have no confirmations.
+type Bulletin struct {
+	*ombjson.JsonBltn
+	Confirmations uint64 `json:"confirmations"`
+	OnMainChain   bool   `json:"onMainChain"`
+}
+
+// A BlockResp is a block along with the bulletins it confirmed.
+type BlockResp struct {
+	Head  *ombjson.JsonBlkHead `json:"head"`
+	Bltns []*Bulletin          `json:"bltns"`
+}
+
+// A BoardResp is a board along with every bulletin posted to it.
+type BoardResp struct {
+	Summary *ombjson.BoardSummary `json:"summary"`
+	Bltns   []*Bulletin           `json:"bltns"`
+}
+
+// An AuthorResp is an author along with every bulletin they wrote.
+type AuthorResp struct {
+	Author *ombjson.AuthorSummary `json:"author"`
+	Bltns  []*Bulletin            `json:"bltns"`
+}
+
+// A chainView is a snapshot of the main chain that works out the depth of
+// bulletins.
+type chainView struct {
+	tip     uint64
+	heights map[string]uint64
+}
+
+// view returns a snapshot of the main chain as the index last saw it.
+func (ci *ChainIndex) view() (*chainView, error) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if err := ci.refresh(); err != nil {
+		return nil, err
+	}
+	return ci.snapshot(), nil
+}
+
+// snapshot returns the main chain as it is indexed. The caller must hold the
+// lock.
+func (ci *ChainIndex) snapshot() *chainView {
+	v := &chainView{heights: ci.heights}
+	if n := len(ci.byHeight); n > 0 {
+		v.tip = ci.byHeight[n-1].Height
+	}
+	return v
+}
+
+// bulletin returns bltn along with its depth.
+func (v *chainView) bulletin(bltn *ombjson.JsonBltn) *Bulletin {
+	b := &Bulletin{JsonBltn: bltn}
+	if height, ok := v.heights[bltn.Blk]; ok && bltn.Blk != "" {
+		b.Confirmations, b.OnMainChain = v.tip-height+1, true
+	}
+	return b
+}
+
+func (v *chainView) bulletins(bltns []*ombjson.JsonBltn) []*Bulletin {
+	bs := make([]*Bulletin, len(bltns))
+	for i, bltn := range bltns {
+		bs[i] = v.bulletin(bltn)
+	}
+	return bs
+}
+
+// confirmed returns the bulletins of bltns with at least minconf
+// confirmations.
+func (v *chainView) confirmed(bltns []*ombjson.JsonBltn, minconf uint64) []*ombjson.JsonBltn {
+	if minconf == 0 {
+		return bltns
+	}
+
+	kept := []*ombjson.JsonBltn{}
+	for _, bltn := range bltns {
+		if v.bulletin(bltn).Confirmations >= minconf {
+			kept = append(kept, bltn)
+		}
+	}
+	return kept
+}
+
+// parseMinConf reads ?minconf= from the request. It is zero when missing.
+func parseMinConf(request *http.Request) (uint64, error) {
+	s := request.URL.Query().Get("minconf")
+	if s == "" {
+		return 0, nil
+	}
+
+	minconf, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errBadMinConf
+	}
+	return minconf, nil
+}
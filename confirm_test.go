@@ -0,0 +1,60 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMinConf(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	var minConfTests = []struct {
+		endpoint string
+		count    int
+	}{
+		{"/board/ahimsa-dev", 4},
+		{"/board/ahimsa-dev?minconf=1", 2},
+		{"/board/ahimsa-dev?minconf=1289", 2},
+		{"/board/ahimsa-dev?minconf=1290", 1},
+		{"/author/miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH?minconf=2000", 1},
+		{"/nilboard?minconf=1", 0},
+		{"/unconfirmed?minconf=1", 0},
+		{"/recent?minconf=1", 1},
+		{"/recent?minconf=2", 0},
+		{"/search?q=medium&minconf=1", 1},
+		{"/search?q=medium&minconf=2485", 0},
+	}
+	for _, test := range minConfTests {
+		var resp struct {
+			Bltns   []*Bulletin     `json:"bltns"`
+			Results []*SearchResult `json:"results"`
+		}
+		var list []*Bulletin
+		body := get(t, ts.URL+test.endpoint)
+		if err := json.Unmarshal([]byte(body), &list); err != nil {
+			if err := json.Unmarshal([]byte(body), &resp); err != nil {
+				t.Fatal(err)
+			}
+			list = resp.Bltns
+			for _, result := range resp.Results {
+				list = append(list, result.Bltn)
+			}
+		}
+		if len(list) != test.count {
+			t.Errorf("%s: got %d bulletins wanted %d", test.endpoint, len(list), test.count)
+		}
+		for _, bltn := range list {
+			if bltn.Confirmations < 1 && bltn.OnMainChain {
+				t.Errorf("%s: %s is on the main chain without confirmations", test.endpoint, bltn.Txid)
+			}
+		}
+	}
+
+	for _, endpoint := range []string{"/recent?minconf=-1", "/board/ahimsa-dev?minconf=one"} {
+		if res := doGet(t, ts.URL+endpoint, nil); res.StatusCode != 400 {
+			t.Errorf("%s: got %d wanted 400", endpoint, res.StatusCode)
+		}
+	}
+}
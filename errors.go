@@ -0,0 +1,139 @@
+package ahimsarest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// An APIError describes why a request made to the versioned api failed. Code
+// is stable and meant for machines, Message is meant for people.
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId"`
+}
+
+// ErrorResp is the body of every failed response in the versioned api.
+type ErrorResp struct {
+	Error APIError `json:"error"`
+}
+
+// The codes that the common statuses are reported with.
+var errorCodes = map[int]string{
+	400: "bad_request",
+	404: "not_found",
+	405: "method_not_allowed",
+	451: "censored",
+	500: "internal_error",
+	501: "not_implemented",
+	503: "unavailable",
+}
+
+// errorCode returns the machine readable code of status.
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	text := strings.ToLower(http.StatusText(status))
+	if text == "" {
+		return "error"
+	}
+	return strings.Replace(text, " ", "_", -1)
+}
+
+type ctxKey int
+
+const requestIDKey ctxKey = 0
+
+// versioned reports whether request was routed through the versioned api.
+func versioned(request *http.Request) bool {
+	_, ok := request.Context().Value(requestIDKey).(string)
+	return ok
+}
+
+// requestID returns the id given to request by the versioned api.
+func requestID(request *http.Request) string {
+	id, _ := request.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// newRequestID returns a random id that is unique enough to find a request in
+// the logs.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts the ids that proxies in front of the api hand out as
+// long as they are short and printable.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// v1 marks requests as part of the versioned api and tags each one with an id
+// that is sent back in the X-Request-Id header. An id passed in by the client
+// is reused. The tagged request is a copy, which only keeps the variables of
+// the path since mux 1.8 holds them in the context of the request.
+func v1(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		id := request.Header.Get("X-Request-Id")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-Id", id)
+
+		ctx := context.WithValue(request.Context(), requestIDKey, id)
+		h(w, request.WithContext(ctx))
+	}
+}
+
+// writeError fails the request with status. The versioned api responds with an
+// ErrorResp while the legacy routes keep their plain text bodies.
+func writeError(w http.ResponseWriter, request *http.Request, status int, msg string) {
+	if !versioned(request) {
+		http.Error(w, msg, status)
+		return
+	}
+
+	bytes, _ := json.Marshal(ErrorResp{APIError{
+		Code:      errorCode(status),
+		Message:   msg,
+		RequestID: requestID(request),
+	}})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+// serverError logs err and fails the request without revealing the details of
+// what went wrong to the client.
+func serverError(w http.ResponseWriter, request *http.Request, err error) {
+	logError(request, err)
+	writeError(w, request, 500, "Internal server error")
+}
+
+// logError logs err along with the request it failed.
+func logError(request *http.Request, err error) {
+	if id := requestID(request); id != "" {
+		log.Printf("%s %s [%s]: %s", request.Method, request.URL, id, err)
+	} else {
+		log.Printf("%s %s: %s", request.Method, request.URL, err)
+	}
+}
@@ -0,0 +1,97 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Asserts that the versioned api answers failures with an ErrorResp.
+func TestVersionedErrors(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	var errorTests = []struct {
+		endpoint   string
+		statuscode int
+		code       string
+	}{
+		{"/v1/bulletin/deadbeef2ffc35dcc191acca037bed1defb0cf4df19555320502766c05041a62", 404, "not_found"},
+		{"/v1/bulletin/b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be", 451, "censored"},
+		{"/v1/block/0000000000000000000000000000000000000000000000000000000000000000", 404, "not_found"},
+		{"/v1/author/mxmvvxMNaXvPPnU5vHXPoPEsrHbbnSAeaa", 404, "not_found"},
+		{"/v1/board/not-a-board", 404, "not_found"},
+		{"/v1/blocks/02-01-2006", 404, "not_found"},
+		{"/v1/recent?limit=0", 400, "bad_request"},
+		{"/v1/search", 400, "bad_request"},
+		{"/v1/no/such/endpoint", 404, "not_found"},
+	}
+
+	for _, test := range errorTests {
+		res, err := http.Get(ts.URL + test.endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var body ErrorResp
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+
+		if res.StatusCode != test.statuscode {
+			t.Errorf("%s: got %d wanted %d", test.endpoint, res.StatusCode, test.statuscode)
+		}
+		if err != nil {
+			t.Errorf("%s: body is not an ErrorResp: %s", test.endpoint, err)
+			continue
+		}
+		if body.Error.Code != test.code || body.Error.Message == "" {
+			t.Errorf("%s: unexpected error %+v", test.endpoint, body.Error)
+		}
+		if id := res.Header.Get("X-Request-Id"); id == "" || id != body.Error.RequestID {
+			t.Errorf("%s: request id %q does not match %q", test.endpoint, body.Error.RequestID, id)
+		}
+	}
+}
+
+// Asserts that the legacy routes keep their behaviour beside the versioned api.
+func TestVersionedRoutes(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	addr := "/author/mxmvvxMNaXvPPnU5vHXPoPEsrHbbnSAeaa"
+	if res := doGet(t, ts.URL+addr, nil); res.StatusCode != 204 {
+		t.Errorf("Legacy unknown author: got %d", res.StatusCode)
+	}
+
+	res := doGet(t, ts.URL+"/v1/blacklist", map[string]string{"X-Request-Id": "abc-123"})
+	if res.StatusCode != 200 {
+		t.Errorf("/v1/blacklist: got %d", res.StatusCode)
+	}
+	if id := res.Header.Get("X-Request-Id"); id != "abc-123" {
+		t.Errorf("The request id was not kept: %q", id)
+	}
+
+	legacy := get(t, ts.URL+"/board/ahimsa-dev")
+	if v := get(t, ts.URL+"/v1/board/ahimsa-dev"); v != legacy {
+		t.Errorf("The versioned board differs from the legacy one")
+	}
+
+	// The variables of the path must survive tagging the request with an id.
+	txid := "933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9"
+	var bltn Bulletin
+	if err := json.Unmarshal([]byte(get(t, ts.URL+"/v1/bulletin/"+txid)), &bltn); err != nil || bltn.Txid != txid {
+		t.Errorf("Unexpected bulletin %+v %v", bltn, err)
+	}
+
+	res, err := http.Get(ts.URL + "/bulletin/deadbeef2ffc35dcc191acca037bed1defb0cf4df19555320502766c05041a62")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Legacy errors should stay plain text: %s", ct)
+	}
+}
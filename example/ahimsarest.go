@@ -20,7 +20,9 @@ func main() {
 		log.Fatal(err)
 	}
 
-	http.Handle("/", ahimsarest.Handler("/", db))
+	opts := ahimsarest.DefaultOptions
+	opts.Feed = ahimsarest.NewFeed(db)
+	http.Handle("/", ahimsarest.NewHandler("/", db, opts))
 	host := "0.0.0.0:1054"
 	log.Printf("web-api listening at %s.\n", host)
 	http.ListenAndServe(host, nil)
@@ -0,0 +1,313 @@
+package ahimsarest
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// The kinds of events pushed out by a Feed.
+const (
+	EventBulletin     = "bulletin"
+	EventConfirmation = "confirmation"
+	EventBlock        = "block"
+	EventReorg        = "reorg"
+)
+
+var (
+	// How often the feed checks the store for changes.
+	feedPollInterval = 5 * time.Second
+	// The number of past events kept around so that reconnecting clients can
+	// resume where they left off.
+	feedHistory = 1024
+	// The number of events that can be queued for a single subscriber before
+	// it is considered too slow and dropped.
+	feedSubBuffer = 64
+)
+
+// An Event describes a change in the public record. Exactly one of Bltn, Block
+// or Reorg is set depending on the Kind of the event. Bulletins carry their
+// depth as of the poll that noticed the change.
+type Event struct {
+	ID    uint64               `json:"id"`
+	Kind  string               `json:"kind"`
+	Bltn  *Bulletin            `json:"bltn,omitempty"`
+	Block *ombjson.JsonBlkHead `json:"block,omitempty"`
+	Reorg *Reorg               `json:"reorg,omitempty"`
+}
+
+// Payload returns the json object that the event carries. It has the same shape
+// as the response of the BulletinHandler, the BlockHeadHandler or a single
+// entry of the ReorgsHandler.
+func (ev *Event) Payload() interface{} {
+	if ev.Reorg != nil {
+		return ev.Reorg
+	}
+	if ev.Block != nil {
+		return ev.Block
+	}
+	return ev.Bltn
+}
+
+// A Subscription receives every event published by a Feed after it was
+// created. If the subscriber falls too far behind C is closed.
+type Subscription struct {
+	C <-chan *Event
+	// Backlog holds the events the subscriber missed before subscribing.
+	Backlog []*Event
+
+	c chan *Event
+}
+
+// A Feed watches a public record for new bulletins, confirmations and blocks
+// and fans those changes out to subscribers. Polling starts with the first
+// subscription and stops when the feed is closed.
+type Feed struct {
+	db        Store
+	chain     *ChainIndex
+	interval  time.Duration
+	once      sync.Once
+	closeOnce sync.Once
+	quit      chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	lastID  uint64
+	history []*Event
+	subs    map[chan *Event]struct{}
+
+	// The state of the record as of the last poll.
+	unconf map[string]bool
+	conf   map[string]bool
+	blocks map[string]*ombjson.JsonBlkHead
+	// The number of reorgs the chain index had recorded.
+	reorgSeq uint64
+}
+
+// NewFeed creates a feed that watches db.
+func NewFeed(db Store) *Feed {
+	return &Feed{
+		db:       db,
+		interval: feedPollInterval,
+		quit:     make(chan struct{}),
+		subs:     make(map[chan *Event]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber. If lastID is not zero every event
+// still in the history that came after lastID is placed in the Backlog. The
+// subscription is already closed when the feed is.
+func (f *Feed) Subscribe(lastID uint64) *Subscription {
+	f.start()
+
+	c := make(chan *Event, feedSubBuffer)
+	sub := &Subscription{C: c, c: c}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if lastID != 0 {
+		// An id from the future was handed out by an earlier process, so the
+		// subscriber gets everything that is left.
+		for _, ev := range f.history {
+			if ev.ID > lastID || lastID > f.lastID {
+				sub.Backlog = append(sub.Backlog, ev)
+			}
+		}
+	}
+
+	if f.closed {
+		close(c)
+	} else {
+		f.subs[c] = struct{}{}
+	}
+
+	return sub
+}
+
+// Unsubscribe removes sub from the feed. It is safe to call more than once.
+func (f *Feed) Unsubscribe(sub *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.subs[sub.c]; ok {
+		delete(f.subs, sub.c)
+		close(sub.c)
+	}
+}
+
+// start begins polling the store. It is safe to call more than once.
+func (f *Feed) start() {
+	f.once.Do(func() { go f.run() })
+}
+
+// Close stops polling the store and closes every subscription. It is safe to
+// call more than once.
+func (f *Feed) Close() {
+	f.closeOnce.Do(func() {
+		close(f.quit)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.closed = true
+		for c := range f.subs {
+			delete(f.subs, c)
+			close(c)
+		}
+	})
+}
+
+func (f *Feed) publish(kind string, bltn *Bulletin, blk *ombjson.JsonBlkHead) {
+	f.send(&Event{Kind: kind, Bltn: bltn, Block: blk})
+}
+
+// send assigns the next id to the event, records it and hands it to every
+// subscriber without blocking. Subscribers with a full buffer are dropped.
+func (f *Feed) send(ev *Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastID++
+	ev.ID = f.lastID
+
+	f.history = append(f.history, ev)
+	if len(f.history) > feedHistory {
+		f.history = f.history[len(f.history)-feedHistory:]
+	}
+
+	for c := range f.subs {
+		select {
+		case c <- ev:
+		default:
+			delete(f.subs, c)
+			close(c)
+		}
+	}
+}
+
+func (f *Feed) run() {
+	select {
+	case <-f.quit:
+		return
+	default:
+	}
+
+	// The first poll only records the current state of the db.
+	f.poll(false)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.quit:
+			return
+		case <-ticker.C:
+			f.poll(true)
+		}
+	}
+}
+
+// poll diffs the state of the db against the last poll and publishes the
+// difference. Errors are ignored since the next poll will try again.
+func (f *Feed) poll(notify bool) {
+
+	unconf, err := f.db.GetUnconfirmed()
+	if err != nil {
+		return
+	}
+	recent, err := f.db.GetRecentConf(6)
+	if err != nil {
+		return
+	}
+	now := time.Now().UTC()
+	blks, err := f.blocksSince(now.Add(-24*time.Hour), now)
+	if err != nil {
+		return
+	}
+	// Bulletins are published along with their depth as of this poll.
+	if f.chain == nil {
+		f.chain = NewChainIndex(f.db)
+	}
+	f.chain.expire()
+	v, err := f.chain.view()
+	if err != nil {
+		return
+	}
+	reorgs, reorgSeq := f.chain.reorgsSince(f.reorgSeq)
+
+	newUnconf := make(map[string]bool)
+	newConf := make(map[string]bool)
+	newBlocks := make(map[string]*ombjson.JsonBlkHead)
+
+	var fresh, confirmed []*ombjson.JsonBltn
+	var found []*ombjson.JsonBlkHead
+
+	for _, bltn := range append(unconf, recent...) {
+		if bltn.Blk == "" {
+			newUnconf[bltn.Txid] = true
+		} else {
+			newConf[bltn.Txid] = true
+		}
+
+		if !f.unconf[bltn.Txid] && !f.conf[bltn.Txid] {
+			fresh = append(fresh, bltn)
+		}
+		if bltn.Blk != "" && !f.conf[bltn.Txid] {
+			confirmed = append(confirmed, bltn)
+		}
+	}
+
+	for _, blk := range blks {
+		newBlocks[blk.Hash] = blk
+		if f.blocks[blk.Hash] == nil {
+			found = append(found, blk)
+		}
+	}
+
+	f.unconf, f.conf, f.blocks, f.reorgSeq = newUnconf, newConf, newBlocks, reorgSeq
+	if !notify {
+		return
+	}
+
+	sort.Sort(bltnsByKey(fresh))
+	sort.Sort(blksByKey(found))
+	sort.Sort(bltnsByKey(confirmed))
+
+	// A reorg is published first so that clients drop the stale blocks before
+	// they hear of the ones that replaced them.
+	for _, reorg := range reorgs {
+		f.send(&Event{Kind: EventReorg, Reorg: reorg})
+	}
+
+	for _, bltn := range fresh {
+		f.publish(EventBulletin, v.bulletin(bltn), nil)
+	}
+	for _, blk := range found {
+		f.publish(EventBlock, nil, blk)
+	}
+	for _, bltn := range confirmed {
+		f.publish(EventConfirmation, v.bulletin(bltn), nil)
+	}
+}
+
+// blocksSince returns the heads of the blocks found on the UTC days from start
+// through end.
+func (f *Feed) blocksSince(start, end time.Time) ([]*ombjson.JsonBlkHead, error) {
+
+	var blks []*ombjson.JsonBlkHead
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	for !day.After(end) {
+		found, err := f.db.GetBlocksByDay(day)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		blks = append(blks, found...)
+		day = day.AddDate(0, 0, 1)
+	}
+
+	return blks, nil
+}
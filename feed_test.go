@@ -0,0 +1,142 @@
+package ahimsarest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// newIdleFeed returns a feed that never polls so that events can be published
+// by hand.
+func newIdleFeed() *Feed {
+	f := NewFeed(nil)
+	f.once.Do(func() {})
+	return f
+}
+
+func TestFeedResume(t *testing.T) {
+
+	f := newIdleFeed()
+	f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "a"}}, nil)
+	f.publish(EventBlock, nil, &ombjson.JsonBlkHead{Hash: "b"})
+	f.publish(EventConfirmation, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "a", Blk: "b"}}, nil)
+
+	sub := f.Subscribe(1)
+	defer f.Unsubscribe(sub)
+
+	if len(sub.Backlog) != 2 {
+		t.Fatalf("Expected 2 events in the backlog, got %d", len(sub.Backlog))
+	}
+	if sub.Backlog[0].ID != 2 || sub.Backlog[0].Kind != EventBlock {
+		t.Errorf("Backlog starts with the wrong event: %v", sub.Backlog[0])
+	}
+
+	f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "c"}}, nil)
+	ev := <-sub.C
+	if ev.ID != 4 || ev.Bltn.Txid != "c" {
+		t.Errorf("Received the wrong event: %v", ev)
+	}
+}
+
+func TestFeedDropsSlowSubscriber(t *testing.T) {
+
+	f := newIdleFeed()
+	slow := f.Subscribe(0)
+
+	for i := 0; i <= feedSubBuffer; i++ {
+		f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{}}, nil)
+	}
+
+	n := 0
+	for range slow.C {
+		n++
+	}
+	if n != feedSubBuffer {
+		t.Errorf("Expected %d buffered events, got %d", feedSubBuffer, n)
+	}
+
+	// Unsubscribing after being dropped must not panic.
+	f.Unsubscribe(slow)
+}
+
+func TestStreamResume(t *testing.T) {
+
+	f := newIdleFeed()
+	f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "a"}}, nil)
+	f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "b"}}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest("GET", "/stream", nil)
+	req = req.WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "1")
+
+	rec := httptest.NewRecorder()
+	StreamHandler(f)(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Wrong content type: %s", ct)
+	}
+	want := "id: 2\nevent: bulletin\ndata: {\"txid\":\"b\",\"author\":\"\",\"msg\":\"\",\"timestamp\":0,\"confirmations\":0,\"onMainChain\":false}\n\n"
+	if body := rec.Body.String(); !strings.HasPrefix(body, want) {
+		t.Errorf("Responded with body:\n%s\nWanted:\n%s", body, want)
+	}
+}
+
+// Asserts that an api without a feed refuses to push events.
+func TestStreamDisabled(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	for _, path := range []string{"/stream", "/ws", "/v1/stream"} {
+		if res := doGet(t, ts.URL+path, nil); res.StatusCode != 501 {
+			t.Errorf("%s: got %d wanted 501", path, res.StatusCode)
+		}
+	}
+}
+
+// A countingStore counts how often the unconfirmed bulletins were read.
+type countingStore struct {
+	Store
+	n int32
+}
+
+func (s *countingStore) GetUnconfirmed() ([]*ombjson.JsonBltn, error) {
+	atomic.AddInt32(&s.n, 1)
+	return s.Store.GetUnconfirmed()
+}
+
+func TestFeedClose(t *testing.T) {
+
+	store := &countingStore{Store: newTestMemStore()}
+	f := NewFeed(store)
+	f.interval = time.Millisecond
+	sub := f.Subscribe(0)
+	time.Sleep(10 * time.Millisecond)
+
+	f.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("Closing the feed left a subscription open")
+	}
+	if _, ok := <-f.Subscribe(0).C; ok {
+		t.Error("Subscribed to a closed feed")
+	}
+
+	// A poll that was under way when the feed closed may still finish.
+	time.Sleep(5 * time.Millisecond)
+	polls := atomic.LoadInt32(&store.n)
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&store.n); n != polls {
+		t.Errorf("The feed polled %d more times after it closed", n-polls)
+	}
+
+	// Closing twice must not panic.
+	f.Close()
+}
@@ -0,0 +1,283 @@
+package ahimsarest
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// The longest title an entry is given before it is truncated.
+const feedTitleLen = 80
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Title     string        `xml:"title"`
+	ID        string        `xml:"id"`
+	Updated   string        `xml:"updated"`
+	Published string        `xml:"published"`
+	Author    atomPerson    `xml:"author"`
+	Category  *atomCategory `xml:"category"`
+	Content   atomText      `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssGuid struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	Category    string  `xml:"category,omitempty"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// A feedEntry is a bulletin prepared for either feed format.
+type feedEntry struct {
+	txid     string
+	title    string
+	content  string
+	author   string
+	board    string
+	updated  time.Time
+	reported time.Time
+}
+
+// newFeedEntry turns a bulletin into an entry. Censored bulletins become stubs
+// that only carry the reason they were blacklisted.
+func newFeedEntry(bltn *ombjson.JsonBltn) feedEntry {
+
+	e := feedEntry{
+		txid:     bltn.Txid,
+		author:   bltn.Author,
+		board:    bltn.Board,
+		reported: time.Unix(bltn.Timestamp, 0).UTC(),
+		updated:  time.Unix(bltn.Timestamp, 0).UTC(),
+	}
+	if bltn.BlkTimestamp != 0 {
+		e.updated = time.Unix(bltn.BlkTimestamp, 0).UTC()
+	}
+
+	if bltn.BannedReason != "" {
+		e.title = "Censored bulletin"
+		e.content = "This bulletin was censored: " + bltn.BannedReason
+		return e
+	}
+
+	e.content = bltn.Msg
+	e.title = strings.TrimSpace(strings.SplitN(bltn.Msg, "\n", 2)[0])
+	if r := []rune(e.title); len(r) > feedTitleLen {
+		e.title = string(r[:feedTitleLen-3]) + "..."
+	}
+	if e.title == "" {
+		e.title = "Untitled bulletin"
+	}
+
+	return e
+}
+
+type entriesByUpdated []feedEntry
+
+func (s entriesByUpdated) Len() int      { return len(s) }
+func (s entriesByUpdated) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s entriesByUpdated) Less(i, j int) bool {
+	if s[i].updated.Equal(s[j].updated) {
+		return s[i].txid < s[j].txid
+	}
+	return s[i].updated.After(s[j].updated)
+}
+
+// writeFeed renders bltns as an atom or an rss feed with the newest entries
+// first. The url of the request doubles as the id of the feed. Unlike the json
+// handlers entries carry no confirmations: the depth changes with every block
+// and readers would take every entry for updated each time.
+func writeFeed(w http.ResponseWriter, request *http.Request, format, title string, bltns []*ombjson.JsonBltn) {
+
+	entries := make([]feedEntry, len(bltns))
+	for i, bltn := range bltns {
+		entries[i] = newFeedEntry(bltn)
+	}
+	sort.Sort(entriesByUpdated(entries))
+
+	updated := processStart.UTC()
+	if len(entries) > 0 {
+		updated = entries[0].updated
+	}
+
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	self := fmt.Sprintf("%s://%s%s", scheme, request.Host, request.URL.EscapedPath())
+
+	var doc interface{}
+	var contentType string
+
+	switch format {
+	case "atom":
+		feed := atomFeed{
+			Title:   title,
+			ID:      self,
+			Updated: updated.Format(time.RFC3339),
+			Link:    atomLink{Href: self, Rel: "self"},
+		}
+		for _, e := range entries {
+			entry := atomEntry{
+				Title:     e.title,
+				ID:        "urn:ombuds:bulletin:" + e.txid,
+				Updated:   e.updated.Format(time.RFC3339),
+				Published: e.reported.Format(time.RFC3339),
+				Author:    atomPerson{Name: e.author},
+				Content:   atomText{Type: "text", Body: e.content},
+			}
+			if e.board != "" {
+				entry.Category = &atomCategory{Term: e.board}
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+		doc, contentType = feed, "application/atom+xml; charset=utf-8"
+	default:
+		feed := rssFeed{
+			Version: "2.0",
+			Channel: rssChannel{
+				Title:         title,
+				Link:          self,
+				Description:   title,
+				LastBuildDate: updated.Format(time.RFC1123Z),
+			},
+		}
+		for _, e := range entries {
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       e.title,
+				Description: e.content,
+				Category:    e.board,
+				Guid:        rssGuid{IsPermaLink: "false", Value: e.txid},
+				PubDate:     e.updated.Format(time.RFC1123Z),
+			})
+		}
+		doc, contentType = feed, "application/rss+xml; charset=utf-8"
+	}
+
+	bytes, err := xml.Marshal(doc)
+	if err != nil {
+		serverError(w, request, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	w.Write(bytes)
+}
+
+// Serves a board as a feed. The format is either atom or rss.
+func BoardFeedHandler(db Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		vars := mux.Vars(request)
+		boardstr, format := vars["board"], vars["format"]
+
+		board, err := db.GetWholeBoard(boardstr)
+		if err == sql.ErrNoRows {
+			writeError(w, request, 404, "Board does not exist")
+			return
+		}
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeFeed(w, request, format, "Bulletins posted to "+boardstr, board.Bltns)
+	}
+}
+
+// Serves the bulletins of an author as a feed.
+func AuthorFeedHandler(db Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		vars := mux.Vars(request)
+		addr, format := vars["addr"], vars["format"]
+
+		authorJson, err := db.GetJsonAuthor(addr)
+		if err == sql.ErrNoRows {
+			writeError(w, request, 404, "Author does not exist")
+			return
+		}
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeFeed(w, request, format, "Bulletins written by "+addr, authorJson.Bltns)
+	}
+}
+
+// Serves the bulletins seen within the last ?blocks= blocks as a feed, over
+// the same window as /recent.
+func RecentFeedHandler(db Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		format := mux.Vars(request)["format"]
+
+		n, err := recentBlocks(request)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+
+		bltns, err := db.GetRecentConf(n)
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeFeed(w, request, format, "Recent bulletins", bltns)
+	}
+}
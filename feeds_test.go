@@ -0,0 +1,101 @@
+package ahimsarest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+var feedBltns = []*ombjson.JsonBltn{
+	{
+		Txid:      "f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf",
+		Board:     "ahimsa-dev",
+		Author:    "mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c",
+		Msg:       "Here comes the sun",
+		Timestamp: 1413079355,
+	},
+	{
+		Txid:         "b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be",
+		Board:        "ahimsa-dev",
+		Author:       "mnPZBNTrLoCoSkAgSfKeeCujU3129PG6vn",
+		Timestamp:    1413216499,
+		Blk:          "00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d",
+		BlkTimestamp: 1414813562,
+		BannedReason: "The Beatles are slanderous.",
+	},
+}
+
+// Asserts that entries are ordered by block time and that censored bulletins
+// are kept as stubs.
+func TestAtomFeed(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "http://example.com/board/ahimsa-dev/feed.atom", nil)
+	rec := httptest.NewRecorder()
+	writeFeed(rec, req, "atom", "ahimsa-dev", feedBltns)
+
+	body := rec.Body.String()
+	want := []string{
+		`<feed xmlns="http://www.w3.org/2005/Atom"><title>ahimsa-dev</title><id>http://example.com/board/ahimsa-dev/feed.atom</id><updated>2014-11-01T03:46:02Z</updated>`,
+		`<entry><title>Censored bulletin</title><id>urn:ombuds:bulletin:b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be</id><updated>2014-11-01T03:46:02Z</updated>`,
+		`<content type="text">This bulletin was censored: The Beatles are slanderous.</content>`,
+		`<entry><title>Here comes the sun</title><id>urn:ombuds:bulletin:f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf</id><updated>2014-10-12T02:02:35Z</updated>`,
+	}
+	for _, s := range want {
+		if !strings.Contains(body, s) {
+			t.Errorf("Feed is missing:\n%s\nGot:\n%s", s, body)
+		}
+	}
+	if strings.Index(body, want[1]) > strings.Index(body, want[3]) {
+		t.Errorf("Entries are not ordered newest first")
+	}
+}
+
+func TestRssFeed(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "http://example.com/recent/feed.rss", nil)
+	rec := httptest.NewRecorder()
+	writeFeed(rec, req, "rss", "Recent bulletins", feedBltns)
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/rss+xml; charset=utf-8" {
+		t.Errorf("Wrong content type: %s", ct)
+	}
+	want := `<guid isPermaLink="false">f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf</guid><pubDate>Sun, 12 Oct 2014 02:02:35 +0000</pubDate>`
+	if body := rec.Body.String(); !strings.Contains(body, want) {
+		t.Errorf("Feed is missing:\n%s\nGot:\n%s", want, body)
+	}
+}
+
+// Asserts that the recent feed covers the same window of blocks as /recent.
+func TestRecentFeedWindow(t *testing.T) {
+
+	store := newTestMemStore()
+	tip := "00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff"
+	hash := "000000000000000000000000000000000000000000000000000000000000ffff"
+	store.AddBlock(&ombjson.JsonBlkHead{Hash: hash, PrevHash: tip, Timestamp: 1415862700, Height: 307013})
+	store.AddBltn(&ombjson.JsonBltn{Txid: "e1", Board: "ahimsa-dev", Author: "miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH", Msg: "newest", Timestamp: 1415862650, Blk: hash})
+	ts := httptest.NewServer(Handler("/", store))
+	defer ts.Close()
+
+	recent := "urn:ombuds:bulletin:5df96dcb607701d19f7ae3a5da2708d834df7dc8ff505d74aa27dc82aeb7b3c1"
+	var windowTests = []struct {
+		query    string
+		included bool
+	}{
+		{"", true},
+		{"?blocks=1", false},
+		{"?blocks=5000", true},
+	}
+	for _, test := range windowTests {
+		body := get(t, ts.URL+"/recent/feed.atom"+test.query)
+		if !strings.Contains(body, "urn:ombuds:bulletin:e1") || strings.Contains(body, recent) != test.included {
+			t.Errorf("%s: unexpected feed %s", test.query, body)
+		}
+	}
+
+	if res := doGet(t, ts.URL+"/recent/feed.atom?blocks=0", nil); res.StatusCode != 400 {
+		t.Errorf("Expected a bad window to be refused: %d", res.StatusCode)
+	}
+}
@@ -3,11 +3,18 @@ package ahimsarest
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/gorilla/mux"
+	"github.com/soapboxsys/ombudslib/ombjson"
 	"github.com/soapboxsys/ombudslib/protocol/ombproto"
 	"github.com/soapboxsys/ombudslib/pubrecdb"
 )
@@ -16,290 +23,1048 @@ var (
 	processStart time.Time = time.Now()
 )
 
-func writeJson(w http.ResponseWriter, m interface{}) {
+// The number of blocks the recent bulletins are taken from by default and at
+// most, which is about a week.
+const (
+	defaultRecentBlocks = 6
+	maxRecentBlocks     = 1008
+)
+
+var errBadBlocks = errors.New("blocks must be a positive integer")
+
+func writeJson(w http.ResponseWriter, request *http.Request, m interface{}) {
+
+	bytes, err := json.Marshal(m)
+	if err != nil {
+		serverError(w, request, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+// writeJsonStatus serves m like writeJson but with a status other than 200.
+// Nothing is written until m was marshalled, so a failure can still be served.
+func writeJsonStatus(w http.ResponseWriter, request *http.Request, status int, m interface{}) {
 
 	bytes, err := json.Marshal(m)
 	if err != nil {
-		http.Error(w, "Failed", 500)
+		serverError(w, request, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(bytes)
 }
 
-func BulletinHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+// writeBltns serves the bulletins with at least minconf confirmations either
+// whole or as a single Page.
+func writeBltns(w http.ResponseWriter, request *http.Request, v *chainView, bltns []*ombjson.JsonBltn, pp pageParams, minconf uint64) {
+	bltns = v.confirmed(bltns, minconf)
+	if !pp.paged {
+		writeJson(w, request, v.bulletins(bltns))
+		return
+	}
+
+	page, next := pageBltns(bltns, pp)
+	writeJson(w, request, Page{Items: v.bulletins(page), Next: next})
+}
+
+// writeBoard serves a board with the bulletins that have at least minconf
+// confirmations either whole or as a single BoardPage.
+func writeBoard(w http.ResponseWriter, request *http.Request, v *chainView, board *ombjson.WholeBoard, pp pageParams, minconf uint64) {
+	bltns := v.confirmed(board.Bltns, minconf)
+	if !pp.paged {
+		writeJson(w, request, BoardResp{Summary: board.Summary, Bltns: v.bulletins(bltns)})
+		return
+	}
+
+	page, next := pageBltns(bltns, pp)
+	writeJson(w, request, BoardPage{Summary: board.Summary, Bltns: v.bulletins(page), Next: next})
+}
+
+// listParams parses the paging query and ?minconf= of a request that lists
+// bulletins and takes a view of the chain to serve them with. It responds
+// itself if it fails.
+func listParams(w http.ResponseWriter, request *http.Request, ci *ChainIndex) (*chainView, pageParams, uint64, bool) {
+
+	pp, err := parsePageParams(request)
+	if err != nil {
+		writeError(w, request, 400, err.Error())
+		return nil, pp, 0, false
+	}
+	minconf, err := parseMinConf(request)
+	if err != nil {
+		writeError(w, request, 400, err.Error())
+		return nil, pp, 0, false
+	}
+	v, err := ci.view()
+	if err != nil {
+		serverError(w, request, err)
+		return nil, pp, 0, false
+	}
+
+	return v, pp, minconf, true
+}
+
+func BulletinHandler(db Store, ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
 		txid, _ := mux.Vars(request)["txid"]
 		bltn, err := db.GetJsonBltn(txid)
 		if err == sql.ErrNoRows {
-			http.Error(w, "Bulletin does not exist", 404)
+			writeError(w, request, 404, "Bulletin does not exist")
 			return
 		}
 		if err == pubrecdb.ErrBltnCensored {
-			http.Error(w, err.Error(), 451)
+			w.Header().Set("Link", transparencyLink(request, txid))
+			writeError(w, request, 451, err.Error())
 			return
 		}
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
 			return
 		}
 
-		writeJson(w, bltn)
+		v, err := ci.view()
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeJson(w, request, v.bulletin(bltn))
 	}
 }
 
-// Handles requests for individual Blocks
-func BlockHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+// Handles requests for individual Blocks. Blocks that a reorg disconnected
+// from the main chain are gone unless the store kept them, in which case they
+// are served as stale.
+func BlockHandler(db Store, ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
 		hash, _ := mux.Vars(request)["hash"]
 
 		blockH, err := db.GetJsonBlock(hash)
 		if err == sql.ErrNoRows {
-			http.Error(w, err.Error(), 404)
+			missingBlock(w, request, ci, hash)
+			return
+		}
+		if err != nil {
+			serverError(w, request, err)
 			return
 		}
+
+		v, err := ci.view()
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
+			return
+		}
+		if err := setChainStatus(w, ci, hash); err != nil {
+			serverError(w, request, err)
 			return
 		}
 
-		writeJson(w, blockH)
+		writeJson(w, request, BlockResp{Head: blockH.Head, Bltns: v.bulletins(blockH.Bltns)})
 	}
 }
 
-// Handles requests for individual Blocks
-func BlockHeadHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+// Handles requests for the heads of individual Blocks.
+func BlockHeadHandler(db Store, ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
 		hash, _ := mux.Vars(request)["hash"]
 
 		blockH, err := db.GetJsonBlockHead(hash)
 		if err == sql.ErrNoRows {
-			http.Error(w, err.Error(), 404)
+			missingBlock(w, request, ci, hash)
 			return
 		}
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
+			return
+		}
+
+		if err := setChainStatus(w, ci, hash); err != nil {
+			serverError(w, request, err)
 			return
 		}
 
-		writeJson(w, blockH)
+		writeJson(w, request, blockH)
 	}
 }
 
 // Handles a request for information about an individual author. This does not
 // validate the provided address.
-func AuthorHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+func AuthorHandler(db Store, ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
 		addr, _ := mux.Vars(request)["addr"]
 
+		v, pp, minconf, ok := listParams(w, request, ci)
+		if !ok {
+			return
+		}
+
 		authorJson, err := db.GetJsonAuthor(addr)
-		if err == sql.ErrNoRows {
+		if err == sql.ErrNoRows && !versioned(request) {
 			http.Error(w, "Author does not exist", 204)
 			return
 		}
+		if err == sql.ErrNoRows {
+			writeError(w, request, 404, "Author does not exist")
+			return
+		}
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
+			return
+		}
+
+		bltns := v.confirmed(authorJson.Bltns, minconf)
+		if !pp.paged {
+			writeJson(w, request, AuthorResp{Author: authorJson.Author, Bltns: v.bulletins(bltns)})
 			return
 		}
 
-		writeJson(w, authorJson)
+		page, next := pageBltns(bltns, pp)
+		writeJson(w, request, AuthorPage{Author: authorJson.Author, Bltns: v.bulletins(page), Next: next})
 	}
 }
 
 // Handles serving the blacklist contents over http. If the black list is empty
 // it serves an empty list.
-func BlacklistHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+func BlacklistHandler(db Store) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 		blacklist, err := db.GetJsonBlacklist()
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
 			return
 		}
-		writeJson(w, blacklist)
+		writeJson(w, request, blacklist)
 	}
 }
 
 // Handles serving a bulletin board.
-func BoardHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+func BoardHandler(db Store, ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 		boardstr, _ := mux.Vars(request)["board"]
 
+		v, pp, minconf, ok := listParams(w, request, ci)
+		if !ok {
+			return
+		}
+
 		board, err := db.GetWholeBoard(boardstr)
 		if err == sql.ErrNoRows {
-			http.Error(w, err.Error(), 404)
+			writeError(w, request, 404, "Board does not exist")
 			return
 		}
 
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
 			return
 		}
 
-		writeJson(w, board)
+		writeBoard(w, request, v, board, pp, minconf)
 	}
 }
 
 // Returns all bulletins under the board that has no name! Since board is an
 // optional field you don't actually have to specify one. If that's the case
 // then your bulletins will just have a NULL value in the board column
-func NilBoardHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+func NilBoardHandler(db Store, ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
+		v, pp, minconf, ok := listParams(w, request, ci)
+		if !ok {
+			return
+		}
+
 		board, err := db.GetWholeBoard("")
 		if err == sql.ErrNoRows {
-			http.Error(w, err.Error(), 404)
+			writeError(w, request, 404, "Board does not exist")
 			return
 		}
 
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
 			return
 		}
 
-		writeJson(w, board)
+		writeBoard(w, request, v, board, pp, minconf)
 	}
 }
 
 // Returns the summaries of every board in the system sorted in lexicographic order.
-func AllBoardsHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+func AllBoardsHandler(db Store) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
+		pp, err := parsePageParams(request)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+
 		boards, err := db.GetAllBoards()
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
+			return
+		}
+
+		if !pp.paged {
+			writeJson(w, request, boards)
 			return
 		}
 
-		writeJson(w, boards)
+		page, next := pageBoards(boards, pp)
+		writeJson(w, request, Page{Items: page, Next: next})
 	}
 }
 
 // Returns all of the authors in the public record sorted in alphabetical order
-func AllAuthorsHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+func AllAuthorsHandler(db Store) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
+		pp, err := parsePageParams(request)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+
 		authors, err := db.GetAllAuthors()
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
 			return
 		}
 
-		writeJson(w, authors)
+		if !pp.paged {
+			writeJson(w, request, authors)
+			return
+		}
+
+		page, next := pageAuthors(authors, pp)
+		writeJson(w, request, Page{Items: page, Next: next})
+	}
+}
+
+// recentBlocks reads how many of the latest blocks ?blocks= asks for. It is
+// defaultRecentBlocks when left out and never more than maxRecentBlocks.
+func recentBlocks(request *http.Request) (int, error) {
+
+	n := defaultRecentBlocks
+	if s := request.URL.Query().Get("blocks"); s != "" {
+		blocks, err := strconv.Atoi(s)
+		if err != nil || blocks < 1 {
+			return 0, errBadBlocks
+		}
+		n = blocks
 	}
+	if n > maxRecentBlocks {
+		n = maxRecentBlocks
+	}
+
+	return n, nil
 }
 
-// Returns all of the bulletins seen within the last 6 blocks.
-func RecentHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+// Returns all of the bulletins seen within the last ?blocks= blocks, 6 by
+// default. With ?since= only bulletins confirmed by blocks found at or after
+// that time are returned and with ?unconfirmed=true the unconfirmed bulletins
+// made since then are merged in by time.
+func RecentHandler(db Store, ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
-		bltns, err := db.GetRecentConf(6)
+		v, pp, minconf, ok := listParams(w, request, ci)
+		if !ok {
+			return
+		}
+
+		q := request.URL.Query()
+		n, err := recentBlocks(request)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			writeError(w, request, 400, err.Error())
 			return
 		}
 
-		writeJson(w, bltns)
+		var since time.Time
+		if s := q.Get("since"); s != "" {
+			var err error
+			if since, err = parseTime(s); err != nil {
+				writeError(w, request, 400, "since is not a valid time")
+				return
+			}
+
+			// The window reaches back to the oldest block found since then.
+			var from uint64
+			if v.tip >= maxRecentBlocks {
+				from = v.tip - maxRecentBlocks + 1
+			}
+			heads, err := ci.Range(from, v.tip)
+			if err != nil {
+				serverError(w, request, err)
+				return
+			}
+			n = 0
+			for _, head := range heads {
+				if head.Timestamp >= since.Unix() {
+					n = int(v.tip-head.Height) + 1
+					break
+				}
+			}
+		}
+		if n > maxRecentBlocks {
+			n = maxRecentBlocks
+		}
+
+		unconf := false
+		if s := q.Get("unconfirmed"); s != "" {
+			var err error
+			if unconf, err = strconv.ParseBool(s); err != nil {
+				writeError(w, request, 400, "unconfirmed must be true or false")
+				return
+			}
+		}
+
+		bltns := []*ombjson.JsonBltn{}
+		if n > 0 {
+			recent, err := db.GetRecentConf(n)
+			if err != nil {
+				serverError(w, request, err)
+				return
+			}
+			for _, bltn := range recent {
+				if bltn.BlkTimestamp >= since.Unix() {
+					bltns = append(bltns, bltn)
+				}
+			}
+		}
+
+		if unconf {
+			pending, err := db.GetUnconfirmed()
+			if err != nil {
+				serverError(w, request, err)
+				return
+			}
+			for _, bltn := range pending {
+				if bltn.Timestamp >= since.Unix() {
+					bltns = append(bltns, bltn)
+				}
+			}
+			sort.Sort(bltnsByKey(bltns))
+		}
+
+		writeBltns(w, request, v, bltns, pp, minconf)
 	}
 }
 
 // Returns all of the unconfirmed bulletins ordered by reported time.
-func UnconfirmedHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+func UnconfirmedHandler(db Store, ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
+		v, pp, minconf, ok := listParams(w, request, ci)
+		if !ok {
+			return
+		}
+
 		bltns, err := db.GetUnconfirmed()
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
 			return
 		}
 
-		writeJson(w, bltns)
+		writeBltns(w, request, v, bltns, pp, minconf)
 	}
 }
 
-// Returns all of the block summaries for a given day.
-func BlockDayHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+// Returns all of the block summaries for a given day. The day starts at
+// midnight in the zone given by ?tz=, UTC by default.
+func BlockDayHandler(db Store) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
 		datestr := mux.Vars(request)["day"]
 
-		// convert into UTC then do lookups within range
-		layout := "02-01-2006"
-		date, err := time.Parse(layout, datestr)
+		pp, err := parsePageParams(request)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			writeError(w, request, 400, err.Error())
 			return
 		}
 
-		blocks, err := db.GetBlocksByDay(date)
-		if err == sql.ErrNoRows {
-			http.Error(w, err.Error(), 404)
+		loc, err := parseZone(request)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+
+		date, err := parseDay(datestr, loc)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
 			return
 		}
+
+		blocks, err := blocksWithin(db, date, date.AddDate(0, 0, 1))
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
+			return
+		}
+		if len(blocks) == 0 {
+			writeError(w, request, 404, "No blocks were found on that day")
 			return
 		}
 
-		writeJson(w, blocks)
+		if !pp.paged {
+			writeJson(w, request, blocks)
+			return
+		}
+
+		page, next := pageBlks(blocks, pp)
+		writeJson(w, request, Page{Items: page, Next: next})
 	}
 }
 
 // Handles the round trip to pubrecdb to get DB status. In the future
 // this could look up the status of other processes that are running
 // on the machine and report their status as well.
-func StatusHandler(db *pubrecdb.PublicRecord) func(http.ResponseWriter, *http.Request) {
+// The counters of the response cache are included if there is one.
+func StatusHandler(db Store, cache *ResponseCache) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, request *http.Request) {
 
 		status, err := db.GetDBStatus()
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			serverError(w, request, err)
 			return
 		}
 
 		status.Version = ombproto.Version
 
-		writeJson(w, status)
+		resp := StatusResp{Status: status}
+		if cache != nil {
+			stats := cache.Stats()
+			resp.Cache = &stats
+		}
+
+		writeJson(w, request, resp)
 	}
 }
 
+// A StatusResp is the status of the store along with the state of the api.
+type StatusResp struct {
+	*ombjson.Status
+	Cache *CacheStats `json:"cache,omitempty"`
+}
+
+// Options tune the handler returned by NewHandler.
+type Options struct {
+	// The most memory in bytes that the response cache may hold. The cache is
+	// disabled when this is zero.
+	CacheBytes int
+	// How often the cache checks the store for a new tip.
+	CacheCheck time.Duration
+	// How often the index of the main chain checks the store for new blocks
+	// when the cache has not already seen them.
+	ChainRefresh time.Duration
+	// Relays the bulletins posted to the api. Bulletins cannot be posted when
+	// this is nil.
+	Broadcaster Broadcaster
+	// The network that posted bulletins are decoded for.
+	Net *chaincfg.Params
+	// Looks up the transactions spent by built bulletins that clients did not
+	// send along. Clients must send them when this is nil.
+	TxSource TxSource
+	// When set the overlay is served in place of the store it wraps and
+	// operators may change its blacklist.
+	Blacklist *BlacklistOverlay
+	// The names of the operators keyed by the bearer tokens they present.
+	AdminTokens map[string]string
+	// The key that the blacklist is signed with when it is exported.
+	SigningKey *btcec.PrivateKey
+	// The feed that streams and websockets are served from. It should watch
+	// the store the api serves and is closed by the caller once the handler is
+	// thrown away. Events are not pushed when this is nil.
+	Feed *Feed
+}
+
+// DefaultOptions are the options used by Handler.
+var DefaultOptions = Options{
+	CacheBytes:   32 << 20,
+	CacheCheck:   time.Second,
+	ChainRefresh: chainRefresh,
+	Net:          &chaincfg.MainNetParams,
+}
+
 // returns the http handler initialized with the api's routes. The prefix should
 // start and end with slashes. For example /api/ is a good prefix.
-func Handler(prefix string, db *pubrecdb.PublicRecord) http.Handler {
+//
+// Every handler that serves a list accepts ?limit= and ?cursor=. When either is
+// present the list is served one page at a time in a stable order along with a
+// next cursor to pass back in to get the following page.
+//
+// Successful responses carry an ETag and a Cache-Control policy and requests
+// made with If-None-Match or If-Modified-Since are answered with a 304 when
+// nothing has changed.
+//
+// Every route is also served under prefix+"v1/". Failed requests made to the
+// versioned api are answered with an ErrorResp that carries the id that was
+// sent back in the X-Request-Id header, while the unversioned routes keep
+// responding with plain text for existing clients.
+//
+// The api describes itself with an OpenAPI 3 document served at openapi.json.
+func Handler(prefix string, db Store) http.Handler {
+	return NewHandler(prefix, db, DefaultOptions)
+}
+
+// A route is a handler along with the path it is served at relative to the
+// prefix of the api and the documentation that it is described by in the
+// OpenAPI document.
+type route struct {
+	path string
+	// The method the route is served for. Routes without one are read only.
+	method  string
+	summary string
+	// A value of the type of the body of the request.
+	req interface{}
+	// A value of the type served as json. When paged is set it is served
+	// instead if the client asks for a page.
+	resp  interface{}
+	paged interface{}
+	// The content type of responses that are not json.
+	media string
+	// The status of a successful response when it is not a 200.
+	status int
+	// The query parameters accepted besides the ones used for paging.
+	query []string
+	// The statuses that the handler fails with on a bad request.
+	errs []int
+	// Whether the route is reserved to operators.
+	admin bool
+
+	handler http.HandlerFunc
+}
+
+// The patterns the variables of paths are matched against. Groups within the
+// patterns must not capture, otherwise the router hands the wrong submatch to
+// the variables that follow them in a path.
+const (
+	sha2re  = "(?:[a-f]|[A-F]|[0-9]){64}"
+	addrgex = "(?:[a-z]|[A-Z]|[0-9]){30,35}"
+	// Since the board's path could be percent encoded we give it 3x wiggle
+	// room since a single byte in percent encoding is %EE.
+	boardre = ".{1,90}"
+	// A single day follows either YYYY-MM-DD or DD-MM-YYYY.
+	dayre = `[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}|[0-9]{1,2}-[0-9]{1,2}-[0-9]{4}`
+)
+
+// newRoutes returns every route of the api configured by opts.
+func newRoutes(db Store, opts Options) []route {
+
+	if opts.Blacklist != nil {
+		db = opts.Blacklist
+	}
+
+	idx := NewSearchIndex(db)
+	chain := NewChainIndex(db)
+	chain.every = opts.ChainRefresh
+
+	// Responses that only change with the store are served out of the cache
+	// and carry validators along with the given Cache-Control policy.
+	var cache *ResponseCache
+	var modified func() time.Time
+	c := func(policy string, h http.HandlerFunc) http.HandlerFunc {
+		return conditional(policy, modified, h)
+	}
+	if opts.CacheBytes > 0 {
+		cache = NewResponseCache(db, opts.CacheBytes, opts.CacheCheck)
+		cache.onChange = chain.expire
+		modified = cache.LastModified
+		c = func(policy string, h http.HandlerFunc) http.HandlerFunc {
+			return conditional(policy, modified, cache.Wrap(h))
+		}
+	}
+
+	// Changes to the blacklist take effect immediately.
+	if opts.Blacklist != nil {
+		if cache != nil {
+			opts.Blacklist.OnChange(cache.Purge)
+		}
+		opts.Blacklist.OnChange(idx.Invalidate)
+	}
+
+	// Syndication formats that a list of bulletins can be served in
+	feedre := "atom|rss"
+	feedType := "application/atom+xml"
+
+	bltns := []*Bulletin{}
+	bltnPage := Page{Items: bltns}
+
+	return []route{
+		// Feed handlers come first so that the board pattern does not swallow them
+		{
+			path:    fmt.Sprintf("board/{board:%s}/feed.{format:%s}", boardre, feedre),
+			summary: "The bulletins of a board as an atom or rss feed",
+			media:   feedType,
+			errs:    []int{404},
+			handler: c(cacheDefault, BoardFeedHandler(db)),
+		},
+		{
+			path:    fmt.Sprintf("author/{addr:%s}/feed.{format:%s}", addrgex, feedre),
+			summary: "The bulletins of an author as an atom or rss feed",
+			media:   feedType,
+			errs:    []int{404},
+			handler: c(cacheDefault, AuthorFeedHandler(db)),
+		},
+		{
+			path:    fmt.Sprintf("recent/feed.{format:%s}", feedre),
+			summary: "The bulletins confirmed within the last ?blocks= blocks as an atom or rss feed",
+			media:   feedType,
+			query:   []string{"blocks"},
+			errs:    []int{400},
+			handler: c(cacheShort, RecentFeedHandler(db)),
+		},
+
+		// Item handlers
+		{
+			path:    "bulletin",
+			method:  "POST",
+			summary: "Relays a signed bulletin to the bitcoin network",
+			req:     &PostBltnReq{},
+			resp:    &PostBltnResp{},
+			status:  202,
+			errs:    []int{501, 502},
+			handler: PostBulletinHandler(opts.Broadcaster, opts.Net),
+		},
+		{
+			path:    "bulletin/build",
+			method:  "POST",
+			summary: "Builds an unsigned bulletin funded by the given outputs of its author",
+			req:     &BuildBltnReq{},
+			resp:    &BuildBltnResp{},
+			errs:    []int{400, 502},
+			handler: BuildBulletinHandler(opts.Net, opts.TxSource),
+		},
+		{
+			path:    fmt.Sprintf("bulletin/{txid:%s}", sha2re),
+			summary: "A single bulletin",
+			resp:    &Bulletin{},
+			errs:    []int{404, 451},
+			handler: c(cacheDefault, BulletinHandler(db, chain)),
+		},
+		{
+			path:    fmt.Sprintf("author/{addr:%s}", addrgex),
+			summary: "An author along with every bulletin they wrote",
+			resp:    &AuthorResp{},
+			paged:   &AuthorPage{},
+			query:   []string{"minconf"},
+			errs:    []int{404},
+			handler: c(cacheDefault, AuthorHandler(db, chain)),
+		},
+		{
+			path:    fmt.Sprintf("block/{hash:%s}", sha2re),
+			summary: "A block along with the bulletins it confirmed",
+			resp:    &BlockResp{},
+			errs:    []int{404, 410},
+			handler: c(cacheDefault, BlockHandler(db, chain)),
+		},
+		{
+			path:    "block/height/{height:[0-9]{1,19}}",
+			summary: "The block at a height, if the public record holds it",
+			resp:    &BlockResp{},
+			errs:    []int{400, 404},
+			handler: c(cacheDefault, BlockHeightHandler(db, chain)),
+		},
+		{
+			path:    "reorgs",
+			summary: "The blocks disconnected from the main chain and the bulletins they reverted",
+			resp:    []*Reorg{},
+			handler: ReorgsHandler(chain),
+		},
+		{
+			path:    "tip",
+			summary: "The head of the highest block in the public record",
+			resp:    &ombjson.JsonBlkHead{},
+			errs:    []int{404},
+			handler: c(cacheDefault, TipHandler(chain)),
+		},
+		{
+			path:    fmt.Sprintf("blockhead/{hash:%s}", sha2re),
+			summary: "The head of a block",
+			resp:    &ombjson.JsonBlkHead{},
+			errs:    []int{404, 410},
+			handler: c(cacheDefault, BlockHeadHandler(db, chain)),
+		},
+		{
+			path:    fmt.Sprintf("board/{board:%s}", boardre),
+			summary: "A board along with every bulletin posted to it",
+			resp:    &BoardResp{},
+			paged:   &BoardPage{},
+			query:   []string{"minconf"},
+			errs:    []int{404},
+			handler: c(cacheDefault, BoardHandler(db, chain)),
+		},
+		{
+			path:    "blacklist",
+			summary: "Every censored bulletin along with the reason it was censored",
+			resp:    []*ombjson.BlacklistEntry{},
+			handler: c(cacheDefault, BlacklistHandler(db)),
+		},
+		{
+			path:    "blacklist/signed",
+			summary: "The blacklist signed by the key of the operator for other operators to import",
+			resp:    &SignedBlacklist{},
+			errs:    []int{501},
+			handler: c(cacheDefault, SignedBlacklistHandler(db, opts.Blacklist, opts.SigningKey, opts.Net)),
+		},
+		{
+			path:    "transparency",
+			summary: "A summary of every censored bulletin by reason, board, month added and source",
+			resp:    &TransparencyReport{},
+			handler: c(cacheDefault, TransparencyHandler(db, opts.Blacklist)),
+		},
+		{
+			path:    fmt.Sprintf("transparency/{txid:%s}", sha2re),
+			summary: "Why, when and by whom a single bulletin was censored",
+			resp:    &CensorRecord{},
+			errs:    []int{404},
+			handler: c(cacheDefault, TransparencyRecordHandler(db, opts.Blacklist)),
+		},
+		{
+			path:    "nilboard",
+			summary: "The bulletins that were not posted to a board",
+			resp:    &BoardResp{},
+			paged:   &BoardPage{},
+			query:   []string{"minconf"},
+			errs:    []int{404},
+			handler: c(cacheDefault, NilBoardHandler(db, chain)),
+		},
+
+		// Aggregate handlers
+		{
+			path:    "boards",
+			summary: "A summary of every board",
+			resp:    []*ombjson.BoardSummary{},
+			paged:   Page{Items: []*ombjson.BoardSummary{}},
+			handler: c(cacheDefault, AllBoardsHandler(db)),
+		},
+		{
+			path:    "recent",
+			summary: "The bulletins confirmed within the last 6 blocks or the given window",
+			resp:    bltns,
+			paged:   bltnPage,
+			query:   []string{"blocks", "since", "unconfirmed", "minconf"},
+			handler: c(cacheShort, RecentHandler(db, chain)),
+		},
+		{
+			path:    "unconfirmed",
+			summary: "The bulletins that are not yet in a block",
+			resp:    bltns,
+			paged:   bltnPage,
+			query:   []string{"minconf"},
+			handler: c(cacheShort, UnconfirmedHandler(db, chain)),
+		},
+		{
+			path:    "authors",
+			summary: "A summary of every author",
+			resp:    []*ombjson.AuthorSummary{},
+			paged:   Page{Items: []*ombjson.AuthorSummary{}},
+			handler: c(cacheDefault, AllAuthorsHandler(db)),
+		},
+		{
+			path:    fmt.Sprintf("blocks/{day:%s}", dayre),
+			summary: "The heads of the blocks found on a day given as YYYY-MM-DD or DD-MM-YYYY",
+			resp:    []*ombjson.JsonBlkHead{},
+			paged:   Page{Items: []*ombjson.JsonBlkHead{}},
+			query:   []string{"tz"},
+			errs:    []int{400, 404},
+			handler: c(cacheDefault, BlockDayHandler(db)),
+		},
+		{
+			path:    "calendar/{year:[0-9]{4}}/{month:[0-9]{1,2}}",
+			summary: "The number of blocks and bulletins found on every day of a month",
+			resp:    &Calendar{Days: []*CalendarDay{}},
+			query:   []string{"tz"},
+			errs:    []int{400},
+			handler: c(cacheDefault, CalendarHandler(db)),
+		},
+		{
+			path:    "blocks",
+			summary: "The heads of the blocks the public record holds within a range of heights",
+			resp:    []*ombjson.JsonBlkHead{},
+			query:   []string{"from", "to"},
+			errs:    []int{400},
+			handler: c(cacheDefault, BlockRangeHandler(chain)),
+		},
+		{
+			path:    "search",
+			summary: "A full text search over the messages and boards of bulletins",
+			resp:    &SearchResp{Results: []*SearchResult{}},
+			query:   []string{"q", "board", "author", "since", "until", "minconf", "limit", "cursor"},
+			handler: c(cacheDefault, SearchHandler(idx, chain)),
+		},
+
+		// Meta handlers
+		{
+			path:    "status",
+			summary: "The status of the store and of the api",
+			resp:    &StatusResp{},
+			handler: conditional(cacheNone, nil, StatusHandler(db, cache)),
+		},
+
+		// Admin handlers
+		{
+			path:    fmt.Sprintf("admin/blacklist/{txid:%s}", sha2re),
+			method:  "PUT",
+			summary: "Censors a bulletin",
+			req:     &BlacklistReq{},
+			resp:    &ombjson.BlacklistEntry{},
+			errs:    []int{401, 501},
+			admin:   true,
+			handler: BlacklistAddHandler(opts.Blacklist, opts.AdminTokens),
+		},
+		{
+			path:    fmt.Sprintf("admin/blacklist/{txid:%s}", sha2re),
+			method:  "DELETE",
+			summary: "Lifts the censorship of a bulletin that an operator censored",
+			status:  204,
+			errs:    []int{401, 404, 409, 501},
+			admin:   true,
+			handler: BlacklistRemoveHandler(opts.Blacklist, opts.AdminTokens),
+		},
+		{
+			path:    "admin/blacklist/import",
+			method:  "POST",
+			summary: "Imports the signed blacklist of an issuer that is subscribed to",
+			req:     &SignedBlacklist{},
+			resp:    &BlacklistSubscription{},
+			errs:    []int{401, 403, 409, 501},
+			admin:   true,
+			handler: ImportBlacklistHandler(opts.Blacklist, opts.AdminTokens, opts.Net),
+		},
+		{
+			path:    "admin/subscriptions",
+			summary: "The issuers whose blacklists are imported",
+			resp:    []BlacklistSubscription{},
+			errs:    []int{401, 501},
+			admin:   true,
+			handler: SubscriptionsHandler(opts.Blacklist, opts.AdminTokens),
+		},
+		{
+			path:    fmt.Sprintf("admin/subscriptions/{issuer:%s}", addrgex),
+			method:  "PUT",
+			summary: "Subscribes to the blacklists signed by an issuer",
+			req:     &SubscribeReq{},
+			status:  204,
+			errs:    []int{400, 401, 501},
+			admin:   true,
+			handler: SubscribeHandler(opts.Blacklist, opts.AdminTokens),
+		},
+		{
+			path:    fmt.Sprintf("admin/subscriptions/{issuer:%s}", addrgex),
+			method:  "DELETE",
+			summary: "Drops the blacklist of an issuer",
+			status:  204,
+			errs:    []int{401, 404, 501},
+			admin:   true,
+			handler: UnsubscribeHandler(opts.Blacklist, opts.AdminTokens),
+		},
+		{
+			path:    fmt.Sprintf("admin/subscriptions/{issuer:%s}/list", addrgex),
+			method:  "PUT",
+			summary: "Fetches and imports the latest blacklist of an issuer from the url subscribed with",
+			resp:    &BlacklistSubscription{},
+			errs:    []int{401, 404, 409, 501, 502},
+			admin:   true,
+			handler: RefreshHandler(opts.Blacklist, opts.AdminTokens, opts.Net),
+		},
+		{
+			path:    fmt.Sprintf("admin/overrides/{txid:%s}", sha2re),
+			method:  "PUT",
+			summary: "Serves a bulletin even though a blacklist subscribed to censors it",
+			req:     &BlacklistReq{},
+			status:  204,
+			errs:    []int{401, 501},
+			admin:   true,
+			handler: AllowHandler(opts.Blacklist, opts.AdminTokens),
+		},
+		{
+			path:    fmt.Sprintf("admin/overrides/{txid:%s}", sha2re),
+			method:  "DELETE",
+			summary: "Drops an override",
+			status:  204,
+			errs:    []int{401, 404, 501},
+			admin:   true,
+			handler: DisallowHandler(opts.Blacklist, opts.AdminTokens),
+		},
+		{
+			path:    "admin/audit",
+			summary: "Every change that operators made to the blacklist",
+			resp:    []AuditEntry{},
+			errs:    []int{401, 501},
+			admin:   true,
+			handler: AuditHandler(opts.Blacklist, opts.AdminTokens),
+		},
+
+		// Push handlers
+		{
+			path:    "stream",
+			summary: "A stream of Server-Sent Events for new bulletins, confirmations and blocks",
+			media:   "text/event-stream",
+			errs:    []int{501},
+			handler: StreamHandler(opts.Feed),
+		},
+		{
+			path:    "ws",
+			summary: "A websocket that pushes the events of the topics a client subscribes to",
+			status:  101,
+			errs:    []int{501},
+			handler: WebSocketHandler(opts.Feed),
+		},
+	}
+}
+
+// NewHandler is Handler configured by opts.
+func NewHandler(prefix string, db Store, opts Options) http.Handler {
+	return newRouter(prefix, newRoutes(db, opts))
+}
+
+// newRouter serves routes under prefix along with an OpenAPI document that
+// describes them.
+func newRouter(prefix string, routes []route) http.Handler {
 
 	r := mux.NewRouter()
-	sha2re := "([a-f]|[A-F]|[0-9]){64}"
-	addrgex := "([a-z]|[A-Z]|[0-9]){30,35}"
-	// Since the board's path could be percent encoded we give it 3x wiggle room
-	// since a single byte in percent encoding is %EE.
-	boardre := ".{1,90}"
-
-	// A single day follows this format: DD-MM-YY
-	dayre := `[0-9]{1,2}-[0-9]{1,2}-[0-9]{4}`
-
-	p := prefix
-	// Item handlers
-	r.HandleFunc(p+fmt.Sprintf("bulletin/{txid:%s}", sha2re), BulletinHandler(db))
-	r.HandleFunc(p+fmt.Sprintf("author/{addr:%s}", addrgex), AuthorHandler(db))
-	r.HandleFunc(p+fmt.Sprintf("block/{hash:%s}", sha2re), BlockHandler(db))
-	r.HandleFunc(p+fmt.Sprintf("blockhead/{hash:%s}", sha2re), BlockHeadHandler(db))
-	r.HandleFunc(p+fmt.Sprintf("board/{board:%s}", boardre), BoardHandler(db))
-	r.HandleFunc(p+"blacklist", BlacklistHandler(db))
-	r.HandleFunc(p+"nilboard", NilBoardHandler(db))
-
-	// Aggregate handlers
-	r.HandleFunc(p+"boards", AllBoardsHandler(db))
-	r.HandleFunc(p+"recent", RecentHandler(db))
-	r.HandleFunc(p+"unconfirmed", UnconfirmedHandler(db))
-	r.HandleFunc(p+"authors", AllAuthorsHandler(db))
-	r.HandleFunc(p+fmt.Sprintf("blocks/{day:%s}", dayre), BlockDayHandler(db))
-
-	// Meta handlers
-	r.HandleFunc(p+"status", StatusHandler(db))
+
+	// The document describes itself so it is added once every route is known.
+	var doc *OpenAPI
+	routes = append(routes, route{
+		path:    "openapi.json",
+		summary: "This OpenAPI document",
+		resp:    &OpenAPI{},
+		handler: conditional(cacheDefault, nil, func(w http.ResponseWriter, request *http.Request) {
+			OpenAPIHandler(doc)(w, request)
+		}),
+	})
+	doc = newOpenAPI(prefix, routes)
+
+	// Every route is served under the versioned api as well as at the legacy
+	// unversioned path.
+	v := prefix + "v1/"
+	for _, rt := range routes {
+		if rt.method != "" {
+			r.HandleFunc(v+rt.path, v1(rt.handler)).Methods(rt.method)
+			r.HandleFunc(prefix+rt.path, rt.handler).Methods(rt.method)
+			continue
+		}
+		r.HandleFunc(v+rt.path, v1(rt.handler))
+		r.HandleFunc(prefix+rt.path, rt.handler)
+	}
+
+	notFound := v1(func(w http.ResponseWriter, request *http.Request) {
+		writeError(w, request, 404, "No such endpoint")
+	})
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if strings.HasPrefix(request.URL.Path, v) {
+			notFound(w, request)
+			return
+		}
+		http.NotFound(w, request)
+	})
 
 	return r
 }
@@ -62,6 +62,28 @@ var statusCodeTests = []struct {
 	{"/blocks/111-990-2014", 404},
 	{"/status", 200},
 	{"/authors", 200},
+	// Pagination parameters
+	{"/authors?limit=2", 200},
+	{"/authors?limit=0", 400},
+	{"/boards?limit=ten", 400},
+	{"/unconfirmed?cursor=%21%21%21", 400},
+	{"/board/ahimsa-dev?limit=1", 200},
+	{"/author/miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH?limit=1", 200},
+	{"/blocks/01-11-2014?limit=2", 200},
+	// Syndication feeds
+	{"/board/ahimsa-dev/feed.atom", 200},
+	{"/board/ahimsa-dev/feed.rss", 200},
+	{"/board/this-One-Isnt-Real/feed.atom", 404},
+	{"/board/ahimsa-dev/feed.json", 404},
+	{"/author/miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH/feed.rss", 200},
+	{"/author/0000000000000000000000000000000000/feed.atom", 404},
+	{"/recent/feed.atom", 200},
+	{"/recent/feed.rss", 200},
+	// Full text search
+	{"/search?q=sun", 200},
+	{"/search?q=%22comes+the+sun%22&board=ahimsa-dev", 200},
+	{"/search", 400},
+	{"/search?q=sun&since=yesterday", 400},
 }
 
 // Runs a series of tests to assert the api is returning the correct status codes.
@@ -70,6 +92,11 @@ func TestStatusCodes(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
 
+	checkStatusCodes(t, ts)
+}
+
+func checkStatusCodes(t *testing.T, ts *httptest.Server) {
+
 	for _, testCase := range statusCodeTests {
 		url := ts.URL + testCase.endpoint
 		res, err := http.Get(url)
@@ -96,11 +123,11 @@ var responseTests = []struct {
 }{
 	{
 		endpoint: "/bulletin/f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf",
-		body:     `{"txid":"f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf","board":"ahimsa-dev","author":"mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c","msg":"Here comes the sun","timestamp":1413079355}`,
+		body:     `{"txid":"f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf","board":"ahimsa-dev","author":"mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c","msg":"Here comes the sun","timestamp":1413079355,"confirmations":0,"onMainChain":false}`,
 	},
 	{
 		endpoint: "/block/00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d",
-		body:     `{"head":{"hash":"00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d","prevHash":"00000000f1cb8c224acdb0e1becbfa4218f1e13b0d4dbbce64d0a3c15d8bf55f","timestamp":1414813562,"height":305724,"numBltns":1},"bltns":[{"txid":"b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be","board":"ahimsa-dev","author":"mnPZBNTrLoCoSkAgSfKeeCujU3129PG6vn","msg":"","timestamp":1413216499,"blk":"00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d","blkTimestamp":1414813562,"bannedReason":"The Beatles are slanderous."}]}`,
+		body:     `{"head":{"hash":"00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d","prevHash":"00000000f1cb8c224acdb0e1becbfa4218f1e13b0d4dbbce64d0a3c15d8bf55f","timestamp":1414813562,"height":305724,"numBltns":1},"bltns":[{"txid":"b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be","board":"ahimsa-dev","author":"mnPZBNTrLoCoSkAgSfKeeCujU3129PG6vn","msg":"","timestamp":1413216499,"blk":"00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d","blkTimestamp":1414813562,"bannedReason":"The Beatles are slanderous.","confirmations":1289,"onMainChain":true}]}`,
 	},
 	{
 		endpoint: "/blacklist",
@@ -108,28 +135,28 @@ var responseTests = []struct {
 	},
 	{
 		endpoint: "/author/miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH",
-		body:     `{"author":{"addr":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","numBltns":2,"firstBlkTs":1414017952},"bltns":[{"txid":"2963cc35727f4e2c2bd4186e4550fe82b204e446ff7096b425f236264e05c7c6","board":"ahimsa-dev","author":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","msg":"pier and ocean ![mondrian 1915](http://img.ahimsa.io/85rEC0DJiWJyTxOct2dxJI8od1yhcIb5WsYvxGiJ7pY=)","timestamp":1414193281},{"txid":"933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9","board":"ahimsa-dev","author":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","msg":"the mind is our medium","timestamp":1414017848,"blk":"00000000000016b6ff59b9fffcade68943bb02270b46d2a001054d95c56ca8ad","blkTimestamp":1414017952}]}`,
+		body:     `{"author":{"addr":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","numBltns":2,"firstBlkTs":1414017952},"bltns":[{"txid":"2963cc35727f4e2c2bd4186e4550fe82b204e446ff7096b425f236264e05c7c6","board":"ahimsa-dev","author":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","msg":"pier and ocean ![mondrian 1915](http://img.ahimsa.io/85rEC0DJiWJyTxOct2dxJI8od1yhcIb5WsYvxGiJ7pY=)","timestamp":1414193281,"confirmations":0,"onMainChain":false},{"txid":"933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9","board":"ahimsa-dev","author":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","msg":"the mind is our medium","timestamp":1414017848,"blk":"00000000000016b6ff59b9fffcade68943bb02270b46d2a001054d95c56ca8ad","blkTimestamp":1414017952,"confirmations":2484,"onMainChain":true}]}`,
 	},
 	{
 		endpoint: "/board/ahimsa-dev",
-		body:     `{"summary":{"name":"ahimsa-dev","numBltns":4,"createdAt":1414017952,"lastActive":1414193281,"createdBy":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH"},"bltns":[{"txid":"f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf","board":"ahimsa-dev","author":"mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c","msg":"Here comes the sun","timestamp":1413079355},{"txid":"2963cc35727f4e2c2bd4186e4550fe82b204e446ff7096b425f236264e05c7c6","board":"ahimsa-dev","author":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","msg":"pier and ocean ![mondrian 1915](http://img.ahimsa.io/85rEC0DJiWJyTxOct2dxJI8od1yhcIb5WsYvxGiJ7pY=)","timestamp":1414193281},{"txid":"933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9","board":"ahimsa-dev","author":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","msg":"the mind is our medium","timestamp":1414017848,"blk":"00000000000016b6ff59b9fffcade68943bb02270b46d2a001054d95c56ca8ad","blkTimestamp":1414017952},{"txid":"b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be","board":"ahimsa-dev","author":"mnPZBNTrLoCoSkAgSfKeeCujU3129PG6vn","msg":"","timestamp":1413216499,"blk":"00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d","blkTimestamp":1414813562,"bannedReason":"The Beatles are slanderous."}]}`,
+		body:     `{"summary":{"name":"ahimsa-dev","numBltns":4,"createdAt":1414017952,"lastActive":1414193281,"createdBy":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH"},"bltns":[{"txid":"f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf","board":"ahimsa-dev","author":"mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c","msg":"Here comes the sun","timestamp":1413079355,"confirmations":0,"onMainChain":false},{"txid":"2963cc35727f4e2c2bd4186e4550fe82b204e446ff7096b425f236264e05c7c6","board":"ahimsa-dev","author":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","msg":"pier and ocean ![mondrian 1915](http://img.ahimsa.io/85rEC0DJiWJyTxOct2dxJI8od1yhcIb5WsYvxGiJ7pY=)","timestamp":1414193281,"confirmations":0,"onMainChain":false},{"txid":"933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9","board":"ahimsa-dev","author":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","msg":"the mind is our medium","timestamp":1414017848,"blk":"00000000000016b6ff59b9fffcade68943bb02270b46d2a001054d95c56ca8ad","blkTimestamp":1414017952,"confirmations":2484,"onMainChain":true},{"txid":"b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be","board":"ahimsa-dev","author":"mnPZBNTrLoCoSkAgSfKeeCujU3129PG6vn","msg":"","timestamp":1413216499,"blk":"00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d","blkTimestamp":1414813562,"bannedReason":"The Beatles are slanderous.","confirmations":1289,"onMainChain":true}]}`,
 	},
 	// Test character encoding of board in bulletin, ensure that the output is utf-8 encoded
 	{
 		endpoint: "/bulletin/5ed76ba84d4116045df14ecf7a7eca86300a649ef3cbefdd2eeea3f84e1432dc",
-		body:     `{"txid":"5ed76ba84d4116045df14ecf7a7eca86300a649ef3cbefdd2eeea3f84e1432dc","board":"#!~*Enc ded-boÄ\u0026\\/Ӂ","author":"mhDrE934aiWYESLKbxZjUsMBZBSHUbiZRw","msg":"Attempting to comply with RFC 3986. Россия","timestamp":1414897285}`,
+		body:     `{"txid":"5ed76ba84d4116045df14ecf7a7eca86300a649ef3cbefdd2eeea3f84e1432dc","board":"#!~*Enc ded-boÄ\u0026\\/Ӂ","author":"mhDrE934aiWYESLKbxZjUsMBZBSHUbiZRw","msg":"Attempting to comply with RFC 3986. Россия","timestamp":1414897285,"confirmations":0,"onMainChain":false}`,
 	},
 	{
 		endpoint: "/recent",
-		body:     `[{"txid":"5df96dcb607701d19f7ae3a5da2708d834df7dc8ff505d74aa27dc82aeb7b3c1","board":"recent-test","author":"n1j3AYj82gnWmLnmFbTcF4GDxHNWNGyxG1","msg":"This is a test to see if recent confirmations works in the expected way.","timestamp":1415854832,"blk":"00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff","blkTimestamp":1415862580}]`,
+		body:     `[{"txid":"5df96dcb607701d19f7ae3a5da2708d834df7dc8ff505d74aa27dc82aeb7b3c1","board":"recent-test","author":"n1j3AYj82gnWmLnmFbTcF4GDxHNWNGyxG1","msg":"This is a test to see if recent confirmations works in the expected way.","timestamp":1415854832,"blk":"00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff","blkTimestamp":1415862580,"confirmations":1,"onMainChain":true}]`,
 	},
 	{
 		endpoint: "/unconfirmed",
-		body:     `[{"txid":"f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf","board":"ahimsa-dev","author":"mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c","msg":"Here comes the sun","timestamp":1413079355},{"txid":"2963cc35727f4e2c2bd4186e4550fe82b204e446ff7096b425f236264e05c7c6","board":"ahimsa-dev","author":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","msg":"pier and ocean ![mondrian 1915](http://img.ahimsa.io/85rEC0DJiWJyTxOct2dxJI8od1yhcIb5WsYvxGiJ7pY=)","timestamp":1414193281},{"txid":"5ed76ba84d4116045df14ecf7a7eca86300a649ef3cbefdd2eeea3f84e1432dc","board":"#!~*Enc ded-boÄ\u0026\\/Ӂ","author":"mhDrE934aiWYESLKbxZjUsMBZBSHUbiZRw","msg":"Attempting to comply with RFC 3986. Россия","timestamp":1414897285},{"txid":"126484de57d01ab12ae19dfc7c4eb74087e6abb8e749badecc75d570ad577fa3","author":"mxmvvxMNaXvPPnU5vHXPoPEsrHbbnSAehh","msg":"This should be in the nil board.","timestamp":1414900834}]`,
+		body:     `[{"txid":"f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf","board":"ahimsa-dev","author":"mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c","msg":"Here comes the sun","timestamp":1413079355,"confirmations":0,"onMainChain":false},{"txid":"2963cc35727f4e2c2bd4186e4550fe82b204e446ff7096b425f236264e05c7c6","board":"ahimsa-dev","author":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","msg":"pier and ocean ![mondrian 1915](http://img.ahimsa.io/85rEC0DJiWJyTxOct2dxJI8od1yhcIb5WsYvxGiJ7pY=)","timestamp":1414193281,"confirmations":0,"onMainChain":false},{"txid":"5ed76ba84d4116045df14ecf7a7eca86300a649ef3cbefdd2eeea3f84e1432dc","board":"#!~*Enc ded-boÄ\u0026\\/Ӂ","author":"mhDrE934aiWYESLKbxZjUsMBZBSHUbiZRw","msg":"Attempting to comply with RFC 3986. Россия","timestamp":1414897285,"confirmations":0,"onMainChain":false},{"txid":"126484de57d01ab12ae19dfc7c4eb74087e6abb8e749badecc75d570ad577fa3","author":"mxmvvxMNaXvPPnU5vHXPoPEsrHbbnSAehh","msg":"This should be in the nil board.","timestamp":1414900834,"confirmations":0,"onMainChain":false}]`,
 	},
 	{
 		endpoint: "/nilboard",
-		body:     `{"summary":{"name":"","numBltns":1,"createdAt":0,"lastActive":1414900834,"createdBy":"mxmvvxMNaXvPPnU5vHXPoPEsrHbbnSAehh"},"bltns":[{"txid":"126484de57d01ab12ae19dfc7c4eb74087e6abb8e749badecc75d570ad577fa3","author":"mxmvvxMNaXvPPnU5vHXPoPEsrHbbnSAehh","msg":"This should be in the nil board.","timestamp":1414900834}]}`,
+		body:     `{"summary":{"name":"","numBltns":1,"createdAt":0,"lastActive":1414900834,"createdBy":"mxmvvxMNaXvPPnU5vHXPoPEsrHbbnSAehh"},"bltns":[{"txid":"126484de57d01ab12ae19dfc7c4eb74087e6abb8e749badecc75d570ad577fa3","author":"mxmvvxMNaXvPPnU5vHXPoPEsrHbbnSAehh","msg":"This should be in the nil board.","timestamp":1414900834,"confirmations":0,"onMainChain":false}]}`,
 	},
 	{
 		endpoint: "/blocks/01-11-2014",
@@ -143,6 +170,23 @@ var responseTests = []struct {
 		endpoint: "/block/00000000efaee711979fe42e667188e50b1096e4d9cfcbc9a82101336189c2ca",
 		body:     `{"head":{"hash":"00000000efaee711979fe42e667188e50b1096e4d9cfcbc9a82101336189c2ca","prevHash":"00000000ef99c1e689c70bf2eaddbef5dc41412dfc0c350226d9caa850da307c","timestamp":1414800258,"height":305698,"numBltns":0},"bltns":[]}`,
 	},
+	// Paginated responses are wrapped in an envelope with a next cursor
+	{
+		endpoint: "/authors?limit=2",
+		body:     `{"items":[{"addr":"mhDrE934aiWYESLKbxZjUsMBZBSHUbiZRw","numBltns":1},{"addr":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH","numBltns":2,"firstBlkTs":1414017952}],"next":"bWlVRGNQOG9iVUtQaHFrckJyUXo1N3NiU2cyTXoxa1pYSA=="}`,
+	},
+	{
+		endpoint: "/authors?limit=1&cursor=bWlVRGNQOG9iVUtQaHFrckJyUXo1N3NiU2cyTXoxa1pYSA==",
+		body:     `{"items":[{"addr":"mnPZBNTrLoCoSkAgSfKeeCujU3129PG6vn","numBltns":1,"firstBlkTs":1414813562}],"next":"bW5QWkJOVHJMb0NvU2tBZ1NmS2VlQ3VqVTMxMjlQRzZ2bg=="}`,
+	},
+	{
+		endpoint: "/board/ahimsa-dev?limit=1",
+		body:     `{"summary":{"name":"ahimsa-dev","numBltns":4,"createdAt":1414017952,"lastActive":1414193281,"createdBy":"miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH"},"bltns":[{"txid":"f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf","board":"ahimsa-dev","author":"mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c","msg":"Here comes the sun","timestamp":1413079355,"confirmations":0,"onMainChain":false}],"next":"MDAwMDAwMDAwMDE0MTMwNzkzNTU6Zjc4MDA3MTJjMjAzNzdjMmQyOTY4MGMxYWVjZjIzMzFkNmY4MGY1YTQ0NTEwZDMwY2ViMmUzMGZkNWRhZmRjZg=="}`,
+	},
+	{
+		endpoint: "/recent?limit=5",
+		body:     `{"items":[{"txid":"5df96dcb607701d19f7ae3a5da2708d834df7dc8ff505d74aa27dc82aeb7b3c1","board":"recent-test","author":"n1j3AYj82gnWmLnmFbTcF4GDxHNWNGyxG1","msg":"This is a test to see if recent confirmations works in the expected way.","timestamp":1415854832,"blk":"00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff","blkTimestamp":1415862580,"confirmations":1,"onMainChain":true}]}`,
+	},
 }
 
 // Executes tests to verify that the json returned at an endpoint is correct
@@ -151,6 +195,11 @@ func TestResponses(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
 
+	checkResponses(t, ts)
+}
+
+func checkResponses(t *testing.T, ts *httptest.Server) {
+
 	for _, testCase := range responseTests {
 		url := ts.URL + testCase.endpoint
 		res, err := http.Get(url)
@@ -0,0 +1,443 @@
+package ahimsarest
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+	"github.com/soapboxsys/ombudslib/pubrecdb"
+)
+
+// A MemStore is a Store that keeps the whole public record in memory. It is
+// meant for tests and for small deployments that are fed by hand. The zero
+// value is not usable, use NewMemStore.
+type MemStore struct {
+	mu        sync.RWMutex
+	blocks    map[string]*ombjson.JsonBlkHead
+	bltns     map[string]*ombjson.JsonBltn
+	blacklist map[string]string
+}
+
+// NewMemStore returns an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{
+		blocks:    make(map[string]*ombjson.JsonBlkHead),
+		bltns:     make(map[string]*ombjson.JsonBltn),
+		blacklist: make(map[string]string),
+	}
+}
+
+// AddBlock stores the head of a block. The number of bulletins it holds is
+// computed by the store.
+func (s *MemStore) AddBlock(head *ombjson.JsonBlkHead) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := *head
+	s.blocks[h.Hash] = &h
+}
+
+// AddBltn stores a bulletin. A bulletin that names a block in Blk is confirmed
+// by it and its BlkTimestamp is taken from that block.
+func (s *MemStore) AddBltn(bltn *ombjson.JsonBltn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := *bltn
+	b.BlkTimestamp, b.BannedReason = 0, ""
+	s.bltns[b.Txid] = &b
+}
+
+// RemoveBlock forgets a block. The bulletins it confirmed become unconfirmed.
+func (s *MemStore) RemoveBlock(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blocks, hash)
+	for _, bltn := range s.bltns {
+		if bltn.Blk == hash {
+			bltn.Blk = ""
+		}
+	}
+}
+
+// Blacklist censors the bulletin with txid for the given reason.
+func (s *MemStore) Blacklist(txid, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blacklist[txid] = reason
+}
+
+// SyncedHeight returns the height of the highest block held so that a
+// MemStore can be a Replica.
+func (s *MemStore) SyncedHeight() (uint64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if tip := s.tip(); tip != nil {
+		return tip.Height, true, nil
+	}
+	return 0, false, nil
+}
+
+// BlockHash returns the hash of the block held at height. Where several blocks
+// share a height the lowest hash is returned.
+func (s *MemStore) BlockHash(height uint64) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash := ""
+	for _, blk := range s.blocks {
+		if blk.Height == height && (hash == "" || blk.Hash < hash) {
+			hash = blk.Hash
+		}
+	}
+	return hash, hash != "", nil
+}
+
+// DropBlocks forgets every block at height or above and the bulletins they
+// confirmed.
+func (s *MemStore) DropBlocks(height uint64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := make(map[string]bool)
+	for hash, blk := range s.blocks {
+		if blk.Height >= height {
+			dropped[hash] = true
+			delete(s.blocks, hash)
+		}
+	}
+	for txid, bltn := range s.bltns {
+		if dropped[bltn.Blk] {
+			delete(s.bltns, txid)
+		}
+	}
+	return len(dropped), nil
+}
+
+// PutUnconfirmed stores unconfirmed bulletins and forgets the unconfirmed
+// bulletins held that are not among them.
+func (s *MemStore) PutUnconfirmed(bltns []*ombjson.JsonBltn) (int, error) {
+
+	keep := make(map[string]bool)
+	for _, bltn := range bltns {
+		keep[bltn.Txid] = true
+	}
+
+	s.mu.Lock()
+	pruned := 0
+	for txid, bltn := range s.bltns {
+		if _, ok := s.blocks[bltn.Blk]; !ok && !keep[txid] {
+			delete(s.bltns, txid)
+			pruned++
+		}
+	}
+	s.mu.Unlock()
+
+	return pruned, s.PutBulletins(bltns)
+}
+
+// PutBlock stores a block and the bulletins it confirmed. Censored bulletins
+// are blacklisted.
+func (s *MemStore) PutBlock(head *ombjson.JsonBlkHead, bltns []*ombjson.JsonBltn) error {
+	s.AddBlock(head)
+	return s.PutBulletins(bltns)
+}
+
+// PutBulletins stores bulletins. Censored bulletins are blacklisted.
+func (s *MemStore) PutBulletins(bltns []*ombjson.JsonBltn) error {
+	for _, bltn := range bltns {
+		s.AddBltn(bltn)
+		if bltn.BannedReason != "" {
+			s.Blacklist(bltn.Txid, bltn.BannedReason)
+		}
+	}
+	return nil
+}
+
+// view returns a copy of a stored bulletin as it should be served. The caller
+// must hold the lock.
+func (s *MemStore) view(b *ombjson.JsonBltn) *ombjson.JsonBltn {
+	bltn := *b
+	if blk, ok := s.blocks[bltn.Blk]; ok {
+		bltn.BlkTimestamp = blk.Timestamp
+	} else {
+		bltn.Blk = ""
+	}
+	if reason, ok := s.blacklist[bltn.Txid]; ok {
+		bltn.Msg = ""
+		bltn.BannedReason = reason
+	}
+	return &bltn
+}
+
+// selectBltns returns every bulletin that satisfies keep. Unconfirmed bulletins
+// come first ordered by their timestamp, followed by the confirmed bulletins
+// ordered by the time of their block. The caller must hold the lock.
+func (s *MemStore) selectBltns(keep func(*ombjson.JsonBltn) bool) []*ombjson.JsonBltn {
+
+	bltns := []*ombjson.JsonBltn{}
+	for _, b := range s.bltns {
+		if bltn := s.view(b); keep(bltn) {
+			bltns = append(bltns, bltn)
+		}
+	}
+	sort.Sort(memBltnOrder(bltns))
+
+	return bltns
+}
+
+type memBltnOrder []*ombjson.JsonBltn
+
+func (o memBltnOrder) Len() int      { return len(o) }
+func (o memBltnOrder) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o memBltnOrder) Less(i, j int) bool {
+	a, b := o[i], o[j]
+	if (a.Blk == "") != (b.Blk == "") {
+		return a.Blk == ""
+	}
+	if a.BlkTimestamp != b.BlkTimestamp {
+		return a.BlkTimestamp < b.BlkTimestamp
+	}
+	return bltnKey(a) < bltnKey(b)
+}
+
+// tip returns the head of the highest block. The caller must hold the lock.
+func (s *MemStore) tip() *ombjson.JsonBlkHead {
+	var tip *ombjson.JsonBlkHead
+	for _, blk := range s.blocks {
+		if tip == nil || blk.Height > tip.Height {
+			tip = blk
+		}
+	}
+	return tip
+}
+
+func (s *MemStore) head(blk *ombjson.JsonBlkHead) *ombjson.JsonBlkHead {
+	h := *blk
+	h.NumBltns = 0
+	for _, bltn := range s.bltns {
+		if bltn.Blk == h.Hash {
+			h.NumBltns++
+		}
+	}
+	return &h
+}
+
+func (s *MemStore) GetJsonBltn(txid string) (*ombjson.JsonBltn, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b, ok := s.bltns[txid]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if _, ok := s.blacklist[txid]; ok {
+		return nil, pubrecdb.ErrBltnCensored
+	}
+
+	return s.view(b), nil
+}
+
+func (s *MemStore) GetJsonBlock(hash string) (*ombjson.JsonBlock, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blk, ok := s.blocks[hash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	bltns := s.selectBltns(func(b *ombjson.JsonBltn) bool { return b.Blk == hash })
+	return &ombjson.JsonBlock{Head: s.head(blk), Bltns: bltns}, nil
+}
+
+func (s *MemStore) GetJsonBlockHead(hash string) (*ombjson.JsonBlkHead, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blk, ok := s.blocks[hash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return s.head(blk), nil
+}
+
+func (s *MemStore) GetJsonAuthor(addr string) (*ombjson.AuthorResp, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bltns := s.selectBltns(func(b *ombjson.JsonBltn) bool { return b.Author == addr })
+	if len(bltns) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return &ombjson.AuthorResp{Author: authorSummary(addr, bltns), Bltns: bltns}, nil
+}
+
+func authorSummary(addr string, bltns []*ombjson.JsonBltn) *ombjson.AuthorSummary {
+	summary := &ombjson.AuthorSummary{Address: addr}
+	for _, bltn := range bltns {
+		summary.NumBltns++
+		if bltn.BlkTimestamp != 0 && (summary.FirstBlkTs == 0 || bltn.BlkTimestamp < summary.FirstBlkTs) {
+			summary.FirstBlkTs = bltn.BlkTimestamp
+		}
+	}
+	return summary
+}
+
+func (s *MemStore) GetJsonBlacklist() ([]*ombjson.BlacklistEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	txids := []string{}
+	for txid := range s.blacklist {
+		txids = append(txids, txid)
+	}
+	sort.Strings(txids)
+
+	entries := []*ombjson.BlacklistEntry{}
+	for _, txid := range txids {
+		entries = append(entries, &ombjson.BlacklistEntry{Txid: txid, Reason: s.blacklist[txid]})
+	}
+
+	return entries, nil
+}
+
+func (s *MemStore) GetWholeBoard(board string) (*ombjson.WholeBoard, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bltns := s.selectBltns(func(b *ombjson.JsonBltn) bool { return b.Board == board })
+	if len(bltns) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return &ombjson.WholeBoard{Summary: boardSummary(board, bltns), Bltns: bltns}, nil
+}
+
+// boardSummary describes a board. The board was created by the author of its
+// first confirmed bulletin, or its first bulletin if none are confirmed.
+func boardSummary(name string, bltns []*ombjson.JsonBltn) *ombjson.BoardSummary {
+	summary := &ombjson.BoardSummary{Name: name}
+	var first *ombjson.JsonBltn
+	for _, bltn := range bltns {
+		summary.NumBltns++
+		if bltn.Timestamp > summary.LastActive {
+			summary.LastActive = bltn.Timestamp
+		}
+		if first == nil || (first.Blk == "" && bltn.Blk != "") {
+			first = bltn
+		}
+	}
+	summary.CreatedAt = first.BlkTimestamp
+	summary.CreatedBy = first.Author
+	return summary
+}
+
+func (s *MemStore) GetAllBoards() ([]*ombjson.BoardSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byBoard := make(map[string][]*ombjson.JsonBltn)
+	for _, bltn := range s.selectBltns(func(b *ombjson.JsonBltn) bool { return b.Board != "" }) {
+		byBoard[bltn.Board] = append(byBoard[bltn.Board], bltn)
+	}
+
+	boards := []*ombjson.BoardSummary{}
+	for name, bltns := range byBoard {
+		boards = append(boards, boardSummary(name, bltns))
+	}
+	sort.Sort(boardsByName(boards))
+
+	return boards, nil
+}
+
+func (s *MemStore) GetAllAuthors() ([]*ombjson.AuthorSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byAuthor := make(map[string][]*ombjson.JsonBltn)
+	for _, bltn := range s.selectBltns(func(*ombjson.JsonBltn) bool { return true }) {
+		byAuthor[bltn.Author] = append(byAuthor[bltn.Author], bltn)
+	}
+
+	authors := []*ombjson.AuthorSummary{}
+	for addr, bltns := range byAuthor {
+		authors = append(authors, authorSummary(addr, bltns))
+	}
+	sort.Sort(authorsByAddr(authors))
+
+	return authors, nil
+}
+
+// GetRecentConf returns the bulletins confirmed within the last n blocks.
+func (s *MemStore) GetRecentConf(n int) ([]*ombjson.JsonBltn, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tip := s.tip()
+	if tip == nil {
+		return []*ombjson.JsonBltn{}, nil
+	}
+
+	return s.selectBltns(func(b *ombjson.JsonBltn) bool {
+		blk, ok := s.blocks[b.Blk]
+		return ok && blk.Height+uint64(n) > tip.Height
+	}), nil
+}
+
+func (s *MemStore) GetUnconfirmed() ([]*ombjson.JsonBltn, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.selectBltns(func(b *ombjson.JsonBltn) bool { return b.Blk == "" }), nil
+}
+
+// GetBlocksByDay returns the heads of the blocks found within the 24 hours
+// that follow day ordered by height.
+func (s *MemStore) GetBlocksByDay(day time.Time) ([]*ombjson.JsonBlkHead, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start, end := day.Unix(), day.Add(24*time.Hour).Unix()
+	blks := []*ombjson.JsonBlkHead{}
+	for _, blk := range s.blocks {
+		if blk.Timestamp >= start && blk.Timestamp < end {
+			blks = append(blks, s.head(blk))
+		}
+	}
+	if len(blks) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	sort.Sort(blksByKey(blks))
+
+	return blks, nil
+}
+
+func (s *MemStore) GetDBStatus() (*ombjson.Status, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := &ombjson.Status{
+		BlkCount:  uint64(len(s.blocks)),
+		BltnCount: uint64(len(s.bltns)),
+	}
+	for _, blk := range s.blocks {
+		if blk.Timestamp > status.LatestBlk {
+			status.LatestBlk = blk.Timestamp
+		}
+	}
+	for _, bltn := range s.bltns {
+		if bltn.Timestamp > status.LatestBltn {
+			status.LatestBltn = bltn.Timestamp
+		}
+	}
+
+	return status, nil
+}
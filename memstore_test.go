@@ -0,0 +1,65 @@
+package ahimsarest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// newTestMemStore fills a MemStore with the same records as the test db that
+// ships with pubrecdb.
+func newTestMemStore() *MemStore {
+
+	store := NewMemStore()
+
+	blocks := []*ombjson.JsonBlkHead{
+		{Hash: "000000009eca8c144e1be1daddee437e14d92c2379ed70adbb586c6b9a5610f4", PrevHash: "0000000083f4f28cd2061073754383baeb38d3639e153b18e643268771e27f12", Timestamp: 1414801097, Height: 305694},
+		{Hash: "00000000efaee711979fe42e667188e50b1096e4d9cfcbc9a82101336189c2ca", PrevHash: "00000000ef99c1e689c70bf2eaddbef5dc41412dfc0c350226d9caa850da307c", Timestamp: 1414800258, Height: 305698},
+		{Hash: "000000002f21b1943beb5c07a35fb89238b6dcd42312d39789b4d1b19b83f08a", PrevHash: "00000000efaee711979fe42e667188e50b1096e4d9cfcbc9a82101336189c2ca", Timestamp: 1414801459, Height: 305699},
+		{Hash: "00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d", PrevHash: "00000000f1cb8c224acdb0e1becbfa4218f1e13b0d4dbbce64d0a3c15d8bf55f", Timestamp: 1414813562, Height: 305724},
+		{Hash: "00000000000016b6ff59b9fffcade68943bb02270b46d2a001054d95c56ca8ad", PrevHash: "000000000000205fa5a2b5c57edc2e1a3d2f5ba8a2efcfaa36b05dbb6ddbcd67", Timestamp: 1414017952, Height: 304529},
+		{Hash: "00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff", PrevHash: "000000003ec62f4be3e4dd0b3aa8d0c34bf2ef2cd3a20e85c1c2e95b83d8d48e", Timestamp: 1415862580, Height: 307012},
+	}
+	for _, blk := range blocks {
+		store.AddBlock(blk)
+	}
+
+	bltns := []*ombjson.JsonBltn{
+		{Txid: "f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf", Board: "ahimsa-dev", Author: "mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c", Msg: "Here comes the sun", Timestamp: 1413079355},
+		{Txid: "2963cc35727f4e2c2bd4186e4550fe82b204e446ff7096b425f236264e05c7c6", Board: "ahimsa-dev", Author: "miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH", Msg: "pier and ocean ![mondrian 1915](http://img.ahimsa.io/85rEC0DJiWJyTxOct2dxJI8od1yhcIb5WsYvxGiJ7pY=)", Timestamp: 1414193281},
+		{Txid: "933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9", Board: "ahimsa-dev", Author: "miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH", Msg: "the mind is our medium", Timestamp: 1414017848, Blk: "00000000000016b6ff59b9fffcade68943bb02270b46d2a001054d95c56ca8ad"},
+		{Txid: "b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be", Board: "ahimsa-dev", Author: "mnPZBNTrLoCoSkAgSfKeeCujU3129PG6vn", Msg: "Help! I'm being repressed!", Timestamp: 1413216499, Blk: "00000000777213b4fd7c5d5a71b9b52608356c4194203b1b63d1bb0e6141d17d"},
+		{Txid: "5ed76ba84d4116045df14ecf7a7eca86300a649ef3cbefdd2eeea3f84e1432dc", Board: "#!~*Enc ded-boÄ&\\/Ӂ", Author: "mhDrE934aiWYESLKbxZjUsMBZBSHUbiZRw", Msg: "Attempting to comply with RFC 3986. Россия", Timestamp: 1414897285},
+		{Txid: "126484de57d01ab12ae19dfc7c4eb74087e6abb8e749badecc75d570ad577fa3", Author: "mxmvvxMNaXvPPnU5vHXPoPEsrHbbnSAehh", Msg: "This should be in the nil board.", Timestamp: 1414900834},
+		{Txid: "5df96dcb607701d19f7ae3a5da2708d834df7dc8ff505d74aa27dc82aeb7b3c1", Board: "recent-test", Author: "n1j3AYj82gnWmLnmFbTcF4GDxHNWNGyxG1", Msg: "This is a test to see if recent confirmations works in the expected way.", Timestamp: 1415854832, Blk: "00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff"},
+	}
+	for _, bltn := range bltns {
+		store.AddBltn(bltn)
+	}
+
+	store.Blacklist("b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be", "The Beatles are slanderous.")
+
+	return store
+}
+
+func newMemTestServer() *httptest.Server {
+	return httptest.NewServer(Handler("/", newTestMemStore()))
+}
+
+// The MemStore must be indistinguishable from the test db.
+func TestMemStoreStatusCodes(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	checkStatusCodes(t, ts)
+}
+
+func TestMemStoreResponses(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	checkResponses(t, ts)
+}
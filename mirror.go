@@ -0,0 +1,210 @@
+package ahimsarest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// A Replica is a local public record that a Mirror copies a remote api into.
+// Bulletins that the remote api censored arrive without a message and with
+// the reason they were censored for.
+type Replica interface {
+	// SyncedHeight returns the height of the highest block held. It reports
+	// false when no block is held.
+	SyncedHeight() (uint64, bool, error)
+	// BlockHash returns the hash of the block held at height. It reports
+	// false when no block is held there.
+	BlockHash(height uint64) (string, bool, error)
+	// PutBlock stores the head of a block along with the bulletins it
+	// confirmed.
+	PutBlock(head *ombjson.JsonBlkHead, bltns []*ombjson.JsonBltn) error
+	// DropBlocks forgets every block at height or above along with the
+	// bulletins they confirmed and returns how many blocks it forgot.
+	DropBlocks(height uint64) (int, error)
+	// PutUnconfirmed stores the unconfirmed bulletins and forgets every other
+	// unconfirmed bulletin held. It returns how many it forgot.
+	PutUnconfirmed(bltns []*ombjson.JsonBltn) (int, error)
+}
+
+var _ Replica = (*MemStore)(nil)
+
+// SyncStats counts what a single sync copied.
+type SyncStats struct {
+	Blocks      int
+	Bltns       int
+	Unconfirmed int
+	// The blocks dropped since the remote disconnected them by a reorg.
+	Dropped int
+	// The unconfirmed bulletins dropped since the remote no longer serves them.
+	Pruned int
+	// The height of the highest block the replica holds after the sync.
+	Height uint64
+}
+
+// A Mirror copies the blocks and bulletins an api serves into a replica so
+// that read replicas can be started without a full node.
+type Mirror struct {
+	cli *Client
+	dst Replica
+}
+
+// NewMirror creates a mirror of the api cli talks to that writes into dst.
+func NewMirror(cli *Client, dst Replica) *Mirror {
+	return &Mirror{cli: cli, dst: dst}
+}
+
+// Sync copies every block above the highest one the replica holds up to the
+// tip of the api, lowest first, and then every unconfirmed bulletin. Blocks are
+// stored one at a time so an interrupted sync resumes where it stopped. The
+// blocks the replica holds that the api no longer has on its main chain are
+// dropped first and the sync resumes from where the chains forked.
+func (m *Mirror) Sync(ctx context.Context) (*SyncStats, error) {
+
+	stats := &SyncStats{}
+	height, ok, err := m.dst.SyncedHeight()
+	if err != nil {
+		return stats, err
+	}
+	stats.Height = height
+
+	tip, err := m.cli.getTip(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	// A remote that holds no blocks yet has no chain to compare with.
+	var from uint64
+	if ok && tip != nil {
+		if from, err = m.forkPoint(ctx, height, stats); err != nil {
+			return stats, err
+		}
+	} else if ok {
+		from = height + 1
+	}
+
+	for tip != nil && from <= tip.Height {
+		to := from + maxPageLimit - 1
+		if to > tip.Height {
+			to = tip.Height
+		}
+
+		heads := []*ombjson.JsonBlkHead{}
+		path := fmt.Sprintf("blocks?from=%d&to=%d", from, to)
+		if err := m.cli.getJson(ctx, path, &heads); err != nil {
+			return stats, err
+		}
+
+		for _, head := range heads {
+			if err := m.syncBlock(ctx, head, stats); err != nil {
+				return stats, err
+			}
+		}
+		from = to + 1
+	}
+
+	unconf, err := m.cli.GetUnconfirmed(ctx)
+	if err != nil {
+		return stats, err
+	}
+	bltns := make([]*ombjson.JsonBltn, len(unconf))
+	for i, bltn := range unconf {
+		bltns[i] = bltn.JsonBltn
+	}
+	pruned, err := m.dst.PutUnconfirmed(bltns)
+	if err != nil {
+		return stats, err
+	}
+	stats.Unconfirmed, stats.Pruned = len(bltns), pruned
+
+	return stats, nil
+}
+
+// forkPoint returns the height to resume a replica that holds blocks up to
+// height from. It walks down until the replica holds the block that the remote
+// has on its main chain at the same height and drops every block above it.
+func (m *Mirror) forkPoint(ctx context.Context, height uint64, stats *SyncStats) (uint64, error) {
+
+	top := height
+	for {
+		var bottom uint64
+		if top >= maxPageLimit {
+			bottom = top - maxPageLimit + 1
+		}
+
+		heads := []*ombjson.JsonBlkHead{}
+		path := fmt.Sprintf("blocks?from=%d&to=%d", bottom, top)
+		if err := m.cli.getJson(ctx, path, &heads); err != nil {
+			return 0, err
+		}
+		remote := make(map[uint64]string)
+		for _, head := range heads {
+			remote[head.Height] = head.Hash
+		}
+
+		for h := top; ; h-- {
+			hash, ok, err := m.dst.BlockHash(h)
+			if err != nil {
+				return 0, err
+			}
+			if ok && hash == remote[h] {
+				return h + 1, m.drop(h+1, height, stats)
+			}
+			if h == bottom {
+				break
+			}
+		}
+
+		// Not even the lowest block is shared, so nothing is.
+		if bottom == 0 {
+			return 0, m.drop(0, height, stats)
+		}
+		top = bottom - 1
+	}
+}
+
+// drop forgets the blocks of the replica from height from up to height.
+func (m *Mirror) drop(from, height uint64, stats *SyncStats) error {
+
+	if from > height {
+		return nil
+	}
+	dropped, err := m.dst.DropBlocks(from)
+	if err != nil {
+		return err
+	}
+
+	stats.Dropped += dropped
+	stats.Height = 0
+	if from > 0 {
+		stats.Height = from - 1
+	}
+	return nil
+}
+
+func (m *Mirror) syncBlock(ctx context.Context, head *ombjson.JsonBlkHead, stats *SyncStats) error {
+
+	hash, err := wire.NewShaHashFromStr(head.Hash)
+	if err != nil {
+		return err
+	}
+	block, err := m.cli.GetJsonBlock(ctx, hash)
+	if err != nil {
+		return err
+	}
+
+	bltns := make([]*ombjson.JsonBltn, len(block.Bltns))
+	for i, bltn := range block.Bltns {
+		bltns[i] = bltn.JsonBltn
+	}
+	if err := m.dst.PutBlock(block.Head, bltns); err != nil {
+		return err
+	}
+
+	stats.Blocks++
+	stats.Bltns += len(bltns)
+	stats.Height = block.Head.Height
+	return nil
+}
@@ -0,0 +1,107 @@
+package ahimsarest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// A flakyReplica fails once it was handed a number of blocks.
+type flakyReplica struct {
+	*MemStore
+	left int
+}
+
+func (r *flakyReplica) PutBlock(head *ombjson.JsonBlkHead, bltns []*ombjson.JsonBltn) error {
+	if r.left == 0 {
+		return errors.New("disk full")
+	}
+	r.left--
+	return r.MemStore.PutBlock(head, bltns)
+}
+
+func TestMirror(t *testing.T) {
+
+	remote := newTestMemStore()
+	opts := DefaultOptions
+	opts.CacheBytes = 0
+	opts.ChainRefresh = 0
+	ts := httptest.NewServer(NewHandler("/api/", remote, opts))
+	defer ts.Close()
+	ctx := context.Background()
+	cli := NewClient(ts.URL)
+
+	// A sync that fails part way keeps what it copied.
+	local := NewMemStore()
+	stats, err := NewMirror(cli, &flakyReplica{MemStore: local, left: 2}).Sync(ctx)
+	if err == nil || stats.Blocks != 2 || stats.Height != 305694 {
+		t.Fatalf("Expected the sync to stop after two blocks: %+v %v", stats, err)
+	}
+
+	stats, err = NewMirror(cli, local).Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blocks != 4 || stats.Height != 307012 || stats.Unconfirmed != 4 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	want, _ := remote.GetDBStatus()
+	got, _ := local.GetDBStatus()
+	if *got != *want {
+		t.Errorf("The replica holds %+v wanted %+v", got, want)
+	}
+
+	censored := "b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be"
+	entries, _ := local.GetJsonBlacklist()
+	if len(entries) != 1 || entries[0].Txid != censored || entries[0].Reason != "The Beatles are slanderous." {
+		t.Errorf("Unexpected blacklist %+v", entries)
+	}
+
+	// Only what is new is copied once the replica caught up.
+	tip := "00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff"
+	hash := "000000000000000000000000000000000000000000000000000000000000ffff"
+	remote.AddBlock(&ombjson.JsonBlkHead{Hash: hash, PrevHash: tip, Timestamp: 1415862700, Height: 307013})
+	remote.AddBltn(&ombjson.JsonBltn{Txid: "e1", Board: "ahimsa-dev", Author: "miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH", Timestamp: 1415862650, Blk: hash})
+
+	stats, err = NewMirror(cli, local).Sync(ctx)
+	if err != nil || stats.Blocks != 1 || stats.Bltns != 1 || stats.Height != 307013 {
+		t.Errorf("Unexpected stats %+v %v", stats, err)
+	}
+	if bltn, err := local.GetJsonBltn("e1"); err != nil || bltn.Blk != hash {
+		t.Errorf("Unexpected bulletin %+v %v", bltn, err)
+	}
+
+	// The remote replaces the block at the tip and drops an unconfirmed
+	// bulletin, which the replica follows from the point the chains forked.
+	unconfirmed, _ := remote.GetUnconfirmed()
+	gone := unconfirmed[0].Txid
+	delete(remote.bltns, gone)
+	remote.RemoveBlock(hash)
+	delete(remote.bltns, "e1")
+	fork := "000000000000000000000000000000000000000000000000000000000000eeee"
+	remote.AddBlock(&ombjson.JsonBlkHead{Hash: fork, PrevHash: tip, Timestamp: 1415862710, Height: 307013})
+	remote.AddBlock(&ombjson.JsonBlkHead{Hash: "000000000000000000000000000000000000000000000000000000000000dddd", PrevHash: fork, Timestamp: 1415862720, Height: 307014})
+
+	stats, err = NewMirror(cli, local).Sync(ctx)
+	if err != nil || stats.Dropped != 1 || stats.Blocks != 2 || stats.Pruned != 1 || stats.Height != 307014 {
+		t.Errorf("Unexpected stats %+v %v", stats, err)
+	}
+	if held, _, _ := local.BlockHash(307013); held != fork {
+		t.Errorf("The replica holds %s at the fork wanted %s", held, fork)
+	}
+	for _, txid := range []string{"e1", gone} {
+		if _, err := local.GetJsonBltn(txid); err != sql.ErrNoRows {
+			t.Errorf("The replica still holds %s: %v", txid, err)
+		}
+	}
+	want, _ = remote.GetDBStatus()
+	got, _ = local.GetDBStatus()
+	if *got != *want {
+		t.Errorf("The replica holds %+v wanted %+v", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/NSkelsey/ahimsarest"
+	"github.com/btcsuite/btcutil"
+)
+
+var (
+	remote   = flag.String("remote", "http://localhost:1055", "The address of the api to mirror")
+	prefix   = flag.String("prefix", "/api/", "The prefix the api is mounted under")
+	dbpath   = flag.String("db", filepath.Join(btcutil.AppDataDir("ombmirror", false), "pubrecord.db"), "The sqlite database to write into")
+	interval = flag.Duration("interval", 0, "How often to sync again. The mirror syncs once when this is zero")
+)
+
+func main() {
+	flag.Parse()
+
+	replica, err := ahimsarest.OpenSQLReplica(*dbpath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer replica.Close()
+
+	mirror := ahimsarest.NewMirror(ahimsarest.NewClientPrefix(*remote, *prefix), replica)
+	for {
+		stats, err := mirror.Sync(context.Background())
+		switch {
+		case err != nil && *interval == 0:
+			replica.Close()
+			log.Fatalf("sync failed at height %d: %s", stats.Height, err)
+		case err != nil:
+			// What was copied is kept, so the next sync resumes from there.
+			log.Printf("sync failed at height %d: %s", stats.Height, err)
+		default:
+			if stats.Dropped > 0 || stats.Pruned > 0 {
+				log.Printf("dropped %d blocks the remote reorged away and %d unconfirmed bulletins it no longer serves",
+					stats.Dropped, stats.Pruned)
+			}
+			log.Printf("synced %d blocks with %d bulletins and %d unconfirmed bulletins up to height %d",
+				stats.Blocks, stats.Bltns, stats.Unconfirmed, stats.Height)
+		}
+
+		if *interval == 0 {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
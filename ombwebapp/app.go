@@ -36,7 +36,9 @@ func main() {
 	}
 
 	prefix := "/api/"
-	api := ahimsarest.Handler(prefix, db)
+	opts := ahimsarest.DefaultOptions
+	opts.Feed = ahimsarest.NewFeed(db)
+	api := ahimsarest.NewHandler(prefix, db, opts)
 
 	mux := http.NewServeMux()
 
@@ -0,0 +1,434 @@
+package ahimsarest
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The version of the OpenAPI specification that the api is described with.
+const openAPIVersion = "3.0.3"
+
+// A Schema is an OpenAPI schema object. Only the parts needed to describe the
+// responses of the api are supported.
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	OneOf       []*Schema          `json:"oneOf,omitempty"`
+}
+
+// A Parameter is a path or query parameter of an operation.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// A MediaType holds the schema of a body of a given content type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// A Response is one of the possible responses of an operation.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// An Operation is a single method served at a path.
+type Operation struct {
+	Summary     string                `json:"summary"`
+	OperationID string                `json:"operationId"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// A RequestBody describes what an operation expects to be sent.
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// A PathItem lists the operations of a path.
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Put    *Operation `json:"put,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+}
+
+// operation returns the operation of the item served for method.
+func (item *PathItem) operation(method string) **Operation {
+	switch method {
+	case "POST":
+		return &item.Post
+	case "PUT":
+		return &item.Put
+	case "DELETE":
+		return &item.Delete
+	}
+	return &item.Get
+}
+
+// A Server is a base url that every path of the api is served under.
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description"`
+}
+
+// A SecurityScheme describes how a client authenticates itself.
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+// Components holds the schemas that are referred to throughout the document.
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+}
+
+// An OpenAPI document describes every route served by the api.
+type OpenAPI struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       map[string]string    `json:"info"`
+	Servers    []*Server            `json:"servers"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// The query parameters that routes may accept.
+var queryParams = map[string]*Parameter{
+	"limit":       {Description: "The most items to return. Asking for a limit pages the response.", Schema: &Schema{Type: "integer"}},
+	"cursor":      {Description: "The next cursor of the previous page.", Schema: &Schema{Type: "string"}},
+	"q":           {Description: "Words or \"quoted phrases\" that must all appear in a bulletin.", Schema: &Schema{Type: "string"}},
+	"board":       {Description: "Only match bulletins posted to this board.", Schema: &Schema{Type: "string"}},
+	"author":      {Description: "Only match bulletins written by this address.", Schema: &Schema{Type: "string"}},
+	"since":       {Description: "Only match bulletins made at or after this time, in unix seconds or RFC 3339.", Schema: &Schema{Type: "string"}},
+	"until":       {Description: "Only match bulletins made at or before this time, in unix seconds or RFC 3339.", Schema: &Schema{Type: "string"}},
+	"blocks":      {Description: "How many of the latest blocks to take bulletins from, at most 1008.", Schema: &Schema{Type: "integer"}},
+	"unconfirmed": {Description: "Whether to merge in the unconfirmed bulletins by time.", Schema: &Schema{Type: "boolean"}},
+	"minconf":     {Description: "Only return bulletins with at least this many confirmations.", Schema: &Schema{Type: "integer"}},
+	"tz":          {Description: "The zone days start in, as a name like Europe/Berlin or an offset like +02:00. UTC by default.", Schema: &Schema{Type: "string"}},
+	"from":        {Description: "The lowest height of the range, by default the last 50 heights.", Schema: &Schema{Type: "integer"}},
+	"to":          {Description: "The highest height of the range, by default the tip.", Schema: &Schema{Type: "integer"}},
+}
+
+// splitTemplate turns a path template of the router into an OpenAPI path and
+// the patterns its variables are constrained by.
+func splitTemplate(tmpl string) (string, []*Parameter) {
+
+	var path []byte
+	var params []*Parameter
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] != '{' {
+			path = append(path, tmpl[i])
+			continue
+		}
+
+		// Patterns may contain braces of their own so they are counted.
+		depth, end := 1, i+1
+		for ; end < len(tmpl) && depth > 0; end++ {
+			switch tmpl[end] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
+		}
+		v := strings.SplitN(tmpl[i+1:end-1], ":", 2)
+		param := &Parameter{Name: v[0], In: "path", Required: true, Schema: &Schema{Type: "string"}}
+		if len(v) == 2 {
+			param.Schema.Pattern = "^(?:" + v[1] + ")$"
+		}
+		params = append(params, param)
+		path = append(path, "{"+v[0]+"}"...)
+		i = end - 1
+	}
+
+	return "/" + string(path), params
+}
+
+// schemaFor describes the json encoding of v. Named structs are placed in the
+// components and referred to, unless they hold an interface whose schema
+// depends on the value held.
+func schemaFor(v reflect.Value, t reflect.Type, comps map[string]*Schema) *Schema {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if v.IsValid() && !v.IsNil() {
+			v = v.Elem()
+		} else {
+			v = reflect.Value{}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: intFormat(t)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		var elem reflect.Value
+		if v.IsValid() && v.Len() > 0 {
+			elem = v.Index(0)
+		}
+		return &Schema{Type: "array", Items: schemaFor(elem, t.Elem(), comps)}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Interface:
+		if v.IsValid() && !v.IsNil() {
+			return schemaFor(v.Elem(), v.Elem().Type(), comps)
+		}
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" || hasInterface(t) {
+			return structSchema(v, t, comps)
+		}
+		if _, ok := comps[t.Name()]; !ok {
+			// Guards against recursive types while the schema is built.
+			comps[t.Name()] = &Schema{}
+			comps[t.Name()] = structSchema(reflect.Value{}, t, comps)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+
+	return &Schema{}
+}
+
+func intFormat(t reflect.Type) string {
+	if t.Bits() == 64 {
+		return "int64"
+	}
+	return "int32"
+}
+
+func hasInterface(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() == reflect.Interface {
+			return true
+		}
+	}
+	return false
+}
+
+// structSchema lists the fields of a struct as encoding/json would write them.
+// Fields that are not omitted when empty are required.
+func structSchema(v reflect.Value, t reflect.Type, comps map[string]*Schema) *Schema {
+
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		var fv reflect.Value
+		if v.IsValid() {
+			fv = v.Field(i)
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+
+		// Embedded structs without a name are flattened into their parent.
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+				if fv.IsValid() && !fv.IsNil() {
+					fv = fv.Elem()
+				} else {
+					fv = reflect.Value{}
+				}
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded := structSchema(fv, ft, comps)
+				for k, p := range embedded.Properties {
+					s.Properties[k] = p
+				}
+				s.Required = append(s.Required, embedded.Required...)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = schemaFor(fv, field.Type, comps)
+		if len(opts) == 1 || opts[1] != "omitempty" {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+
+	return s
+}
+
+// jsonContent describes a json body holding values like v.
+func jsonContent(v interface{}, comps map[string]*Schema) map[string]*MediaType {
+	schema := schemaFor(reflect.ValueOf(v), reflect.TypeOf(v), comps)
+	return map[string]*MediaType{"application/json": {Schema: schema}}
+}
+
+// operationID derives a name for the operation served at path.
+func operationID(path string) string {
+	var words []string
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '.' || r == '{' || r == '}'
+	}) {
+		words = append(words, strings.Title(part))
+	}
+	id := strings.Join(words, "")
+	if id == "" {
+		return "root"
+	}
+	return strings.ToLower(id[:1]) + id[1:]
+}
+
+// newOpenAPI describes routes served under prefix as an OpenAPI 3 document.
+func newOpenAPI(prefix string, routes []route) *OpenAPI {
+
+	comps := make(map[string]*Schema)
+	errResp := jsonContent(ErrorResp{}, comps)
+
+	legacy := strings.TrimSuffix(prefix, "/")
+	if legacy == "" {
+		legacy = "/"
+	}
+	doc := &OpenAPI{
+		OpenAPI: openAPIVersion,
+		Info: map[string]string{
+			"title":       "ahimsarest",
+			"description": "A RESTful API that exposes bulletins stored in a blockchain.",
+			"version":     "1",
+		},
+		Servers: []*Server{
+			{URL: prefix + "v1", Description: "The versioned api. Errors are sent as an ErrorResp."},
+			{URL: legacy, Description: "The legacy api. Errors are sent as plain text."},
+		},
+		Paths: make(map[string]*PathItem),
+		Components: Components{
+			Schemas: comps,
+			SecuritySchemes: map[string]*SecurityScheme{
+				"operator": {Type: "http", Scheme: "bearer"},
+			},
+		},
+	}
+
+	for _, rt := range routes {
+		path, params := splitTemplate(rt.path)
+		method := rt.method
+		if method == "" {
+			method = "GET"
+		}
+		op := &Operation{
+			Summary:     rt.summary,
+			OperationID: strings.ToLower(method) + strings.Title(operationID(path)),
+			Parameters:  params,
+			Responses:   make(map[string]*Response),
+		}
+
+		query := rt.query
+		if rt.paged != nil {
+			query = append([]string{"limit", "cursor"}, query...)
+		}
+		for _, name := range query {
+			p := *queryParams[name]
+			p.Name, p.In = name, "query"
+			op.Parameters = append(op.Parameters, &p)
+		}
+
+		if rt.admin {
+			op.Security = []map[string][]string{{"operator": {}}}
+		}
+		if rt.req != nil {
+			op.RequestBody = &RequestBody{Required: true, Content: jsonContent(rt.req, comps)}
+		}
+
+		success := &Response{Description: http.StatusText(rt.okStatus())}
+		switch {
+		case rt.media != "":
+			success.Content = map[string]*MediaType{rt.media: {Schema: &Schema{Type: "string"}}}
+		case rt.paged != nil:
+			whole := jsonContent(rt.resp, comps)["application/json"].Schema
+			page := jsonContent(rt.paged, comps)["application/json"].Schema
+			success.Content = map[string]*MediaType{"application/json": {Schema: &Schema{OneOf: []*Schema{whole, page}}}}
+		case rt.resp != nil:
+			success.Content = jsonContent(rt.resp, comps)
+		}
+		op.Responses[strconv.Itoa(rt.okStatus())] = success
+
+		errs := rt.errs
+		if rt.paged != nil || len(query) > 0 {
+			errs = append(errs, 400)
+		}
+		for _, status := range append(errs, 500) {
+			op.Responses[strconv.Itoa(status)] = &Response{
+				Description: http.StatusText(status),
+				Content:     errResp,
+			}
+		}
+
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		*item.operation(method) = op
+	}
+
+	return doc
+}
+
+// okStatus is the status code of a successful response.
+func (rt route) okStatus() int {
+	if rt.status != 0 {
+		return rt.status
+	}
+	return 200
+}
+
+// documented reports why rt is missing from the OpenAPI document, if it is.
+func (rt route) documented() error {
+	if rt.summary == "" {
+		return fmt.Errorf("%s has no summary", rt.path)
+	}
+	if rt.resp == nil && rt.media == "" && rt.status != 101 && rt.status != 204 {
+		return fmt.Errorf("%s does not describe its response", rt.path)
+	}
+	if rt.method == "POST" && rt.req == nil {
+		return fmt.Errorf("%s does not describe its request", rt.path)
+	}
+	for _, name := range rt.query {
+		if _, ok := queryParams[name]; !ok {
+			return fmt.Errorf("%s takes an unknown query parameter %s", rt.path, name)
+		}
+	}
+	return nil
+}
+
+// Serves the OpenAPI document that describes the api.
+func OpenAPIHandler(doc *OpenAPI) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+		writeJson(w, request, doc)
+	}
+}
@@ -0,0 +1,135 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestSplitTemplate(t *testing.T) {
+
+	var templateTests = []struct {
+		tmpl    string
+		path    string
+		pattern []string
+	}{
+		{"status", "/status", nil},
+		{"block/{hash:(?:[a-f]|[0-9]){64}}", "/block/{hash}", []string{"^(?:(?:[a-f]|[0-9]){64})$"}},
+		{"recent/feed.{format:atom|rss}", "/recent/feed.{format}", []string{"^(?:atom|rss)$"}},
+		{"author/{addr:[a-z]{30,35}}/feed.{format:atom|rss}", "/author/{addr}/feed.{format}",
+			[]string{"^(?:[a-z]{30,35})$", "^(?:atom|rss)$"},
+		},
+	}
+
+	for _, test := range templateTests {
+		path, params := splitTemplate(test.tmpl)
+		if path != test.path {
+			t.Errorf("%s: got path %s wanted %s", test.tmpl, path, test.path)
+		}
+		if len(params) != len(test.pattern) {
+			t.Errorf("%s: got %d params wanted %d", test.tmpl, len(params), len(test.pattern))
+			continue
+		}
+		for i, p := range params {
+			if p.Schema.Pattern != test.pattern[i] {
+				t.Errorf("%s: got pattern %s wanted %s", test.tmpl, p.Schema.Pattern, test.pattern[i])
+			}
+		}
+	}
+}
+
+// Fails when a route is added to the api without documenting it.
+func TestRoutesDocumented(t *testing.T) {
+
+	for _, rt := range newRoutes(NewMemStore(), DefaultOptions) {
+		if err := rt.documented(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// Asserts that every route is described by the served document.
+func TestOpenAPIDocument(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	for _, endpoint := range []string{"/openapi.json", "/v1/openapi.json"} {
+		res, err := http.Get(ts.URL + endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc OpenAPI
+		err = json.NewDecoder(res.Body).Decode(&doc)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", endpoint, err)
+		}
+
+		if doc.OpenAPI != openAPIVersion {
+			t.Errorf("%s: wrong version %s", endpoint, doc.OpenAPI)
+		}
+
+		routes := append(newRoutes(NewMemStore(), DefaultOptions), route{path: "openapi.json"})
+		for _, rt := range routes {
+			path, _ := splitTemplate(rt.path)
+			item, ok := doc.Paths[path]
+			if !ok || *item.operation(rt.method) == nil {
+				t.Errorf("%s is not documented", path)
+				continue
+			}
+			for _, p := range (*item.operation(rt.method)).Parameters {
+				if p.In != "path" {
+					continue
+				}
+				if _, err := regexp.Compile(p.Schema.Pattern); err != nil || p.Schema.Pattern == "" {
+					t.Errorf("%s: bad pattern for %s: %q", path, p.Name, p.Schema.Pattern)
+				}
+			}
+		}
+		ops := 0
+		for _, item := range doc.Paths {
+			for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+				if *item.operation(method) != nil {
+					ops++
+				}
+			}
+		}
+		if ops != len(routes) {
+			t.Errorf("%s: documents %d operations for %d routes", endpoint, ops, len(routes))
+		}
+	}
+
+	var doc OpenAPI
+	if err := json.Unmarshal([]byte(get(t, ts.URL+"/openapi.json")), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// The patterns of the router must carry over.
+	txid := doc.Paths["/bulletin/{txid}"].Get.Parameters[0].Schema.Pattern
+	if !regexp.MustCompile(txid).MatchString("f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf") {
+		t.Errorf("The txid pattern %s does not match a txid", txid)
+	}
+	day := doc.Paths["/blocks/{day}"].Get.Parameters[0].Schema.Pattern
+	if re := regexp.MustCompile(day); !re.MatchString("2014-11-01") || !re.MatchString("01-11-2014") || re.MatchString("111-990-2014") {
+		t.Errorf("The day pattern %s does not match days", day)
+	}
+
+	bltn, ok := doc.Components.Schemas["Bulletin"]
+	if !ok {
+		t.Fatalf("Bulletin is missing from the components")
+	}
+	for _, field := range []string{"txid", "author", "msg", "timestamp", "blkTimestamp", "bannedReason", "confirmations", "onMainChain"} {
+		if _, ok := bltn.Properties[field]; !ok {
+			t.Errorf("Bulletin is missing %s", field)
+		}
+	}
+
+	resp := doc.Paths["/bulletin/{txid}"].Get.Responses
+	for _, status := range []string{"200", "404", "451", "500"} {
+		if _, ok := resp[status]; !ok {
+			t.Errorf("/bulletin/{txid} does not document a %s", status)
+		}
+	}
+}
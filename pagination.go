@@ -0,0 +1,198 @@
+package ahimsarest
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+var (
+	errBadLimit  = errors.New("limit must be a positive integer")
+	errBadCursor = errors.New("cursor is not valid")
+)
+
+// A Page wraps a slice of items returned by an aggregate handler when the
+// client asks for pagination. Next is empty on the last page.
+type Page struct {
+	Items interface{} `json:"items"`
+	Next  string      `json:"next,omitempty"`
+}
+
+// A BoardPage is a single page of a WholeBoard.
+type BoardPage struct {
+	Summary *ombjson.BoardSummary `json:"summary"`
+	Bltns   []*Bulletin           `json:"bltns"`
+	Next    string                `json:"next,omitempty"`
+}
+
+// An AuthorPage is a single page of an AuthorResp.
+type AuthorPage struct {
+	Author *ombjson.AuthorSummary `json:"author"`
+	Bltns  []*Bulletin            `json:"bltns"`
+	Next   string                 `json:"next,omitempty"`
+}
+
+// pageParams holds the parsed pagination query of a request. If paged is false
+// the client did not ask for pagination and the legacy response is served.
+type pageParams struct {
+	paged bool
+	limit int
+	after string
+}
+
+// parsePageParams reads ?limit= and ?cursor= from the request. A cursor is the
+// base64 encoding of the sort key of the last item the client saw.
+func parsePageParams(request *http.Request) (pageParams, error) {
+
+	q := request.URL.Query()
+	p := pageParams{limit: defaultPageLimit}
+
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return p, errBadLimit
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		p.limit = limit
+		p.paged = true
+	}
+
+	if s := q.Get("cursor"); s != "" {
+		key, err := base64.URLEncoding.DecodeString(s)
+		if err != nil || len(key) == 0 {
+			return p, errBadCursor
+		}
+		p.after = string(key)
+		p.paged = true
+	}
+
+	return p, nil
+}
+
+func encodeCursor(key string) string {
+	return base64.URLEncoding.EncodeToString([]byte(key))
+}
+
+// paginate returns the bounds of the page within keys, which must be sorted,
+// that begins after p.after. The returned cursor is empty if there is nothing
+// left to read after this page.
+func paginate(keys []string, p pageParams) (start, end int, next string) {
+
+	start = sort.Search(len(keys), func(i int) bool { return keys[i] > p.after })
+	end = start + p.limit
+	if end >= len(keys) {
+		return start, len(keys), ""
+	}
+
+	return start, end, encodeCursor(keys[end-1])
+}
+
+// Bulletins are ordered by their reported timestamp and then by txid. The
+// timestamp is zero padded so that the keys sort lexicographically.
+func bltnKey(bltn *ombjson.JsonBltn) string {
+	return fmt.Sprintf("%020d:%s", bltn.Timestamp, bltn.Txid)
+}
+
+type bltnsByKey []*ombjson.JsonBltn
+
+func (s bltnsByKey) Len() int           { return len(s) }
+func (s bltnsByKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bltnsByKey) Less(i, j int) bool { return bltnKey(s[i]) < bltnKey(s[j]) }
+
+// pageBltns sorts a copy of bltns into a stable order and returns the page
+// described by p.
+func pageBltns(bltns []*ombjson.JsonBltn, p pageParams) ([]*ombjson.JsonBltn, string) {
+
+	sorted := make([]*ombjson.JsonBltn, len(bltns))
+	copy(sorted, bltns)
+	sort.Sort(bltnsByKey(sorted))
+
+	keys := make([]string, len(sorted))
+	for i, bltn := range sorted {
+		keys[i] = bltnKey(bltn)
+	}
+
+	start, end, next := paginate(keys, p)
+	return sorted[start:end], next
+}
+
+type boardsByName []*ombjson.BoardSummary
+
+func (s boardsByName) Len() int           { return len(s) }
+func (s boardsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s boardsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+func pageBoards(boards []*ombjson.BoardSummary, p pageParams) ([]*ombjson.BoardSummary, string) {
+
+	sorted := make([]*ombjson.BoardSummary, len(boards))
+	copy(sorted, boards)
+	sort.Sort(boardsByName(sorted))
+
+	keys := make([]string, len(sorted))
+	for i, board := range sorted {
+		keys[i] = board.Name
+	}
+
+	start, end, next := paginate(keys, p)
+	return sorted[start:end], next
+}
+
+type authorsByAddr []*ombjson.AuthorSummary
+
+func (s authorsByAddr) Len() int           { return len(s) }
+func (s authorsByAddr) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s authorsByAddr) Less(i, j int) bool { return s[i].Address < s[j].Address }
+
+func pageAuthors(authors []*ombjson.AuthorSummary, p pageParams) ([]*ombjson.AuthorSummary, string) {
+
+	sorted := make([]*ombjson.AuthorSummary, len(authors))
+	copy(sorted, authors)
+	sort.Sort(authorsByAddr(sorted))
+
+	keys := make([]string, len(sorted))
+	for i, author := range sorted {
+		keys[i] = author.Address
+	}
+
+	start, end, next := paginate(keys, p)
+	return sorted[start:end], next
+}
+
+// Blocks are ordered by height and then by hash so that two blocks at the same
+// height still have a stable position.
+func blkKey(blk *ombjson.JsonBlkHead) string {
+	return fmt.Sprintf("%020d:%s", blk.Height, blk.Hash)
+}
+
+type blksByKey []*ombjson.JsonBlkHead
+
+func (s blksByKey) Len() int           { return len(s) }
+func (s blksByKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s blksByKey) Less(i, j int) bool { return blkKey(s[i]) < blkKey(s[j]) }
+
+func pageBlks(blks []*ombjson.JsonBlkHead, p pageParams) ([]*ombjson.JsonBlkHead, string) {
+
+	sorted := make([]*ombjson.JsonBlkHead, len(blks))
+	copy(sorted, blks)
+	sort.Sort(blksByKey(sorted))
+
+	keys := make([]string, len(sorted))
+	for i, blk := range sorted {
+		keys[i] = blkKey(blk)
+	}
+
+	start, end, next := paginate(keys, p)
+	return sorted[start:end], next
+}
@@ -0,0 +1,72 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRecentWindow(t *testing.T) {
+
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	recent := "5df96dcb607701d19f7ae3a5da2708d834df7dc8ff505d74aa27dc82aeb7b3c1"
+	var windowTests = []struct {
+		query string
+		txids []string
+	}{
+		{"", []string{recent}},
+		{"?blocks=1", []string{recent}},
+		{"?blocks=5000", []string{recent}},
+		{"?since=1415862580", []string{recent}},
+		{"?since=1415862581", []string{}},
+		{"?since=2014-11-13T07:09:40Z", []string{recent}},
+		{"?since=1414897285&unconfirmed=true", []string{
+			"5ed76ba84d4116045df14ecf7a7eca86300a649ef3cbefdd2eeea3f84e1432dc",
+			"126484de57d01ab12ae19dfc7c4eb74087e6abb8e749badecc75d570ad577fa3",
+			recent,
+		}},
+		{"?since=1415862581&unconfirmed=1", []string{}},
+	}
+	for _, test := range windowTests {
+		var bltns []*Bulletin
+		if err := json.Unmarshal([]byte(get(t, ts.URL+"/recent"+test.query)), &bltns); err != nil {
+			t.Fatal(err)
+		}
+		if len(bltns) != len(test.txids) {
+			t.Errorf("%s: got %d bulletins wanted %d", test.query, len(bltns), len(test.txids))
+			continue
+		}
+		for i, bltn := range bltns {
+			if bltn.Txid != test.txids[i] {
+				t.Errorf("%s: got %s at %d wanted %s", test.query, bltn.Txid, i, test.txids[i])
+			}
+		}
+	}
+
+	var bltns []*Bulletin
+	if err := json.Unmarshal([]byte(get(t, ts.URL+"/recent?unconfirmed=true")), &bltns); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(bltns); i++ {
+		if bltns[i-1].Timestamp > bltns[i].Timestamp {
+			t.Errorf("The bulletins are not ordered by time: %d after %d", bltns[i].Timestamp, bltns[i-1].Timestamp)
+		}
+	}
+
+	var statusTests = []struct {
+		query      string
+		statuscode int
+	}{
+		{"?blocks=0", 400},
+		{"?blocks=six", 400},
+		{"?since=yesterday", 400},
+		{"?unconfirmed=maybe", 400},
+		{"?blocks=3&minconf=1", 200},
+	}
+	for _, test := range statusTests {
+		if res := doGet(t, ts.URL+"/v1/recent"+test.query, nil); res.StatusCode != test.statuscode {
+			t.Errorf("%s: got %d wanted %d", test.query, res.StatusCode, test.statuscode)
+		}
+	}
+}
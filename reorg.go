@@ -0,0 +1,290 @@
+package ahimsarest
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+	"github.com/soapboxsys/ombudslib/pubrecdb"
+)
+
+// The header of block responses that says whether the block is on the main
+// chain or was disconnected from it by a reorg.
+const chainStatusHeader = "X-Chain-Status"
+
+var (
+	// The bulletins confirmed by blocks this close to the tip are remembered,
+	// since a store that drops a disconnected block forgets what it held.
+	reorgWindow = uint64(6)
+	// The number of past reorgs kept around.
+	reorgHistory = 1024
+)
+
+// A Reorg records the blocks that were disconnected from the main chain at
+// once and the bulletins they confirmed that reverted to unconfirmed.
+type Reorg struct {
+	Time         int64                  `json:"time"`
+	Disconnected []*ombjson.JsonBlkHead `json:"disconnected"`
+	Reverted     []*Bulletin            `json:"reverted"`
+}
+
+// reorged returns the reorgs that replacing the main chain with byHeight
+// amounts to. The blocks that the store already holds off the main chain when
+// the index is first built were disconnected before anyone was watching, so
+// they are recorded as well, each run of them as a reorg of its own. The
+// caller must hold the lock.
+func (ci *ChainIndex) reorged(heads map[string]*ombjson.JsonBlkHead, byHeight []*ombjson.JsonBlkHead, heights map[string]uint64) ([]*Reorg, error) {
+
+	var reorgs []*Reorg
+	if !ci.built {
+		var forks []*ombjson.JsonBlkHead
+		for hash, head := range heads {
+			if _, ok := heights[hash]; !ok {
+				forks = append(forks, head)
+			}
+		}
+		sort.Sort(blksByKey(forks))
+		for _, run := range branches(forks) {
+			reorgs = append(reorgs, &Reorg{Time: replacedAt(byHeight, run[0]), Disconnected: run})
+		}
+	} else {
+		var disconnected []*ombjson.JsonBlkHead
+		for _, head := range ci.byHeight {
+			if _, ok := heights[head.Hash]; !ok {
+				disconnected = append(disconnected, head)
+			}
+		}
+		if len(disconnected) > 0 {
+			reorgs = append(reorgs, &Reorg{Time: time.Now().Unix(), Disconnected: disconnected})
+		}
+	}
+
+	v := &chainView{heights: heights}
+	if n := len(byHeight); n > 0 {
+		v.tip = byHeight[n-1].Height
+	}
+	for _, reorg := range reorgs {
+		var reverted []*ombjson.JsonBltn
+		for _, head := range reorg.Disconnected {
+			txids, err := ci.txids(head.Hash)
+			if err != nil {
+				return nil, err
+			}
+			for _, txid := range txids {
+				bltn, err := ci.db.GetJsonBltn(txid)
+				if err == sql.ErrNoRows || err == pubrecdb.ErrBltnCensored {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				// Bulletins that the new main chain confirmed again are fine.
+				if !v.bulletin(bltn).OnMainChain {
+					reverted = append(reverted, bltn)
+				}
+			}
+		}
+		sort.Sort(bltnsByKey(reverted))
+		reorg.Reverted = v.bulletins(reverted)
+	}
+
+	return reorgs, nil
+}
+
+// branches splits the heads of blocks off the main chain, sorted by height, into
+// runs of blocks that build on each other.
+func branches(forks []*ombjson.JsonBlkHead) [][]*ombjson.JsonBlkHead {
+
+	var runs [][]*ombjson.JsonBlkHead
+	runOf := make(map[string]int)
+	for _, head := range forks {
+		i, ok := runOf[head.PrevHash]
+		if !ok {
+			i = len(runs)
+			runs = append(runs, nil)
+		}
+		runs[i] = append(runs[i], head)
+		runOf[head.Hash] = i
+	}
+
+	return runs
+}
+
+// replacedAt returns the time of the main chain block that took the height of
+// head, or the time of head itself if there is none.
+func replacedAt(byHeight []*ombjson.JsonBlkHead, head *ombjson.JsonBlkHead) int64 {
+	i := sort.Search(len(byHeight), func(i int) bool { return byHeight[i].Height >= head.Height })
+	if i < len(byHeight) && byHeight[i].Height == head.Height {
+		return byHeight[i].Timestamp
+	}
+	return head.Timestamp
+}
+
+// txids returns the bulletins that the block with hash confirmed, either as
+// remembered or as the store holds them. A block that is gone from the store
+// and was never near the tip confirmed nothing anyone knows of.
+func (ci *ChainIndex) txids(hash string) ([]string, error) {
+
+	if txids, ok := ci.confirmed[hash]; ok {
+		return txids, nil
+	}
+
+	blk, err := ci.db.GetJsonBlock(hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	txids := make([]string, len(blk.Bltns))
+	for i, bltn := range blk.Bltns {
+		txids[i] = bltn.Txid
+	}
+	return txids, nil
+}
+
+// nearTip returns the txids confirmed by the blocks of byHeight within
+// reorgWindow of its tip.
+func (ci *ChainIndex) nearTip(byHeight []*ombjson.JsonBlkHead) (map[string][]string, error) {
+
+	confirmed := make(map[string][]string)
+	n := len(byHeight)
+	for i := n - 1; i >= 0 && byHeight[i].Height+reorgWindow > byHeight[n-1].Height; i-- {
+		txids, err := ci.txids(byHeight[i].Hash)
+		if err != nil {
+			return nil, err
+		}
+		confirmed[byHeight[i].Hash] = txids
+	}
+
+	return confirmed, nil
+}
+
+// record adds reorgs to the history and marks the blocks they disconnected as
+// stale. Blocks that made it back onto the main chain are no longer stale. The
+// caller must hold the lock.
+func (ci *ChainIndex) record(reorgs []*Reorg) {
+
+	if ci.stale == nil {
+		ci.stale = make(map[string]*ombjson.JsonBlkHead)
+	}
+	for hash := range ci.stale {
+		if _, ok := ci.heights[hash]; ok {
+			delete(ci.stale, hash)
+		}
+	}
+
+	for _, reorg := range reorgs {
+		for _, head := range reorg.Disconnected {
+			ci.stale[head.Hash] = head
+		}
+	}
+	ci.reorgs = append(ci.reorgs, reorgs...)
+	ci.reorgSeq += uint64(len(reorgs))
+	if len(ci.reorgs) > reorgHistory {
+		ci.reorgs = ci.reorgs[len(ci.reorgs)-reorgHistory:]
+	}
+}
+
+// Stale returns the head of the block with hash if it is off the main chain,
+// either because a reorg disconnected it or because it lost the race for its
+// height. It returns nil for blocks on the main chain and unknown blocks.
+func (ci *ChainIndex) Stale(hash string) (*ombjson.JsonBlkHead, error) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if err := ci.refresh(); err != nil {
+		return nil, err
+	}
+	if head, ok := ci.stale[hash]; ok {
+		return head, nil
+	}
+	if _, ok := ci.heights[hash]; ok {
+		return nil, nil
+	}
+	return ci.byHash[hash], nil
+}
+
+// Reorgs returns every reorg the index has recorded, oldest first. Only the
+// last reorgHistory reorgs are kept.
+func (ci *ChainIndex) Reorgs() ([]*Reorg, error) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if err := ci.refresh(); err != nil {
+		return nil, err
+	}
+	return append([]*Reorg{}, ci.reorgs...), nil
+}
+
+// reorgsSince returns the reorgs recorded after the first seq along with the
+// number recorded so far.
+func (ci *ChainIndex) reorgsSince(seq uint64) ([]*Reorg, uint64) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	missed := ci.reorgSeq - seq
+	if missed > uint64(len(ci.reorgs)) {
+		missed = uint64(len(ci.reorgs))
+	}
+	return append([]*Reorg{}, ci.reorgs[uint64(len(ci.reorgs))-missed:]...), ci.reorgSeq
+}
+
+// setChainStatus sets the chainStatusHeader of the response for a block that
+// the store holds. Blocks buried deeper in the main chain than any reorg is
+// expected to reach are cached for long.
+func setChainStatus(w http.ResponseWriter, ci *ChainIndex, hash string) error {
+
+	stale, err := ci.Stale(hash)
+	if err != nil {
+		return err
+	}
+	if stale != nil {
+		w.Header().Set(chainStatusHeader, "stale")
+		return nil
+	}
+
+	v, err := ci.view()
+	if err != nil {
+		return err
+	}
+	if height, ok := v.heights[hash]; ok && v.tip-height >= reorgWindow {
+		w.Header().Set("Cache-Control", cacheLong)
+	}
+	w.Header().Set(chainStatusHeader, "main")
+	return nil
+}
+
+// missingBlock answers a request for a block that the store does not hold.
+// Blocks that a reorg disconnected are gone rather than unknown.
+func missingBlock(w http.ResponseWriter, request *http.Request, ci *ChainIndex, hash string) {
+	stale, err := ci.Stale(hash)
+	if err != nil {
+		serverError(w, request, err)
+		return
+	}
+	if stale != nil {
+		w.Header().Set(chainStatusHeader, "stale")
+		writeError(w, request, 410, "Block was disconnected from the main chain")
+		return
+	}
+	writeError(w, request, 404, "Block does not exist")
+}
+
+// Serves every reorg seen by the index, oldest first. Blocks the store already
+// held off the main chain when the api started are included.
+func ReorgsHandler(ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		reorgs, err := ci.Reorgs()
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeJson(w, request, reorgs)
+	}
+}
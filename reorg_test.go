@@ -0,0 +1,117 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+func TestReorg(t *testing.T) {
+
+	now := time.Now().Unix()
+	store := NewMemStore()
+	store.AddBlock(&ombjson.JsonBlkHead{Hash: "aa", PrevHash: "00", Timestamp: now - 1200, Height: 10})
+	store.AddBlock(&ombjson.JsonBlkHead{Hash: "bb", PrevHash: "aa", Timestamp: now - 600, Height: 11})
+	store.AddBltn(&ombjson.JsonBltn{Txid: "t1", Board: "ahimsa-dev", Timestamp: now - 900, Blk: "aa"})
+	store.AddBltn(&ombjson.JsonBltn{Txid: "t2", Board: "ahimsa-dev", Timestamp: now - 300, Blk: "bb"})
+	// A block that lost its height before anything was watching.
+	store.AddBlock(&ombjson.JsonBlkHead{Hash: "a0", PrevHash: "00", Timestamp: now - 1300, Height: 10})
+	store.AddBltn(&ombjson.JsonBltn{Txid: "t0", Board: "ahimsa-dev", Timestamp: now - 1400, Blk: "a0"})
+
+	ci := NewChainIndex(store)
+	ci.every = 0
+	if _, err := ci.Tip(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newIdleFeed()
+	f.db = store
+	f.poll(false)
+	sub := f.Subscribe(0)
+	defer f.Unsubscribe(sub)
+
+	store.RemoveBlock("bb")
+	store.AddBlock(&ombjson.JsonBlkHead{Hash: "cc", PrevHash: "aa", Timestamp: now - 60, Height: 11})
+	f.poll(true)
+
+	reorgs, err := ci.Reorgs()
+	if err != nil || len(reorgs) != 2 {
+		t.Fatalf("Expected the old fork and the reorg: %+v %v", reorgs, err)
+	}
+	if r := reorgs[0]; len(r.Disconnected) != 1 || r.Disconnected[0].Hash != "a0" || len(r.Reverted) != 1 || r.Reverted[0].Txid != "t0" {
+		t.Errorf("Unexpected fork %+v", r)
+	}
+	if r := reorgs[1]; len(r.Disconnected) != 1 || r.Disconnected[0].Hash != "bb" || len(r.Reverted) != 1 || r.Reverted[0].Txid != "t2" {
+		t.Errorf("Unexpected reorg %+v", r)
+	}
+	if r := reorgs[1]; r.Reverted[0].OnMainChain || r.Reverted[0].Confirmations != 0 {
+		t.Errorf("A reverted bulletin is not on the main chain: %+v", r.Reverted[0])
+	}
+
+	// The reorg comes before the block that replaced the stale one, and the
+	// feed does not publish the fork it found when it started.
+	var evs []*Event
+	for len(sub.C) > 0 {
+		evs = append(evs, <-sub.C)
+	}
+	if len(evs) != 2 || evs[0].Kind != EventReorg || evs[1].Kind != EventBlock {
+		t.Fatalf("Unexpected events %+v", evs)
+	}
+	if r := evs[0].Reorg; len(r.Disconnected) != 1 || r.Disconnected[0].Hash != "bb" || len(r.Reverted) != 1 {
+		t.Errorf("Unexpected reorg %+v", r)
+	}
+
+	// Bulletins are published along with their depth.
+	store.AddBltn(&ombjson.JsonBltn{Txid: "t3", Board: "ahimsa-dev", Timestamp: now - 30, Blk: "cc"})
+	f.poll(true)
+	if len(sub.C) != 2 {
+		t.Errorf("Expected the bulletin and its confirmation, got %d events", len(sub.C))
+	}
+	for len(sub.C) > 0 {
+		if ev := <-sub.C; ev.Bltn == nil || ev.Bltn.Confirmations != 1 || !ev.Bltn.OnMainChain {
+			t.Errorf("Published %s without its depth: %+v", ev.Kind, ev.Bltn)
+		}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/block/{hash}", BlockHandler(store, ci))
+	r.HandleFunc("/reorgs", ReorgsHandler(ci))
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	var blockTests = []struct {
+		hash       string
+		statuscode int
+		chain      string
+	}{
+		{"aa", 200, "main"},
+		{"cc", 200, "main"},
+		{"a0", 200, "stale"},
+		{"bb", 410, "stale"},
+		{"dd", 404, ""},
+	}
+	for _, test := range blockTests {
+		res := doGet(t, ts.URL+"/block/"+test.hash, nil)
+		if res.StatusCode != test.statuscode || res.Header.Get(chainStatusHeader) != test.chain {
+			t.Errorf("%s: got %d %q", test.hash, res.StatusCode, res.Header.Get(chainStatusHeader))
+		}
+	}
+
+	var served []*Reorg
+	if err := json.Unmarshal([]byte(get(t, ts.URL+"/reorgs")), &served); err != nil || len(served) != 2 {
+		t.Errorf("Unexpected reorgs %v %v", served, err)
+	}
+
+	// An index built after the fact still knows the fork the store kept.
+	fresh := NewChainIndex(store)
+	if stale, err := fresh.Stale("a0"); err != nil || stale == nil {
+		t.Errorf("The kept fork is not stale: %v", err)
+	}
+	if reorgs, err := fresh.Reorgs(); err != nil || len(reorgs) != 1 {
+		t.Errorf("Unexpected reorgs %+v %v", reorgs, err)
+	}
+}
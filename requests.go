@@ -1,81 +1,316 @@
 package ahimsarest
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/soapboxsys/ombudslib/ombjson"
 )
 
+// ClientOptions tune the client returned by NewClientWith.
+type ClientOptions struct {
+	// How long a single attempt at a request may take. There is no limit when
+	// this is zero.
+	Timeout time.Duration
+	// How many more times a request is made after failing on the network or
+	// with a 5xx status.
+	Retries int
+	// How long to wait before the first retry. The wait doubles each time.
+	Backoff time.Duration
+	// How often Subscribe polls an api that does not stream events.
+	PollInterval time.Duration
+}
+
+// DefaultClientOptions are the options NewClient and NewClientPrefix use.
+var DefaultClientOptions = ClientOptions{
+	Timeout:      30 * time.Second,
+	Retries:      3,
+	Backoff:      250 * time.Millisecond,
+	PollInterval: 5 * time.Second,
+}
+
+// ErrNotFound is returned when the api holds nothing at a path. Blocks that
+// are no longer part of the main chain are not found either.
+type ErrNotFound struct {
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *ErrNotFound) Error() string {
+	return fmt.Sprintf("Not found: %s", e.URL)
+}
+
+// ErrCensored is returned when the operator of the api censored a bulletin.
+type ErrCensored struct {
+	URL    string
+	Reason string
+}
+
+func (e *ErrCensored) Error() string {
+	return fmt.Sprintf("Censored: %s", e.Reason)
+}
+
+// ErrServer is returned when the api answers with any other unexpected status.
+type ErrServer struct {
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *ErrServer) Error() string {
+	return fmt.Sprintf("Server responded with: %d %s", e.StatusCode, e.Message)
+}
+
 type Client struct {
 	httpCli *http.Client
 	base    string
+	prefix  string
+	opts    ClientOptions
 }
 
 // NewClient creates an http client to communicate with a ombwebapp api
 func NewClient(base string) *Client {
+	return NewClientPrefix(base, "/api/")
+}
+
+// NewClientPrefix creates a client for an api mounted under prefix, which
+// must start and end with slashes just like the prefix given to Handler.
+func NewClientPrefix(base, prefix string) *Client {
+	return NewClientWith(base, prefix, DefaultClientOptions)
+}
+
+// NewClientWith creates a client for an api mounted under prefix that is tuned
+// by opts. Waits that are not set are taken from DefaultClientOptions so that
+// retries and subscriptions never hammer the api.
+func NewClientWith(base, prefix string, opts ClientOptions) *Client {
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultClientOptions.Backoff
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultClientOptions.PollInterval
+	}
+
 	return &Client{
-		httpCli: &http.Client{},
-		base:    base,
+		httpCli: &http.Client{Timeout: opts.Timeout},
+		base:    strings.TrimRight(base, "/"),
+		prefix:  prefix,
+		opts:    opts,
 	}
 }
 
-func (c Client) GetJsonBlockHead(hash *wire.ShaHash) (ombjson.JsonBlkHead, error) {
+// fetch gets url and reads the whole response. Requests that fail on the
+// network or with a status that may go away are retried with a growing wait.
+func (c Client) fetch(ctx context.Context, url string) (*http.Response, []byte, error) {
 
-	blkhead := ombjson.JsonBlkHead{}
-	url := fmt.Sprintf("%s/api/blockhead/%s", c.base, hash.String())
-	resp, err := c.httpCli.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return blkhead, err
+		return nil, nil, err
 	}
+	req = req.WithContext(ctx)
 
-	defer resp.Body.Close()
-	blob, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return blkhead, err
-	}
+	wait := c.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, blob, err := c.attempt(req)
+		retry := err != nil || (resp.StatusCode >= 500 && resp.StatusCode != 501)
+		if !retry || attempt >= c.opts.Retries || ctx.Err() != nil {
+			return resp, blob, err
+		}
 
-	if resp.StatusCode == 200 {
-		if err = json.Unmarshal(blob, &blkhead); err != nil {
-			return blkhead, err
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(wait):
 		}
-	} else {
-		return blkhead, fmt.Errorf("Unexpected status code")
+		wait *= 2
 	}
-
-	return blkhead, nil
 }
 
-func (c Client) GetJsonAuthor(addr btcutil.Address) (ombjson.AuthorResp, error) {
+func (c Client) attempt(req *http.Request) (*http.Response, []byte, error) {
 
-	authorResp := ombjson.AuthorResp{}
-	url := fmt.Sprintf("%s/api/author/%s", c.base, addr.String())
-	resp, err := c.httpCli.Get(url)
+	resp, err := c.httpCli.Do(req)
 	if err != nil {
-		return authorResp, err
+		return nil, nil, err
 	}
 
 	defer resp.Body.Close()
 	blob, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return authorResp, err
+		return nil, nil, err
 	}
 
-	// Unmarshal the json into an AuthorResp struct
-	if resp.StatusCode == 200 {
-		if err = json.Unmarshal(blob, &authorResp); err != nil {
-			return authorResp, err
-		}
-	} else {
-		if resp.StatusCode != 204 {
-			err = fmt.Errorf("Server responded with: %s", resp.Status)
-			return authorResp, err
+	return resp, blob, nil
+}
+
+// getJson fetches path relative to the prefix of the api and unmarshals the
+// response into v.
+func (c Client) getJson(ctx context.Context, path string, v interface{}) error {
+	return c.getUrl(ctx, c.base+c.prefix+path, v)
+}
+
+func (c Client) getUrl(ctx context.Context, url string, v interface{}) error {
+
+	resp, blob, err := c.fetch(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return c.statusError(ctx, url, resp, blob)
+	}
+
+	return json.Unmarshal(blob, v)
+}
+
+// statusError turns a failed response into one of the error types of the
+// client. The reason a bulletin was censored for is looked up in the
+// transparency report that the response links to.
+func (c Client) statusError(ctx context.Context, url string, resp *http.Response, blob []byte) error {
+
+	msg := errorMessage(blob)
+	switch resp.StatusCode {
+	// The api answers 204 for authors it has never seen.
+	case 204, 404, 410:
+		return &ErrNotFound{URL: url, StatusCode: resp.StatusCode, Message: msg}
+	case 451:
+		e := &ErrCensored{URL: url, Reason: msg}
+		if link := blockedBy(resp.Header.Get("Link")); link != "" {
+			record := CensorRecord{}
+			if err := c.getUrl(ctx, c.base+link, &record); err == nil {
+				e.Reason = record.Reason
+			}
 		}
+		return e
+	default:
+		return &ErrServer{URL: url, StatusCode: resp.StatusCode, Message: msg}
+	}
+}
+
+// errorMessage returns the message of the body of a failed response, which is
+// plain text on the legacy routes and an ErrorResp in the versioned api.
+func errorMessage(blob []byte) string {
+	errResp := ErrorResp{}
+	if err := json.Unmarshal(blob, &errResp); err == nil && errResp.Error.Message != "" {
+		return errResp.Error.Message
 	}
+	return strings.TrimSpace(string(blob))
+}
+
+// blockedBy returns the path of the link with the blocked-by relation.
+func blockedBy(link string) string {
+	if !strings.HasPrefix(link, "<") || !strings.HasSuffix(link, `rel="blocked-by"`) {
+		return ""
+	}
+	if end := strings.Index(link, ">"); end > 0 {
+		return link[1:end]
+	}
+	return ""
+}
+
+// escape percent encodes s so that it forms a single segment of a path.
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func (c Client) GetJsonBltn(ctx context.Context, txid *wire.ShaHash) (Bulletin, error) {
+	bltn := Bulletin{}
+	err := c.getJson(ctx, "bulletin/"+txid.String(), &bltn)
+	return bltn, err
+}
+
+func (c Client) GetJsonBlock(ctx context.Context, hash *wire.ShaHash) (BlockResp, error) {
+	block := BlockResp{}
+	err := c.getJson(ctx, "block/"+hash.String(), &block)
+	return block, err
+}
+
+func (c Client) GetJsonBlockHead(ctx context.Context, hash *wire.ShaHash) (ombjson.JsonBlkHead, error) {
+	blkhead := ombjson.JsonBlkHead{}
+	err := c.getJson(ctx, "blockhead/"+hash.String(), &blkhead)
+	return blkhead, err
+}
+
+func (c Client) GetJsonAuthor(ctx context.Context, addr btcutil.Address) (AuthorResp, error) {
+	authorResp := AuthorResp{}
+	err := c.getJson(ctx, "author/"+addr.String(), &authorResp)
+	return authorResp, err
+}
+
+// GetWholeBoard returns a board along with every bulletin posted to it.
+func (c Client) GetWholeBoard(ctx context.Context, board string) (BoardResp, error) {
+	boardResp := BoardResp{}
+	err := c.getJson(ctx, "board/"+escape(board), &boardResp)
+	return boardResp, err
+}
+
+// GetNilBoard returns the bulletins posted without a board.
+func (c Client) GetNilBoard(ctx context.Context) (BoardResp, error) {
+	boardResp := BoardResp{}
+	err := c.getJson(ctx, "nilboard", &boardResp)
+	return boardResp, err
+}
+
+func (c Client) GetAllBoards(ctx context.Context) ([]*ombjson.BoardSummary, error) {
+	boards := []*ombjson.BoardSummary{}
+	err := c.getJson(ctx, "boards", &boards)
+	return boards, err
+}
+
+func (c Client) GetJsonBlacklist(ctx context.Context) ([]*ombjson.BlacklistEntry, error) {
+	entries := []*ombjson.BlacklistEntry{}
+	err := c.getJson(ctx, "blacklist", &entries)
+	return entries, err
+}
+
+// GetRecentConf returns the bulletins confirmed within the last n blocks. The
+// server picks the window when n is not positive.
+func (c Client) GetRecentConf(ctx context.Context, n int) ([]*Bulletin, error) {
+	path := "recent"
+	if n > 0 {
+		path += "?blocks=" + strconv.Itoa(n)
+	}
+
+	bltns := []*Bulletin{}
+	err := c.getJson(ctx, path, &bltns)
+	return bltns, err
+}
+
+func (c Client) GetUnconfirmed(ctx context.Context) ([]*Bulletin, error) {
+	bltns := []*Bulletin{}
+	err := c.getJson(ctx, "unconfirmed", &bltns)
+	return bltns, err
+}
+
+func (c Client) GetAllAuthors(ctx context.Context) ([]*ombjson.AuthorSummary, error) {
+	authors := []*ombjson.AuthorSummary{}
+	err := c.getJson(ctx, "authors", &authors)
+	return authors, err
+}
+
+// GetBlocksByDay returns the heads of the blocks found on the day that day
+// falls on in its own location.
+func (c Client) GetBlocksByDay(ctx context.Context, day time.Time) ([]*ombjson.JsonBlkHead, error) {
+	path := "blocks/" + day.Format("2006-01-02")
+	if _, offset := day.Zone(); offset != 0 {
+		path += "?tz=" + url.QueryEscape(day.Format("-07:00"))
+	}
+
+	blks := []*ombjson.JsonBlkHead{}
+	err := c.getJson(ctx, path, &blks)
+	return blks, err
+}
 
-	return authorResp, nil
+func (c Client) GetDBStatus(ctx context.Context) (StatusResp, error) {
+	status := StatusResp{}
+	err := c.getJson(ctx, "status", &status)
+	return status, err
 }
@@ -0,0 +1,169 @@
+package ahimsarest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestClient(t *testing.T) {
+
+	ts := httptest.NewServer(Handler("/api/", newTestMemStore()))
+	defer ts.Close()
+	c := NewClient(ts.URL)
+	ctx := context.Background()
+
+	txid, _ := wire.NewShaHashFromStr("933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9")
+	bltn, err := c.GetJsonBltn(ctx, txid)
+	if err != nil || bltn.Msg != "the mind is our medium" || bltn.Confirmations != 307012-304529+1 {
+		t.Errorf("Unexpected bulletin %+v %v", bltn, err)
+	}
+
+	hash, _ := wire.NewShaHashFromStr("00000000000016b6ff59b9fffcade68943bb02270b46d2a001054d95c56ca8ad")
+	if block, err := c.GetJsonBlock(ctx, hash); err != nil || block.Head.Height != 304529 || len(block.Bltns) != 1 {
+		t.Errorf("Unexpected block %+v %v", block, err)
+	}
+	if head, err := c.GetJsonBlockHead(ctx, hash); err != nil || head.Height != 304529 {
+		t.Errorf("Unexpected head %+v %v", head, err)
+	}
+
+	addr, _ := btcutil.DecodeAddress("miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH", &chaincfg.TestNet3Params)
+	if author, err := c.GetJsonAuthor(ctx, addr); err != nil || len(author.Bltns) != 2 {
+		t.Errorf("Unexpected author %+v %v", author, err)
+	}
+
+	board := "#!~*Enc ded-boÄ&\\/Ӂ"
+	if resp, err := c.GetWholeBoard(ctx, board); err != nil || resp.Summary.Name != board || len(resp.Bltns) != 1 {
+		t.Errorf("Unexpected board %+v %v", resp, err)
+	}
+	if _, err := c.GetWholeBoard(ctx, "this-One-Isnt-Real"); err == nil {
+		t.Errorf("A missing board should be an error")
+	}
+	if resp, err := c.GetNilBoard(ctx); err != nil || len(resp.Bltns) != 1 {
+		t.Errorf("Unexpected nilboard %+v %v", resp, err)
+	}
+
+	var lenTests = []struct {
+		name string
+		get  func() (int, error)
+		n    int
+	}{
+		{"boards", func() (int, error) { v, err := c.GetAllBoards(ctx); return len(v), err }, 3},
+		{"blacklist", func() (int, error) { v, err := c.GetJsonBlacklist(ctx); return len(v), err }, 1},
+		{"recent", func() (int, error) { v, err := c.GetRecentConf(ctx, 0); return len(v), err }, 1},
+		{"recent 5000", func() (int, error) { v, err := c.GetRecentConf(ctx, 5000); return len(v), err }, 1},
+		{"unconfirmed", func() (int, error) { v, err := c.GetUnconfirmed(ctx); return len(v), err }, 4},
+		{"authors", func() (int, error) { v, err := c.GetAllAuthors(ctx); return len(v), err }, 6},
+		{"day", func() (int, error) {
+			v, err := c.GetBlocksByDay(ctx, time.Date(2014, 11, 1, 0, 0, 0, 0, time.UTC))
+			return len(v), err
+		}, 4},
+		{"day in a zone", func() (int, error) {
+			v, err := c.GetBlocksByDay(ctx, time.Date(2014, 10, 23, 0, 0, 0, 0, time.FixedZone("", 5*3600)))
+			return len(v), err
+		}, 1},
+	}
+	for _, test := range lenTests {
+		if n, err := test.get(); err != nil || n != test.n {
+			t.Errorf("%s: got %d wanted %d %v", test.name, n, test.n, err)
+		}
+	}
+
+	if status, err := c.GetDBStatus(ctx); err != nil || status.BlkCount == 0 {
+		t.Errorf("Unexpected status %+v %v", status, err)
+	}
+
+	root := httptest.NewServer(Handler("/", newTestMemStore()))
+	defer root.Close()
+	if _, err := NewClientPrefix(root.URL+"/", "/v1/").GetDBStatus(ctx); err != nil {
+		t.Errorf("The prefix was not used: %v", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+
+	ts := httptest.NewServer(Handler("/", newTestMemStore()))
+	defer ts.Close()
+	ctx := context.Background()
+
+	for _, prefix := range []string{"/", "/v1/"} {
+		c := NewClientPrefix(ts.URL, prefix)
+
+		txid, _ := wire.NewShaHashFromStr("b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be")
+		_, err := c.GetJsonBltn(ctx, txid)
+		var censored *ErrCensored
+		if !errors.As(err, &censored) || censored.Reason != "The Beatles are slanderous." {
+			t.Errorf("%s: expected the bulletin to be censored: %v", prefix, err)
+		}
+
+		missing, _ := wire.NewShaHashFromStr("2222222222222222222222222222222222222222222222222222222222222222")
+		var notFound *ErrNotFound
+		if _, err := c.GetJsonBltn(ctx, missing); !errors.As(err, &notFound) || notFound.StatusCode != 404 {
+			t.Errorf("%s: expected the bulletin to be missing: %v", prefix, err)
+		}
+
+		addr, _ := btcutil.DecodeAddress("mzZr7uKhA1bSU1dQoLZBqVtqpVKuCpLrx4", &chaincfg.TestNet3Params)
+		if _, err := c.GetJsonAuthor(ctx, addr); !errors.As(err, &notFound) {
+			t.Errorf("%s: expected the author to be missing: %v", prefix, err)
+		}
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+
+	api := Handler("/", newTestMemStore())
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			http.Error(w, "Try again later", 503)
+			return
+		}
+		api.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+
+	var retryTests = []struct {
+		retries  int
+		attempts int32
+		fails    bool
+	}{
+		{0, 1, true},
+		{1, 2, true},
+		{2, 3, false},
+		{5, 3, false},
+	}
+	for _, test := range retryTests {
+		atomic.StoreInt32(&attempts, 0)
+		c := NewClientWith(ts.URL, "/", ClientOptions{Retries: test.retries, Backoff: time.Millisecond})
+		_, err := c.GetDBStatus(ctx)
+
+		var serverErr *ErrServer
+		if test.fails && (!errors.As(err, &serverErr) || serverErr.StatusCode != 503 || serverErr.Message != "Try again later") {
+			t.Errorf("%d retries: expected a 503: %v", test.retries, err)
+		}
+		if !test.fails && err != nil {
+			t.Errorf("%d retries: %v", test.retries, err)
+		}
+		if n := atomic.LoadInt32(&attempts); n != test.attempts {
+			t.Errorf("%d retries: made %d attempts wanted %d", test.retries, n, test.attempts)
+		}
+	}
+
+	// Nothing is retried once the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	atomic.StoreInt32(&attempts, -100)
+	c := NewClientWith(ts.URL, "/", ClientOptions{Retries: 100, Backoff: 10 * time.Millisecond})
+	if _, err := c.GetDBStatus(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded: %v", err)
+	}
+}
@@ -0,0 +1,501 @@
+package ahimsarest
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+var (
+	// The index is checked against the status of the db at most this often.
+	searchRefresh = 10 * time.Second
+	// Matches within a board's name count for this many matches in a message.
+	searchBoardBoost = 2.0
+)
+
+var errNoQuery = errors.New("Missing a query")
+
+// A SearchResult is a single bulletin that matched a query along with its rank.
+// Results with a higher score are more relevant.
+type SearchResult struct {
+	Score float64   `json:"score"`
+	Bltn  *Bulletin `json:"bltn"`
+}
+
+// A SearchResp holds a page of the best matching bulletins of a query. Next is
+// the cursor of the following page and empty on the last one.
+type SearchResp struct {
+	Query   string          `json:"query"`
+	Total   int             `json:"total"`
+	Results []*SearchResult `json:"results"`
+	Next    string          `json:"next,omitempty"`
+}
+
+// A posting lists where a term shows up in a single document.
+type posting struct {
+	doc       int
+	positions []int
+}
+
+// searchDoc is an indexed bulletin.
+type searchDoc struct {
+	bltn     *ombjson.JsonBltn
+	msgLen   int
+	boardLen int
+}
+
+// A searchSnapshot is the index as built from the db at one point. It is never
+// changed once built, so it can be searched without holding any lock.
+type searchSnapshot struct {
+	docs     []*searchDoc
+	msgTerms map[string][]posting
+	brdTerms map[string][]posting
+	avgLen   float64
+}
+
+// A SearchIndex is an in memory full text index over the messages and board
+// names of every bulletin in the public record. It is rebuilt whenever the
+// status of the db changes so it always reflects the blacklist. A single
+// search rebuilds it while the others keep searching the last one built.
+type SearchIndex struct {
+	db Store
+	// Held by the search that checks the db and rebuilds the index.
+	rebuild chan struct{}
+
+	mu      sync.Mutex
+	checked time.Time
+	status  ombjson.Status
+	snap    *searchSnapshot
+	// Counts the invalidations so that a rebuild that raced one is dropped.
+	gen uint64
+}
+
+// NewSearchIndex creates an index over db. Nothing is indexed until the first
+// search.
+func NewSearchIndex(db Store) *SearchIndex {
+	return &SearchIndex{db: db, rebuild: make(chan struct{}, 1)}
+}
+
+// Invalidate drops the index so that the next search waits for it to be
+// rebuilt. Bulletins that were just censored are never found again.
+func (idx *SearchIndex) Invalidate() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.snap = nil
+	idx.gen++
+}
+
+// tokenize lower cases s and splits it into runs of letters and digits.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// snapshot returns the index to search, rebuilding it first if the db has
+// changed since it was last built. Only one search at a time rebuilds, the
+// others search the last index built unless there is none.
+func (idx *SearchIndex) snapshot() (*searchSnapshot, error) {
+
+	idx.mu.Lock()
+	snap := idx.snap
+	fresh := snap != nil && time.Since(idx.checked) < searchRefresh
+	idx.mu.Unlock()
+	if fresh {
+		return snap, nil
+	}
+
+	if snap != nil {
+		select {
+		case idx.rebuild <- struct{}{}:
+		default:
+			return snap, nil
+		}
+	} else {
+		idx.rebuild <- struct{}{}
+	}
+	defer func() { <-idx.rebuild }()
+
+	// Another search may have rebuilt the index while this one waited.
+	idx.mu.Lock()
+	snap, gen, status := idx.snap, idx.gen, idx.status
+	fresh = snap != nil && time.Since(idx.checked) < searchRefresh
+	idx.mu.Unlock()
+	if fresh {
+		return snap, nil
+	}
+
+	newStatus, err := idx.db.GetDBStatus()
+	if err != nil {
+		return nil, err
+	}
+	if snap == nil || *newStatus != status {
+		bltns, err := allBltns(idx.db)
+		if err != nil {
+			return nil, err
+		}
+		blacklist, err := idx.db.GetJsonBlacklist()
+		if err != nil {
+			return nil, err
+		}
+		banned := make(map[string]bool)
+		for _, entry := range blacklist {
+			banned[entry.Txid] = true
+		}
+		snap = buildSnapshot(bltns, banned)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	// The blacklist changed while the index was built, so the next search
+	// builds it again.
+	if idx.gen != gen {
+		return snap, nil
+	}
+	idx.snap, idx.status, idx.checked = snap, *newStatus, time.Now()
+	return snap, nil
+}
+
+// buildSnapshot indexes bltns, leaving out every bulletin that has been
+// censored.
+func buildSnapshot(bltns []*ombjson.JsonBltn, banned map[string]bool) *searchSnapshot {
+
+	idx := &searchSnapshot{
+		msgTerms: make(map[string][]posting),
+		brdTerms: make(map[string][]posting),
+	}
+	total := 0
+
+	for _, bltn := range bltns {
+		// Censored bulletins are never searchable.
+		if bltn.BannedReason != "" || banned[bltn.Txid] {
+			continue
+		}
+
+		n := len(idx.docs)
+		msg, brd := tokenize(bltn.Msg), tokenize(bltn.Board)
+		idx.docs = append(idx.docs, &searchDoc{bltn: bltn, msgLen: len(msg), boardLen: len(brd)})
+		addPostings(idx.msgTerms, n, msg)
+		addPostings(idx.brdTerms, n, brd)
+		total += len(msg)
+	}
+
+	idx.avgLen = 1
+	if len(idx.docs) > 0 && total > 0 {
+		idx.avgLen = float64(total) / float64(len(idx.docs))
+	}
+	return idx
+}
+
+func addPostings(terms map[string][]posting, doc int, tokens []string) {
+	for pos, tok := range tokens {
+		list := terms[tok]
+		if len(list) > 0 && list[len(list)-1].doc == doc {
+			list[len(list)-1].positions = append(list[len(list)-1].positions, pos)
+			continue
+		}
+		terms[tok] = append(list, posting{doc: doc, positions: []int{pos}})
+	}
+}
+
+// allBltns gathers every bulletin by walking every board, including the board
+// with no name.
+func allBltns(db Store) ([]*ombjson.JsonBltn, error) {
+
+	boards, err := db.GetAllBoards()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{""}
+	for _, summary := range boards {
+		if summary.Name != "" {
+			names = append(names, summary.Name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var bltns []*ombjson.JsonBltn
+	for _, name := range names {
+		board, err := db.GetWholeBoard(name)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, bltn := range board.Bltns {
+			if !seen[bltn.Txid] {
+				seen[bltn.Txid] = true
+				bltns = append(bltns, bltn)
+			}
+		}
+	}
+
+	return bltns, nil
+}
+
+// A SearchQuery is a parsed query. Every phrase must appear in either the
+// message or the board name of a bulletin for it to match. A single word is a
+// phrase of length one.
+type SearchQuery struct {
+	Phrases [][]string
+	Board   string
+	Author  string
+	Since   time.Time
+	Until   time.Time
+}
+
+// parseQuery splits q into its phrases. Words wrapped in double quotes form a
+// single phrase.
+func parseQuery(q string) [][]string {
+
+	var phrases [][]string
+	for i, part := range strings.Split(q, `"`) {
+		if i%2 == 1 {
+			if phrase := tokenize(part); len(phrase) > 0 {
+				phrases = append(phrases, phrase)
+			}
+			continue
+		}
+		for _, tok := range tokenize(part) {
+			phrases = append(phrases, []string{tok})
+		}
+	}
+
+	return phrases
+}
+
+// phraseDocs returns the documents that contain phrase in terms along with the
+// number of times the phrase appears in each.
+func phraseDocs(terms map[string][]posting, phrase []string) map[int]int {
+
+	found := make(map[int]int)
+	first := terms[phrase[0]]
+
+	for _, p := range first {
+		for _, start := range p.positions {
+			if hasPhraseAt(terms, p.doc, phrase, start) {
+				found[p.doc]++
+			}
+		}
+	}
+
+	return found
+}
+
+func hasPhraseAt(terms map[string][]posting, doc int, phrase []string, start int) bool {
+	for off, tok := range phrase[1:] {
+		list := terms[tok]
+		i := sort.Search(len(list), func(i int) bool { return list[i].doc >= doc })
+		if i == len(list) || list[i].doc != doc {
+			return false
+		}
+		want := start + off + 1
+		positions := list[i].positions
+		j := sort.SearchInts(positions, want)
+		if j == len(positions) || positions[j] != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Search runs the query against the index and returns every match ranked by
+// BM25 over the messages, with board name matches boosted.
+func (idx *SearchIndex) Search(query SearchQuery) ([]*SearchResult, error) {
+
+	if len(query.Phrases) == 0 {
+		return nil, errNoQuery
+	}
+	snap, err := idx.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return snap.search(query), nil
+}
+
+func (idx *searchSnapshot) search(query SearchQuery) []*SearchResult {
+
+	const k1, b = 1.2, 0.75
+	n := float64(len(idx.docs))
+
+	var scores map[int]float64
+	for _, phrase := range query.Phrases {
+		inMsg := phraseDocs(idx.msgTerms, phrase)
+		inBrd := phraseDocs(idx.brdTerms, phrase)
+
+		df := float64(len(inMsg))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		matched := make(map[int]float64)
+		for doc, tf := range inMsg {
+			l := float64(idx.docs[doc].msgLen)
+			f := float64(tf)
+			matched[doc] += idf * f * (k1 + 1) / (f + k1*(1-b+b*l/idx.avgLen))
+		}
+		for doc, tf := range inBrd {
+			matched[doc] += idf * searchBoardBoost * float64(tf) / float64(idx.docs[doc].boardLen)
+		}
+
+		// Every phrase has to match so only documents found so far survive.
+		if scores == nil {
+			scores = matched
+			continue
+		}
+		for doc, score := range scores {
+			if s, ok := matched[doc]; ok {
+				scores[doc] = score + s
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+
+	results := []*SearchResult{}
+	for doc, score := range scores {
+		bltn := idx.docs[doc].bltn
+		if query.Board != "" && bltn.Board != query.Board {
+			continue
+		}
+		if query.Author != "" && bltn.Author != query.Author {
+			continue
+		}
+		ts := time.Unix(bltn.Timestamp, 0)
+		if !query.Since.IsZero() && ts.Before(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && ts.After(query.Until) {
+			continue
+		}
+		results = append(results, &SearchResult{Score: score, Bltn: &Bulletin{JsonBltn: bltn}})
+	}
+
+	sort.Sort(resultsByScore(results))
+	return results
+}
+
+type resultsByScore []*SearchResult
+
+func (s resultsByScore) Len() int      { return len(s) }
+func (s resultsByScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s resultsByScore) Less(i, j int) bool {
+	if s[i].Score == s[j].Score {
+		return bltnKey(s[i].Bltn.JsonBltn) > bltnKey(s[j].Bltn.JsonBltn)
+	}
+	return s[i].Score > s[j].Score
+}
+
+// searchKey is the cursor of a result, holding both of the keys results are
+// ordered by.
+func searchKey(res *SearchResult) string {
+	return strconv.FormatFloat(res.Score, 'g', -1, 64) + " " + bltnKey(res.Bltn.JsonBltn)
+}
+
+// pageResults returns the page of the ranked results described by p. Since
+// results are ordered by score the cursor of the previous page is found by
+// its score first.
+func pageResults(results []*SearchResult, p pageParams) ([]*SearchResult, string, error) {
+
+	start := 0
+	if p.after != "" {
+		parts := strings.SplitN(p.after, " ", 2)
+		if len(parts) != 2 {
+			return nil, "", errBadCursor
+		}
+		score, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, "", errBadCursor
+		}
+		start = sort.Search(len(results), func(i int) bool {
+			res := results[i]
+			return res.Score < score || res.Score == score && bltnKey(res.Bltn.JsonBltn) < parts[1]
+		})
+	}
+
+	end := start + p.limit
+	if end >= len(results) {
+		return results[start:], "", nil
+	}
+	return results[start:end], encodeCursor(searchKey(results[end-1])), nil
+}
+
+// parseTime accepts either unix seconds or an RFC 3339 timestamp.
+func parseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Handles full text searches over bulletins. The query in ?q= may contain
+// "quoted phrases" and can be narrowed with ?board=, ?author=, ?since=,
+// ?until= and ?minconf=. Results are served ?limit= at a time along with the
+// cursor of the next page. Censored bulletins never show up in the results.
+func SearchHandler(idx *SearchIndex, ci *ChainIndex) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		q := request.URL.Query()
+		query := SearchQuery{
+			Phrases: parseQuery(q.Get("q")),
+			Board:   q.Get("board"),
+			Author:  q.Get("author"),
+		}
+		if len(query.Phrases) == 0 {
+			writeError(w, request, 400, errNoQuery.Error())
+			return
+		}
+
+		var err error
+		if s := q.Get("since"); s != "" {
+			if query.Since, err = parseTime(s); err != nil {
+				writeError(w, request, 400, "since is not a valid time")
+				return
+			}
+		}
+		if s := q.Get("until"); s != "" {
+			if query.Until, err = parseTime(s); err != nil {
+				writeError(w, request, 400, "until is not a valid time")
+				return
+			}
+		}
+
+		v, pp, minconf, ok := listParams(w, request, ci)
+		if !ok {
+			return
+		}
+
+		found, err := idx.Search(query)
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		results := []*SearchResult{}
+		for _, result := range found {
+			result.Bltn = v.bulletin(result.Bltn.JsonBltn)
+			if result.Bltn.Confirmations >= minconf {
+				results = append(results, result)
+			}
+		}
+
+		page, next, err := pageResults(results, pp)
+		if err != nil {
+			writeError(w, request, 400, err.Error())
+			return
+		}
+
+		writeJson(w, request, SearchResp{Query: q.Get("q"), Total: len(results), Results: page, Next: next})
+	}
+}
@@ -0,0 +1,104 @@
+package ahimsarest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+var searchBltns = []*ombjson.JsonBltn{
+	{Txid: "a", Board: "ahimsa-dev", Author: "mraY", Msg: "Here comes the sun", Timestamp: 100},
+	{Txid: "b", Board: "ahimsa-dev", Author: "miUD", Msg: "the mind is our medium", Timestamp: 200},
+	{Txid: "c", Board: "sun-worship", Author: "miUD", Msg: "praise the sun, the sun is warm", Timestamp: 300},
+	{Txid: "d", Board: "ahimsa-dev", Author: "mnPZ", Msg: "", BannedReason: "The Beatles are slanderous.", Timestamp: 400},
+	{Txid: "e", Board: "ahimsa-dev", Author: "mnPZ", Msg: "the sun also rises", Timestamp: 500},
+}
+
+func newTestIndex() *SearchIndex {
+	idx := NewSearchIndex(nil)
+	idx.snap = buildSnapshot(searchBltns, map[string]bool{"e": true})
+	idx.checked = time.Now()
+	return idx
+}
+
+func TestParseQuery(t *testing.T) {
+
+	got := parseQuery(`Sun "comes the  SUN" mind`)
+	want := [][]string{{"sun"}, {"comes", "the", "sun"}, {"mind"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parsed %v, wanted %v", got, want)
+	}
+}
+
+var searchTests = []struct {
+	query SearchQuery
+	txids []string
+}{
+	// The board name and repeated terms rank c above a.
+	{SearchQuery{Phrases: [][]string{{"sun"}}}, []string{"c", "a"}},
+	{SearchQuery{Phrases: [][]string{{"comes", "the", "sun"}}}, []string{"a"}},
+	{SearchQuery{Phrases: [][]string{{"the", "sun", "comes"}}}, []string{}},
+	{SearchQuery{Phrases: [][]string{{"sun"}, {"praise"}}}, []string{"c"}},
+	// Ties go to the newest bulletin.
+	{SearchQuery{Phrases: [][]string{{"ahimsa"}}}, []string{"b", "a"}},
+	{SearchQuery{Phrases: [][]string{{"sun"}}, Author: "mraY"}, []string{"a"}},
+	{SearchQuery{Phrases: [][]string{{"sun"}}, Board: "sun-worship"}, []string{"c"}},
+	{SearchQuery{Phrases: [][]string{{"sun"}}, Since: time.Unix(150, 0)}, []string{"c"}},
+	{SearchQuery{Phrases: [][]string{{"the"}}, Until: time.Unix(250, 0)}, []string{"a", "b"}},
+	// Censored bulletins are never found, neither by their reason nor through
+	// the blacklist.
+	{SearchQuery{Phrases: [][]string{{"beatles"}}}, []string{}},
+	{SearchQuery{Phrases: [][]string{{"rises"}}}, []string{}},
+}
+
+func TestSearch(t *testing.T) {
+
+	idx := newTestIndex()
+	for _, testCase := range searchTests {
+		results, err := idx.Search(testCase.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txids := []string{}
+		for _, res := range results {
+			txids = append(txids, res.Bltn.Txid)
+		}
+		if !reflect.DeepEqual(txids, testCase.txids) {
+			t.Errorf("Query %v found %v, wanted %v", testCase.query, txids, testCase.txids)
+		}
+	}
+}
+
+// Asserts that paging through the results of a query returns each of them
+// once and in rank order.
+func TestPageResults(t *testing.T) {
+
+	results, err := newTestIndex().Search(SearchQuery{Phrases: [][]string{{"the"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paged []*SearchResult
+	p := pageParams{limit: 1}
+	for i := 0; i <= len(results); i++ {
+		page, next, err := pageResults(results, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, page...)
+		if next == "" {
+			break
+		}
+		p.after = searchKey(page[len(page)-1])
+	}
+	if !reflect.DeepEqual(paged, results) {
+		t.Errorf("Paged through %v wanted %v", paged, results)
+	}
+
+	if _, _, err := pageResults(results, pageParams{limit: 1, after: "sun"}); err != errBadCursor {
+		t.Errorf("Expected a bad cursor, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package ahimsarest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// The version of the signed blacklist format that is written and understood.
+// Version 2 added the sequence number.
+const signedBlacklistVersion = 2
+
+var (
+	errBadSignature       = errors.New("The signature does not match the issuer")
+	errUnsupportedVersion = errors.New("The version of the blacklist is not supported")
+	errBadEntry           = errors.New("The blacklist holds an entry that is not a txid")
+)
+
+// A SignedBlacklist is a blacklist that an operator shares with others. The
+// signature is a bitcoin signed message made by the key of the issuer over the
+// text returned by Message, so it can be checked by any wallet as well. The
+// sequence number grows whenever the issuer changes the list, so importers can
+// tell which of two lists is newer even when both were created within the
+// same second.
+type SignedBlacklist struct {
+	Version   int                       `json:"version"`
+	Issuer    string                    `json:"issuer"`
+	Created   int64                     `json:"created"`
+	Sequence  uint64                    `json:"sequence"`
+	Entries   []*ombjson.BlacklistEntry `json:"entries"`
+	Signature string                    `json:"signature"`
+}
+
+// Message returns the text that the issuer signs. It lists the header of the
+// blacklist followed by a line for every entry holding its txid and its
+// reason as a json string.
+func (l *SignedBlacklist) Message() []byte {
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "ombuds blacklist\nversion: %d\nissuer: %s\ncreated: %d\nsequence: %d\n", l.Version, l.Issuer, l.Created, l.Sequence)
+	for _, entry := range l.Entries {
+		reason, _ := json.Marshal(entry.Reason)
+		fmt.Fprintf(&b, "%s %s\n", entry.Txid, reason)
+	}
+
+	return b.Bytes()
+}
+
+// signedMessageHash is the hash that bitcoin wallets sign for msg.
+func signedMessageHash(msg []byte) []byte {
+
+	var b bytes.Buffer
+	b.WriteString("\x18Bitcoin Signed Message:\n")
+	writeVarInt(&b, uint64(len(msg)))
+	b.Write(msg)
+
+	first := sha256.Sum256(b.Bytes())
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// Sign sets the issuer of the blacklist to the address of key on net and signs
+// it. The entries are sorted by txid first.
+func (l *SignedBlacklist) Sign(key *btcec.PrivateKey, net *chaincfg.Params) error {
+
+	issuer, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(key.PubKey().SerializeCompressed()), net)
+	if err != nil {
+		return err
+	}
+
+	sort.Sort(blacklistByTxid(l.Entries))
+	l.Version = signedBlacklistVersion
+	l.Issuer = issuer.EncodeAddress()
+
+	sig, err := btcec.SignCompact(btcec.S256(), key, signedMessageHash(l.Message()), true)
+	if err != nil {
+		return err
+	}
+	l.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	return nil
+}
+
+// Verify checks that the blacklist was signed by its issuer on net and that
+// every entry is well formed.
+func (l *SignedBlacklist) Verify(net *chaincfg.Params) error {
+
+	if l.Version != signedBlacklistVersion {
+		return errUnsupportedVersion
+	}
+	for _, entry := range l.Entries {
+		if b, err := hex.DecodeString(entry.Txid); err != nil || len(b) != 32 {
+			return errBadEntry
+		}
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(l.Signature)
+	if err != nil {
+		return errBadSignature
+	}
+	pub, compressed, err := btcec.RecoverCompact(btcec.S256(), sig, signedMessageHash(l.Message()))
+	if err != nil {
+		return errBadSignature
+	}
+
+	serialized := pub.SerializeUncompressed()
+	if compressed {
+		serialized = pub.SerializeCompressed()
+	}
+	addr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(serialized), net)
+	if err != nil || addr.EncodeAddress() != l.Issuer {
+		return errBadSignature
+	}
+
+	return nil
+}
+
+// A blacklistSequence numbers the lists an api signs. The number only moves
+// when the entries change, so the same list is always served under the same
+// number. It starts from the clock when the api starts so that it keeps growing
+// across restarts.
+type blacklistSequence struct {
+	mu   sync.Mutex
+	seq  uint64
+	last []byte
+}
+
+// next returns the sequence number of a list holding entries.
+func (s *blacklistSequence) next(entries []*ombjson.BlacklistEntry) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := (&SignedBlacklist{Entries: entries}).Message()
+	if s.seq == 0 {
+		s.seq = uint64(time.Now().UnixNano())
+	} else if !bytes.Equal(msg, s.last) {
+		s.seq++
+	}
+	s.last = msg
+	return s.seq
+}
+
+// Serves the blacklist signed by the key of the operator so that other
+// operators can import it. When o is set only the entries of the store and of
+// the operator are signed, so that imported entries are never passed on as the
+// operator's own.
+func SignedBlacklistHandler(db Store, o *BlacklistOverlay, key *btcec.PrivateKey, net *chaincfg.Params) func(http.ResponseWriter, *http.Request) {
+	seq := &blacklistSequence{}
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		if key == nil {
+			writeError(w, request, 501, "This api does not sign its blacklist")
+			return
+		}
+
+		var blacklist []*ombjson.BlacklistEntry
+		var err error
+		if o != nil {
+			blacklist, err = o.OwnBlacklist()
+		} else {
+			blacklist, err = db.GetJsonBlacklist()
+		}
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		sort.Sort(blacklistByTxid(blacklist))
+		signed := &SignedBlacklist{Created: time.Now().Unix(), Sequence: seq.next(blacklist), Entries: blacklist}
+		if err := signed.Sign(key, net); err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeJson(w, request, signed)
+	}
+}
@@ -0,0 +1,221 @@
+package ahimsarest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+func testKey(b byte) *btcec.PrivateKey {
+	key, _ := btcec.PrivKeyFromBytes(btcec.S256(), bytes.Repeat([]byte{b}, 32))
+	return key
+}
+
+func TestSignedBlacklist(t *testing.T) {
+
+	net := &chaincfg.TestNet3Params
+	newList := func() *SignedBlacklist {
+		list := &SignedBlacklist{
+			Created: 1415862580,
+			Entries: []*ombjson.BlacklistEntry{
+				{Txid: "f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf", Reason: "Spam\nwith a newline"},
+				{Txid: "933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9", Reason: "Off topic"},
+			},
+		}
+		if err := list.Sign(testKey(0x11), net); err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	if err := newList().Verify(net); err != nil {
+		t.Fatalf("A signed list does not verify: %s", err)
+	}
+	if list := newList(); list.Entries[0].Txid > list.Entries[1].Txid {
+		t.Errorf("The entries were not sorted")
+	}
+
+	var tamperTests = []struct {
+		tamper func(*SignedBlacklist)
+		err    error
+	}{
+		{func(l *SignedBlacklist) { l.Entries[0].Reason = "Changed" }, errBadSignature},
+		{func(l *SignedBlacklist) { l.Entries = l.Entries[1:] }, errBadSignature},
+		{func(l *SignedBlacklist) { l.Created++ }, errBadSignature},
+		{func(l *SignedBlacklist) { l.Sequence++ }, errBadSignature},
+		{func(l *SignedBlacklist) { l.Issuer = "mraY7GWs4G65ZYqWoPEqwPxb6aMuufTq2c" }, errBadSignature},
+		{func(l *SignedBlacklist) { l.Signature = "not base64" }, errBadSignature},
+		{func(l *SignedBlacklist) { l.Version = 1 }, errUnsupportedVersion},
+		{func(l *SignedBlacklist) { l.Entries[0].Txid = "beef" }, errBadEntry},
+	}
+	for i, test := range tamperTests {
+		list := newList()
+		test.tamper(list)
+		if err := list.Verify(net); err != test.err {
+			t.Errorf("%d: got %v wanted %v", i, err, test.err)
+		}
+	}
+}
+
+// Asserts that one operator can follow the blacklist of another.
+func TestBlacklistSubscription(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ahimsarest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newOperator := func(name string, key *btcec.PrivateKey) (*httptest.Server, *BlacklistOverlay) {
+		store := newTestMemStore()
+		overlay, err := NewBlacklistOverlay(store, filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := DefaultOptions
+		opts.Net = &chaincfg.TestNet3Params
+		opts.Blacklist = overlay
+		opts.AdminTokens = map[string]string{name: name}
+		opts.SigningKey = key
+		return httptest.NewServer(NewHandler("/", store, opts)), overlay
+	}
+
+	a, aOverlay := newOperator("alice", testKey(0x11))
+	defer a.Close()
+	b, bOverlay := newOperator("bob", nil)
+	defer b.Close()
+
+	txid := "933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9"
+	if _, err := aOverlay.Add("alice", txid, "Off topic"); err != nil {
+		t.Fatal(err)
+	}
+
+	exported := get(t, a.URL+"/blacklist/signed")
+	var list SignedBlacklist
+	if err := json.Unmarshal([]byte(exported), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 2 {
+		t.Errorf("Expected both entries of alice: %s", exported)
+	}
+	if res := doGet(t, b.URL+"/blacklist/signed", nil); res.StatusCode != 501 {
+		t.Errorf("An api without a key should not export: %d", res.StatusCode)
+	}
+
+	var subTests = []struct {
+		method, url, body string
+		statuscode        int
+	}{
+		{"POST", b.URL + "/admin/blacklist/import", exported, 403},
+		{"PUT", b.URL + "/admin/subscriptions/" + list.Issuer, "", 204},
+		{"POST", b.URL + "/admin/blacklist/import", "{}", 400},
+		{"POST", b.URL + "/admin/blacklist/import", exported, 200},
+		{"GET", b.URL + "/bulletin/" + txid, "", 451},
+		{"POST", b.URL + "/admin/blacklist/import", exported, 409},
+		{"PUT", b.URL + "/admin/overrides/" + txid, `{"reason": "Fine by us"}`, 204},
+		{"GET", b.URL + "/bulletin/" + txid, "", 200},
+		{"DELETE", b.URL + "/admin/overrides/" + txid, "", 204},
+		{"DELETE", b.URL + "/admin/overrides/" + txid, "", 404},
+		{"GET", b.URL + "/bulletin/" + txid, "", 451},
+		{"DELETE", b.URL + "/admin/subscriptions/" + list.Issuer, "", 204},
+		{"GET", b.URL + "/bulletin/" + txid, "", 200},
+		{"DELETE", b.URL + "/admin/subscriptions/" + list.Issuer, "", 404},
+		{"PUT", b.URL + "/admin/subscriptions/" + list.Issuer + "/list", "", 404},
+		{"PUT", b.URL + "/admin/subscriptions/" + list.Issuer, `{"url": "ftp://alice"}`, 400},
+		{"PUT", b.URL + "/admin/subscriptions/" + list.Issuer, "", 204},
+		{"PUT", b.URL + "/admin/subscriptions/" + list.Issuer + "/list", "", 409},
+		{"PUT", b.URL + "/admin/subscriptions/" + list.Issuer, `{"url": "` + a.URL + `/blacklist/signed"}`, 204},
+		{"PUT", b.URL + "/admin/subscriptions/" + list.Issuer + "/list", "", 200},
+		{"GET", b.URL + "/bulletin/" + txid, "", 451},
+	}
+	for _, test := range subTests {
+		res := doAdmin(t, test.method, test.url, "bob", test.body)
+		if res.StatusCode != test.statuscode {
+			t.Errorf("%s %s: got %d wanted %d", test.method, test.url, res.StatusCode, test.statuscode)
+		}
+	}
+
+	// A change made within the same second as the list bob holds is still
+	// picked up, while refreshing an unchanged list leaves it be.
+	held := bOverlay.Subscriptions()[0]
+	newer := "f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf"
+	if _, err := aOverlay.Add("alice", newer, "Spam"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		sub, err := bOverlay.Refresh(context.Background(), "bob", list.Issuer, &chaincfg.TestNet3Params)
+		if err != nil || sub.Entries != 3 || sub.Sequence != held.Sequence+1 {
+			t.Errorf("Unexpected subscription %+v after %+v: %v", sub, held, err)
+		}
+	}
+	if res := doGet(t, b.URL+"/bulletin/"+newer, nil); res.StatusCode != 451 {
+		t.Errorf("The refreshed entry is not enforced: %d", res.StatusCode)
+	}
+}
+
+// Asserts that an operator only signs the entries they vouch for.
+func TestSignedBlacklistOwnEntries(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ahimsarest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	net := &chaincfg.TestNet3Params
+	imported := &SignedBlacklist{
+		Created: 1415862580,
+		Entries: []*ombjson.BlacklistEntry{{Txid: "f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf", Reason: "Spam"}},
+	}
+	if err := imported.Sign(testKey(0x11), net); err != nil {
+		t.Fatal(err)
+	}
+
+	overlay, err := NewBlacklistOverlay(newTestMemStore(), filepath.Join(dir, "carol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overlay.Subscribe("carol", imported.Issuer, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overlay.Import("carol", imported, net); err != nil {
+		t.Fatal(err)
+	}
+	own := "933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9"
+	if _, err := overlay.Add("carol", own, "Off topic"); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions
+	opts.Net = net
+	opts.Blacklist = overlay
+	opts.SigningKey = testKey(0x33)
+	ts := httptest.NewServer(NewHandler("/", overlay.Store, opts))
+	defer ts.Close()
+
+	var list SignedBlacklist
+	if err := json.Unmarshal([]byte(get(t, ts.URL+"/blacklist/signed")), &list); err != nil {
+		t.Fatal(err)
+	}
+	txids := map[string]bool{}
+	for _, entry := range list.Entries {
+		txids[entry.Txid] = true
+	}
+	if len(list.Entries) != 2 || !txids[own] || txids[imported.Entries[0].Txid] {
+		t.Errorf("Expected the entries of the store and of carol alone: %+v", list.Entries)
+	}
+
+	// The imported entry is still enforced.
+	if blacklist, _ := overlay.GetJsonBlacklist(); len(blacklist) != 3 {
+		t.Errorf("Expected every entry to be enforced: %+v", blacklist)
+	}
+}
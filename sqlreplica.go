@@ -0,0 +1,236 @@
+package ahimsarest
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "code.google.com/p/go-sqlite/go1/sqlite3"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// The columns of the tables of a pubrecdb public record that a SQLReplica
+// writes. A record that lacks any of them was laid out by a version of pubrecdb
+// that the replica does not know how to write into.
+var replicaSchema = []struct {
+	table   string
+	create  string
+	columns []string
+}{
+	{"blocks", "CREATE TABLE blocks (hash TEXT NOT NULL, prevhash TEXT NOT NULL, height INT NOT NULL, timestamp INT NOT NULL, PRIMARY KEY(hash))",
+		[]string{"hash", "prevhash", "height", "timestamp"}},
+	{"bulletins", "CREATE TABLE bulletins (txid TEXT NOT NULL, block TEXT, author TEXT NOT NULL, board TEXT, message TEXT, timestamp INT, PRIMARY KEY(txid))",
+		[]string{"txid", "block", "author", "board", "message", "timestamp"}},
+	{"blacklist", "CREATE TABLE blacklist (txid TEXT NOT NULL, reason TEXT, PRIMARY KEY(txid))",
+		[]string{"txid", "reason"}},
+}
+
+// A SQLReplica is a Replica kept in the sqlite database of a pubrecdb public
+// record, so that whatever it copies is served by pubrecdb.LoadDB just like a
+// record built from the chain. A block is written along with its bulletins in a
+// single transaction so that a sync that was interrupted never leaves a block
+// behind without its bulletins.
+type SQLReplica struct {
+	conn *sql.DB
+}
+
+// OpenSQLReplica opens the public record at path. A new file is laid out the
+// way pubrecdb lays out a record, while an existing one must hold every column
+// the replica writes.
+func OpenSQLReplica(path string) (*SQLReplica, error) {
+
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReplicaSchema(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &SQLReplica{conn: conn}, nil
+}
+
+// checkReplicaSchema creates the tables that are missing and checks that the
+// ones that exist hold the columns of replicaSchema.
+func checkReplicaSchema(conn *sql.DB) error {
+	for _, t := range replicaSchema {
+		rows, err := conn.Query("PRAGMA table_info(" + t.table + ")")
+		if err != nil {
+			return err
+		}
+		held := make(map[string]bool)
+		for rows.Next() {
+			var cid, notnull, pk int
+			var name, kind string
+			var dflt interface{}
+			if err := rows.Scan(&cid, &name, &kind, &notnull, &dflt, &pk); err != nil {
+				rows.Close()
+				return err
+			}
+			held[name] = true
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		if len(held) == 0 {
+			if _, err := conn.Exec(t.create); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, column := range t.columns {
+			if !held[column] {
+				return fmt.Errorf("The %s table of the public record has no %s column", t.table, column)
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the database.
+func (r *SQLReplica) Close() error {
+	return r.conn.Close()
+}
+
+func (r *SQLReplica) SyncedHeight() (uint64, bool, error) {
+	var height uint64
+	err := r.conn.QueryRow("SELECT height FROM blocks ORDER BY height DESC LIMIT 1").Scan(&height)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return height, true, nil
+}
+
+func (r *SQLReplica) BlockHash(height uint64) (string, bool, error) {
+	var hash string
+	err := r.conn.QueryRow("SELECT hash FROM blocks WHERE height = ? ORDER BY hash LIMIT 1", height).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return hash, true, nil
+}
+
+func (r *SQLReplica) PutBlock(head *ombjson.JsonBlkHead, bltns []*ombjson.JsonBltn) error {
+
+	tx, err := r.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO blocks (hash, prevhash, height, timestamp) VALUES (?, ?, ?, ?)",
+		head.Hash, head.PrevHash, head.Height, head.Timestamp)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := putBltns(tx, bltns); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLReplica) DropBlocks(height uint64) (int, error) {
+
+	tx, err := r.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM bulletins WHERE block IN (SELECT hash FROM blocks WHERE height >= ?)", height)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM blocks WHERE height >= ?", height)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	dropped, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return int(dropped), tx.Commit()
+}
+
+func (r *SQLReplica) PutUnconfirmed(bltns []*ombjson.JsonBltn) (int, error) {
+
+	tx, err := r.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	keep := make(map[string]bool)
+	for _, bltn := range bltns {
+		keep[bltn.Txid] = true
+	}
+	var stale []string
+	rows, err := tx.Query("SELECT txid FROM bulletins WHERE block IS NULL")
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	for rows.Next() {
+		var txid string
+		if err := rows.Scan(&txid); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, err
+		}
+		if !keep[txid] {
+			stale = append(stale, txid)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for _, txid := range stale {
+		if _, err := tx.Exec("DELETE FROM bulletins WHERE txid = ?", txid); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := putBltns(tx, bltns); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return len(stale), tx.Commit()
+}
+
+// putBltns writes bltns within tx. Censored bulletins are also blacklisted.
+func putBltns(tx *sql.Tx, bltns []*ombjson.JsonBltn) error {
+	for _, bltn := range bltns {
+		// Unconfirmed bulletins have no block.
+		var blk interface{}
+		if bltn.Blk != "" {
+			blk = bltn.Blk
+		}
+		_, err := tx.Exec("INSERT OR REPLACE INTO bulletins (txid, block, author, board, message, timestamp) VALUES (?, ?, ?, ?, ?, ?)",
+			bltn.Txid, blk, bltn.Author, bltn.Board, bltn.Msg, bltn.Timestamp)
+		if err != nil {
+			return err
+		}
+
+		if bltn.BannedReason == "" {
+			continue
+		}
+		_, err = tx.Exec("INSERT OR REPLACE INTO blacklist (txid, reason) VALUES (?, ?)", bltn.Txid, bltn.BannedReason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
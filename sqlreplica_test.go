@@ -0,0 +1,139 @@
+package ahimsarest
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+	"github.com/soapboxsys/ombudslib/pubrecdb"
+)
+
+// What a SQLReplica copied must be served by pubrecdb just like the remote
+// serves it.
+func TestSQLReplica(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ahimsarest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pubrecord.db")
+
+	remote := newTestMemStore()
+	opts := DefaultOptions
+	opts.CacheBytes = 0
+	opts.ChainRefresh = 0
+	ts := httptest.NewServer(NewHandler("/api/", remote, opts))
+	defer ts.Close()
+	cli := NewClient(ts.URL)
+
+	replica, err := OpenSQLReplica(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := NewMirror(cli, replica).Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blocks != 6 || stats.Height != 307012 || stats.Unconfirmed != 4 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// A replica that is opened again resumes from where it stopped.
+	tip := "00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff"
+	hash := "000000000000000000000000000000000000000000000000000000000000ffff"
+	remote.AddBlock(&ombjson.JsonBlkHead{Hash: hash, PrevHash: tip, Timestamp: 1415862700, Height: 307013})
+	remote.AddBltn(&ombjson.JsonBltn{Txid: "e1", Board: "ahimsa-dev", Author: "miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH", Msg: "resumed", Timestamp: 1415862650, Blk: hash})
+
+	replica, err = OpenSQLReplica(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	stats, err = NewMirror(cli, replica).Sync(context.Background())
+	if err != nil || stats.Blocks != 1 || stats.Bltns != 1 || stats.Height != 307013 {
+		t.Errorf("Unexpected stats %+v %v", stats, err)
+	}
+
+	// A reorg at the tip is followed as well.
+	fork := "000000000000000000000000000000000000000000000000000000000000eeee"
+	remote.RemoveBlock(hash)
+	remote.AddBlock(&ombjson.JsonBlkHead{Hash: fork, PrevHash: tip, Timestamp: 1415862710, Height: 307013})
+	remote.AddBltn(&ombjson.JsonBltn{Txid: "e1", Board: "ahimsa-dev", Author: "miUDcP8obUKPhqkrBrQz57sbSg2Mz1kZXH", Msg: "resumed", Timestamp: 1415862650, Blk: fork})
+	stats, err = NewMirror(cli, replica).Sync(context.Background())
+	if err != nil || stats.Dropped != 1 || stats.Blocks != 1 || stats.Height != 307013 {
+		t.Errorf("Unexpected stats %+v %v", stats, err)
+	}
+	if held, _, _ := replica.BlockHash(307013); held != fork {
+		t.Errorf("The replica holds %s at the fork wanted %s", held, fork)
+	}
+
+	record, err := pubrecdb.LoadDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := remote.GetDBStatus()
+	got, err := record.GetDBStatus()
+	if err != nil || *got != *want {
+		t.Errorf("The record holds %+v wanted %+v: %v", got, want, err)
+	}
+
+	censored := "b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be"
+	entries, err := record.GetJsonBlacklist()
+	if err != nil || len(entries) != 1 || entries[0].Txid != censored || entries[0].Reason != "The Beatles are slanderous." {
+		t.Errorf("Unexpected blacklist %+v %v", entries, err)
+	}
+	if _, err := record.GetJsonBltn(censored); err != pubrecdb.ErrBltnCensored {
+		t.Errorf("Expected the censored bulletin to stay censored: %v", err)
+	}
+
+	recent, _ := remote.GetRecentConf(100)
+	unconfirmed, _ := remote.GetUnconfirmed()
+	for _, bltn := range append(recent, unconfirmed...) {
+		got, err := record.GetJsonBltn(bltn.Txid)
+		if err != nil || !reflect.DeepEqual(got, bltn) {
+			t.Errorf("The record holds %+v wanted %+v: %v", got, bltn, err)
+		}
+	}
+
+	bltns, err := record.GetUnconfirmed()
+	if err != nil || len(bltns) != len(unconfirmed) {
+		t.Errorf("The record holds %d unconfirmed bulletins wanted %d: %v", len(bltns), len(unconfirmed), err)
+	}
+}
+
+// A record laid out differently than the replica expects is not written into.
+func TestSQLReplicaSchema(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ahimsarest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pubrecord.db")
+
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec("CREATE TABLE blocks (hash TEXT NOT NULL, height INT NOT NULL, PRIMARY KEY(hash))")
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replica, err := OpenSQLReplica(path); err == nil {
+		replica.Close()
+		t.Errorf("Opened a record without a prevhash column")
+	}
+}
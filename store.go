@@ -0,0 +1,29 @@
+package ahimsarest
+
+import (
+	"time"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+	"github.com/soapboxsys/ombudslib/pubrecdb"
+)
+
+// A Store is the read only view of a public record that the handlers serve.
+// A *pubrecdb.PublicRecord is a Store. Implementations must report missing
+// items with sql.ErrNoRows and blacklisted bulletins with
+// pubrecdb.ErrBltnCensored just like pubrecdb does.
+type Store interface {
+	GetJsonBltn(txid string) (*ombjson.JsonBltn, error)
+	GetJsonBlock(hash string) (*ombjson.JsonBlock, error)
+	GetJsonBlockHead(hash string) (*ombjson.JsonBlkHead, error)
+	GetJsonAuthor(addr string) (*ombjson.AuthorResp, error)
+	GetJsonBlacklist() ([]*ombjson.BlacklistEntry, error)
+	GetWholeBoard(board string) (*ombjson.WholeBoard, error)
+	GetAllBoards() ([]*ombjson.BoardSummary, error)
+	GetAllAuthors() ([]*ombjson.AuthorSummary, error)
+	GetRecentConf(n int) ([]*ombjson.JsonBltn, error)
+	GetUnconfirmed() ([]*ombjson.JsonBltn, error)
+	GetBlocksByDay(day time.Time) ([]*ombjson.JsonBlkHead, error)
+	GetDBStatus() (*ombjson.Status, error)
+}
+
+var _ Store = (*pubrecdb.PublicRecord)(nil)
@@ -0,0 +1,91 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// How often a comment is sent down an idle stream to keep proxies from closing
+// the connection.
+var streamKeepAlive = 30 * time.Second
+
+// Serves the events of the feed as a stream of Server-Sent Events. A client
+// that reconnects with a Last-Event-ID header is sent every event it missed
+// that is still in the feed's history.
+func StreamHandler(f *Feed) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		if f == nil {
+			writeError(w, request, 501, "This api does not push events")
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, request, 500, "Streaming is not supported")
+			return
+		}
+
+		var lastID uint64
+		if s := request.Header.Get("Last-Event-ID"); s != "" {
+			var err error
+			lastID, err = strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				writeError(w, request, 400, "Last-Event-ID is not valid")
+				return
+			}
+		}
+
+		sub := f.Subscribe(lastID)
+		defer f.Unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(200)
+
+		for _, ev := range sub.Backlog {
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case ev, ok := <-sub.C:
+				if !ok {
+					// The client fell behind. It can reconnect and resume.
+					return
+				}
+				if err := writeEvent(w, ev); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case <-request.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes a single event in the text/event-stream format.
+func writeEvent(w http.ResponseWriter, ev *Event) error {
+
+	data, err := json.Marshal(ev.Payload())
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Kind, data)
+	return err
+}
@@ -0,0 +1,335 @@
+package ahimsarest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+var (
+	errNoStream = errors.New("the api does not stream events")
+	// The longest a subscription waits before reconnecting to a stream.
+	maxStreamWait = time.Minute
+)
+
+// A SubscribeFilter picks the events a subscription delivers. The zero value
+// delivers everything.
+type SubscribeFilter struct {
+	// Only events of these kinds are delivered when any are given.
+	Kinds []string
+	// Events that carry a bulletin must match the board and the author when
+	// they are set.
+	Board  string
+	Author string
+}
+
+func (sf SubscribeFilter) matches(ev *Event) bool {
+	if len(sf.Kinds) > 0 {
+		found := false
+		for _, kind := range sf.Kinds {
+			found = found || kind == ev.Kind
+		}
+		if !found {
+			return false
+		}
+	}
+	if ev.Bltn == nil {
+		return true
+	}
+	return (sf.Board == "" || ev.Bltn.Board == sf.Board) &&
+		(sf.Author == "" || ev.Bltn.Author == sf.Author)
+}
+
+// Subscribe delivers the new bulletins, confirmations and blocks that match
+// filter until ctx is done, when the channel is closed. Events are read off
+// the api's stream, which is reconnected to without missing events. An api
+// without a stream is polled every PollInterval instead. Polled events carry no
+// id and reorgs are only learnt of through the stream.
+func (c Client) Subscribe(ctx context.Context, filter SubscribeFilter) <-chan *Event {
+
+	out := make(chan *Event)
+	emit := func(ev *Event) bool {
+		if !filter.matches(ev) {
+			return true
+		}
+		select {
+		case out <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(out)
+		if c.stream(ctx, emit) == errNoStream {
+			c.poll(ctx, emit)
+		}
+	}()
+
+	return out
+}
+
+// sleep waits for d or until ctx is done and reports whether ctx is still live.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// stream reads events off the api's stream and reconnects whenever the stream
+// ends, resuming after the last event seen.
+func (c Client) stream(ctx context.Context, emit func(*Event) bool) error {
+
+	var lastID uint64
+	wait := c.opts.Backoff
+	for {
+		seen := lastID
+		err := c.readStream(ctx, &lastID, emit)
+		if err == errNoStream || ctx.Err() != nil {
+			return err
+		}
+
+		if lastID != seen {
+			wait = c.opts.Backoff
+		}
+		if !sleep(ctx, wait) {
+			return ctx.Err()
+		}
+		if wait *= 2; wait > maxStreamWait {
+			wait = maxStreamWait
+		}
+	}
+}
+
+func (c Client) readStream(ctx context.Context, lastID *uint64, emit func(*Event) bool) error {
+
+	req, err := http.NewRequest("GET", c.base+c.prefix+"stream", nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	if *lastID != 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(*lastID, 10))
+	}
+
+	// The stream is open for as long as the subscription lasts, so it must not
+	// time out like the other requests of the client.
+	streamCli := *c.httpCli
+	streamCli.Timeout = 0
+	resp, err := streamCli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404 || resp.StatusCode == 501:
+		return errNoStream
+	case resp.StatusCode != 200:
+		return &ErrServer{URL: req.URL.String(), StatusCode: resp.StatusCode}
+	case !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"):
+		return errNoStream
+	}
+
+	var id uint64
+	var kind string
+	var data []byte
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if ev, err := decodeEvent(id, kind, data); err == nil {
+				if id != 0 {
+					*lastID = id
+				}
+				if !emit(ev) {
+					return ctx.Err()
+				}
+			}
+			id, kind, data = 0, "", nil
+		case strings.HasPrefix(line, "id: "):
+			id, _ = strconv.ParseUint(line[len("id: "):], 10, 64)
+		case strings.HasPrefix(line, "event: "):
+			kind = line[len("event: "):]
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, line[len("data: "):]...)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// decodeEvent rebuilds an event from the kind and payload it was streamed
+// with.
+func decodeEvent(id uint64, kind string, data []byte) (*Event, error) {
+
+	ev := &Event{ID: id, Kind: kind}
+	var payload interface{}
+	switch kind {
+	case EventBulletin, EventConfirmation:
+		ev.Bltn = &Bulletin{}
+		payload = ev.Bltn
+	case EventBlock:
+		ev.Block = &ombjson.JsonBlkHead{}
+		payload = ev.Block
+	case EventReorg:
+		ev.Reorg = &Reorg{}
+		payload = ev.Reorg
+	default:
+		return nil, fmt.Errorf("Unknown event: %s", kind)
+	}
+
+	if err := json.Unmarshal(data, payload); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// poll works out the events of the api by comparing what it holds between
+// polls. Just like the Feed, the first poll only records the state of the api
+// and errors are ignored since the next poll will try again.
+func (c Client) poll(ctx context.Context, emit func(*Event) bool) {
+
+	var last ombjson.Status
+	var unconf, conf map[string]bool
+	var tip *ombjson.JsonBlkHead
+	polled := false
+
+	for ; ; polled = true {
+		if polled && !sleep(ctx, c.opts.PollInterval) {
+			return
+		}
+
+		// Nothing has changed when the status has not.
+		statusResp, err := c.GetDBStatus(ctx)
+		if err != nil || statusResp.Status == nil {
+			continue
+		}
+		status := *statusResp.Status
+		if unconf != nil && status == last {
+			continue
+		}
+
+		pending, err := c.GetUnconfirmed(ctx)
+		if err != nil {
+			continue
+		}
+		recent, err := c.GetRecentConf(ctx, 0)
+		if err != nil {
+			continue
+		}
+		first := unconf == nil
+		var found []*ombjson.JsonBlkHead
+		newTip := tip
+		if first {
+			newTip, err = c.getTip(ctx)
+		} else {
+			found, newTip, err = c.blocksAfter(ctx, tip)
+		}
+		if err != nil {
+			continue
+		}
+
+		newUnconf := make(map[string]bool)
+		newConf := make(map[string]bool)
+		var fresh, confirmed []*ombjson.JsonBltn
+		byTxid := make(map[string]*Bulletin)
+		for _, bltn := range append(pending, recent...) {
+			byTxid[bltn.Txid] = bltn
+			if bltn.Blk == "" {
+				newUnconf[bltn.Txid] = true
+			} else {
+				newConf[bltn.Txid] = true
+			}
+
+			if !unconf[bltn.Txid] && !conf[bltn.Txid] {
+				fresh = append(fresh, bltn.JsonBltn)
+			}
+			if bltn.Blk != "" && !conf[bltn.Txid] {
+				confirmed = append(confirmed, bltn.JsonBltn)
+			}
+		}
+
+		last, unconf, conf, tip = status, newUnconf, newConf, newTip
+		if first {
+			continue
+		}
+
+		sort.Sort(bltnsByKey(fresh))
+		sort.Sort(bltnsByKey(confirmed))
+
+		var events []*Event
+		for _, bltn := range fresh {
+			events = append(events, &Event{Kind: EventBulletin, Bltn: byTxid[bltn.Txid]})
+		}
+		for _, blk := range found {
+			events = append(events, &Event{Kind: EventBlock, Block: blk})
+		}
+		for _, bltn := range confirmed {
+			events = append(events, &Event{Kind: EventConfirmation, Bltn: byTxid[bltn.Txid]})
+		}
+		for _, ev := range events {
+			if !emit(ev) {
+				return
+			}
+		}
+	}
+}
+
+// getTip returns the head of the highest block, or nil if the api holds none.
+func (c Client) getTip(ctx context.Context) (*ombjson.JsonBlkHead, error) {
+	tip := &ombjson.JsonBlkHead{}
+	err := c.getJson(ctx, "tip", tip)
+	if _, ok := err.(*ErrNotFound); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tip, nil
+}
+
+// blocksAfter returns the heads of the blocks above tip along with the new
+// tip. Every block is above a nil tip.
+func (c Client) blocksAfter(ctx context.Context, tip *ombjson.JsonBlkHead) ([]*ombjson.JsonBlkHead, *ombjson.JsonBlkHead, error) {
+
+	newTip, err := c.getTip(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if newTip == nil || (tip != nil && newTip.Height <= tip.Height) {
+		return nil, newTip, nil
+	}
+
+	// A range spans at most maxPageLimit heights.
+	var from uint64
+	if tip != nil {
+		from = tip.Height + 1
+	}
+	if newTip.Height-from >= maxPageLimit {
+		from = newTip.Height - maxPageLimit + 1
+	}
+
+	found := []*ombjson.JsonBlkHead{}
+	path := fmt.Sprintf("blocks?from=%d&to=%d", from, newTip.Height)
+	if err := c.getJson(ctx, path, &found); err != nil {
+		return nil, nil, err
+	}
+	return found, newTip, nil
+}
@@ -0,0 +1,151 @@
+package ahimsarest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+// next waits for the next event of a subscription.
+func next(t *testing.T, events <-chan *Event) *Event {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+		return nil
+	}
+}
+
+func TestSubscribeStream(t *testing.T) {
+
+	f := newIdleFeed()
+	r := mux.NewRouter()
+	r.HandleFunc("/stream", StreamHandler(f))
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := NewClientPrefix(ts.URL, "/")
+	events := c.Subscribe(ctx, SubscribeFilter{Kinds: []string{EventBulletin, EventBlock}, Board: "ahimsa-dev"})
+
+	for subscribed := false; !subscribed; {
+		f.mu.Lock()
+		subscribed = len(f.subs) > 0
+		f.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "a", Board: "elsewhere"}}, nil)
+	f.publish(EventConfirmation, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "b", Board: "ahimsa-dev"}}, nil)
+	f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "c", Board: "ahimsa-dev"}}, nil)
+	f.publish(EventBlock, nil, &ombjson.JsonBlkHead{Hash: "d", Height: 7})
+
+	if ev := next(t, events); ev.Kind != EventBulletin || ev.Bltn.Txid != "c" || ev.ID != 3 {
+		t.Errorf("Unexpected event %+v", ev)
+	}
+	if ev := next(t, events); ev.Kind != EventBlock || ev.Block.Height != 7 || ev.ID != 4 {
+		t.Errorf("Unexpected event %+v", ev)
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("The channel should close once the context is done")
+	}
+}
+
+func TestSubscribePoll(t *testing.T) {
+
+	store := newTestMemStore()
+	opts := DefaultOptions
+	opts.CacheBytes = 0
+	opts.ChainRefresh = 0
+	api := NewHandler("/", store, opts)
+
+	// The api is served without its stream.
+	var polls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stream":
+			http.NotFound(w, r)
+			return
+		case "/status":
+			atomic.AddInt32(&polls, 1)
+		}
+		api.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewClientWith(ts.URL, "/", ClientOptions{Backoff: time.Millisecond, PollInterval: 10 * time.Millisecond})
+	events := c.Subscribe(ctx, SubscribeFilter{})
+
+	// The first poll only records what the api holds.
+	for atomic.LoadInt32(&polls) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	tip := "00000000459c5532eefae667ca4443c813c6c9a054cb2775ba14fd7a002890ff"
+	store.AddBltn(&ombjson.JsonBltn{Txid: "e1", Board: "ahimsa-dev", Timestamp: 1415862600})
+	// The second bulletin is only seen once its block is.
+	store.AddBltn(&ombjson.JsonBltn{Txid: "e2", Board: "ahimsa-dev", Timestamp: 1415862650, Blk: "ff"})
+	store.AddBlock(&ombjson.JsonBlkHead{Hash: "ff", PrevHash: tip, Timestamp: 1415862700, Height: 307013})
+
+	var pollTests = []struct {
+		kind, id string
+	}{
+		{EventBulletin, "e1"},
+		{EventBulletin, "e2"},
+		{EventBlock, "ff"},
+		{EventConfirmation, "e2"},
+	}
+	for _, test := range pollTests {
+		ev := next(t, events)
+		id := ""
+		if ev.Bltn != nil {
+			id = ev.Bltn.Txid
+		} else if ev.Block != nil {
+			id = ev.Block.Hash
+		}
+		if ev.Kind != test.kind || id != test.id {
+			t.Errorf("Got %s %s wanted %s %s", ev.Kind, id, test.kind, test.id)
+		}
+	}
+
+	// Nothing is sent twice.
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case ev := <-events:
+		t.Errorf("Unexpected event %+v", ev)
+	default:
+	}
+}
+
+// Asserts that a subscription made with options that are not set waits before
+// reconnecting to a stream that keeps ending.
+func TestSubscribeZeroOptions(t *testing.T) {
+
+	var conns int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&conns, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := NewClientWith(ts.URL, "/", ClientOptions{})
+	c.Subscribe(ctx, SubscribeFilter{})
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	if n := atomic.LoadInt32(&conns); n > 2 {
+		t.Errorf("Reconnected %d times within 100ms", n)
+	}
+}
@@ -0,0 +1,191 @@
+package ahimsarest
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soapboxsys/ombudslib/pubrecdb"
+)
+
+// A CensorRecord says why a bulletin is censored, where the entry came from
+// and when it was added. Entries of the store carry no dates and bulletins this
+// api has not seen yet no board. The store never hands out the bulletins it
+// censors itself, so only the report finds the boards of its entries.
+type CensorRecord struct {
+	Txid   string `json:"txid"`
+	Reason string `json:"reason"`
+	Board  string `json:"board,omitempty"`
+	Posted int64  `json:"posted,omitempty"`
+	Added  int64  `json:"added,omitempty"`
+	Source string `json:"source"`
+	Issuer string `json:"issuer,omitempty"`
+}
+
+// A TransparencyReport summarises every bulletin this api censors. Months are
+// the months entries were added in, or unknown for entries of the store.
+// Unseen counts the entries whose bulletin is not in the store, which are left
+// out of ByBoard.
+type TransparencyReport struct {
+	Total    int             `json:"total"`
+	Unseen   int             `json:"unseen"`
+	ByReason map[string]int  `json:"byReason"`
+	ByBoard  map[string]int  `json:"byBoard"`
+	ByMonth  map[string]int  `json:"byMonth"`
+	BySource map[string]int  `json:"bySource"`
+	Records  []*CensorRecord `json:"records"`
+}
+
+type recordsByTxid []*CensorRecord
+
+func (s recordsByTxid) Len() int           { return len(s) }
+func (s recordsByTxid) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s recordsByTxid) Less(i, j int) bool { return s[i].Txid < s[j].Txid }
+
+// transparencyReport builds the report for the blacklist of db. Without an
+// overlay every entry belongs to the store.
+func transparencyReport(db Store, o *BlacklistOverlay) (*TransparencyReport, error) {
+
+	var records []*CensorRecord
+	if o != nil {
+		var err error
+		if records, err = o.Provenance(); err != nil {
+			return nil, err
+		}
+	} else {
+		blacklist, err := db.GetJsonBlacklist()
+		if err != nil {
+			return nil, err
+		}
+		records = []*CensorRecord{}
+		for _, entry := range blacklist {
+			records = append(records, &CensorRecord{Txid: entry.Txid, Reason: entry.Reason, Source: SourceStore})
+		}
+	}
+
+	bltns, err := allBltns(db)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]int)
+	for i, bltn := range bltns {
+		stored[bltn.Txid] = i
+	}
+
+	report := &TransparencyReport{
+		Total:    len(records),
+		ByReason: make(map[string]int),
+		ByBoard:  make(map[string]int),
+		ByMonth:  make(map[string]int),
+		BySource: make(map[string]int),
+		Records:  records,
+	}
+	for _, record := range records {
+		report.ByReason[record.Reason]++
+		report.BySource[record.Source]++
+
+		month := "unknown"
+		if record.Added != 0 {
+			month = time.Unix(record.Added, 0).UTC().Format("2006-01")
+		}
+		report.ByMonth[month]++
+
+		i, ok := stored[record.Txid]
+		if !ok {
+			report.Unseen++
+			continue
+		}
+		record.Board, record.Posted = bltns[i].Board, bltns[i].Timestamp
+		report.ByBoard[record.Board]++
+	}
+
+	return report, nil
+}
+
+// transparencyLink returns the Link header that points a 451 for the bulletin
+// with txid at its transparency record, as RFC 7725 suggests. The record is
+// served next to the bulletin so the prefix and version are kept.
+func transparencyLink(request *http.Request, txid string) string {
+	record := path.Join(path.Dir(path.Dir(request.URL.Path)), "transparency", txid)
+	return fmt.Sprintf(`<%s>; rel="blocked-by"`, record)
+}
+
+// Serves the summary of every bulletin this api censors.
+func TransparencyHandler(db Store, o *BlacklistOverlay) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		report, err := transparencyReport(db, o)
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+
+		writeJson(w, request, report)
+	}
+}
+
+// censorRecord returns the transparency record of the bulletin with txid
+// without building the whole report. It reports false when the bulletin is
+// not censored.
+func censorRecord(db Store, o *BlacklistOverlay, txid string) (*CensorRecord, bool, error) {
+
+	var record *CensorRecord
+	if o != nil {
+		var ok bool
+		var err error
+		if record, ok, err = o.ProvenanceOf(txid); err != nil || !ok {
+			return nil, false, err
+		}
+		// The store beneath the overlay still hands out what the operator
+		// censors.
+		db = o.Store
+	} else {
+		blacklist, err := db.GetJsonBlacklist()
+		if err != nil {
+			return nil, false, err
+		}
+		for _, entry := range blacklist {
+			if entry.Txid == txid {
+				record = &CensorRecord{Txid: txid, Reason: entry.Reason, Source: SourceStore}
+				break
+			}
+		}
+		if record == nil {
+			return nil, false, nil
+		}
+	}
+
+	bltn, err := db.GetJsonBltn(txid)
+	switch {
+	case err == sql.ErrNoRows, err == pubrecdb.ErrBltnCensored:
+	case err != nil:
+		return nil, false, err
+	default:
+		record.Board, record.Posted = bltn.Board, bltn.Timestamp
+	}
+
+	return record, true, nil
+}
+
+// Serves the transparency record of a single censored bulletin.
+func TransparencyRecordHandler(db Store, o *BlacklistOverlay) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		txid := mux.Vars(request)["txid"]
+
+		record, ok, err := censorRecord(db, o, txid)
+		if err != nil {
+			serverError(w, request, err)
+			return
+		}
+		if !ok {
+			writeError(w, request, 404, "The bulletin is not censored")
+			return
+		}
+
+		writeJson(w, request, record)
+	}
+}
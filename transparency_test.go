@@ -0,0 +1,119 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+func TestTransparency(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ahimsarest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	net := &chaincfg.TestNet3Params
+	store := newTestMemStore()
+	overlay, err := NewBlacklistOverlay(store, filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions
+	opts.Net = net
+	opts.Blacklist = overlay
+
+	ts := httptest.NewServer(NewHandler("/", store, opts))
+	defer ts.Close()
+
+	local := "933c592a1a22b41a9a692aba57da649c91fe32403e8ff7b13f452071aa9820b9"
+	imported := "f7800712c20377c2d29680c1aecf2331d6f80f5a44510d30ceb2e30fd5dafdcf"
+	unseen := "1111111111111111111111111111111111111111111111111111111111111111"
+
+	list := &SignedBlacklist{Created: 1415862580, Entries: []*ombjson.BlacklistEntry{
+		{Txid: imported, Reason: "Spam"},
+		{Txid: unseen, Reason: "Spam"},
+	}}
+	if err := list.Sign(testKey(0x11), net); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overlay.Add("alice", local, "Spam"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overlay.Subscribe("alice", list.Issuer, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overlay.Import("alice", list, net); err != nil {
+		t.Fatal(err)
+	}
+
+	var report TransparencyReport
+	if err := json.Unmarshal([]byte(get(t, ts.URL+"/transparency")), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 4 || report.Unseen != 1 || report.ByReason["Spam"] != 3 {
+		t.Errorf("Unexpected totals %+v", report)
+	}
+	if report.BySource[SourceStore] != 1 || report.BySource[SourceLocal] != 1 || report.BySource[SourceImported] != 2 {
+		t.Errorf("Unexpected sources %v", report.BySource)
+	}
+	if report.ByMonth["unknown"] != 1 {
+		t.Errorf("The entry of the store should have no month %v", report.ByMonth)
+	}
+
+	var recordTests = []struct {
+		txid, source, issuer string
+		dated                bool
+	}{
+		{local, SourceLocal, "", true},
+		{imported, SourceImported, list.Issuer, true},
+		{"b0a1ba6e40d8f35aac526eecbc05d82b2a6d3c8d6a316627f593cbe592a777be", SourceStore, "", false},
+		{unseen, SourceImported, list.Issuer, true},
+	}
+	reported := make(map[string]CensorRecord)
+	for _, record := range report.Records {
+		reported[record.Txid] = *record
+	}
+	for _, test := range recordTests {
+		var record CensorRecord
+		if err := json.Unmarshal([]byte(get(t, ts.URL+"/transparency/"+test.txid)), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Source != test.source || record.Issuer != test.issuer || (record.Added != 0) != test.dated {
+			t.Errorf("Unexpected record %+v", record)
+		}
+		// A single record is looked up on its own, but must match the report
+		// apart from the bulletins the store hides.
+		want := reported[test.txid]
+		if test.source == SourceStore {
+			want.Board, want.Posted = "", 0
+		}
+		if record != want {
+			t.Errorf("Record %+v differs from the report %+v", record, want)
+		}
+	}
+
+	var linkTests = []struct {
+		url, link string
+	}{
+		{ts.URL + "/bulletin/" + local, `</transparency/` + local + `>; rel="blocked-by"`},
+		{ts.URL + "/v1/bulletin/" + local, `</v1/transparency/` + local + `>; rel="blocked-by"`},
+	}
+	for _, test := range linkTests {
+		res := doGet(t, test.url, nil)
+		if res.StatusCode != 451 || res.Header.Get("Link") != test.link {
+			t.Errorf("%s: got %d %q", test.url, res.StatusCode, res.Header.Get("Link"))
+		}
+	}
+
+	if res := doGet(t, ts.URL+"/transparency/"+"2222222222222222222222222222222222222222222222222222222222222222", nil); res.StatusCode != 404 {
+		t.Errorf("Expected a 404 for a bulletin that is not censored: %d", res.StatusCode)
+	}
+}
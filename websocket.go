@@ -0,0 +1,244 @@
+package ahimsarest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// The number of messages that can be queued for a single connection before
+	// it is considered too slow and closed.
+	wsSendBuffer = 32
+	// How often the server pings the client and how long it waits for a pong.
+	wsPingPeriod = 30 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsWriteWait  = 10 * time.Second
+	// The largest message a client is allowed to send.
+	wsMaxMessage int64 = 1024
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// The api is public and read only so any origin may connect.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// A wsRequest is sent by the client to change its subscriptions. Topics are
+// "all", "blocks", "board:<name>" or "author:<addr>". Reorgs are sent to the
+// subscribers of blocks and of the bulletins they reverted.
+type wsRequest struct {
+	Op    string `json:"op"`
+	Topic string `json:"topic"`
+}
+
+// A wsMessage is sent by the server. Events carry the id and kind of the event
+// in ID and Type with the bulletin or block head in Data. Replies to requests
+// carry the topic and, if the request failed, an error.
+type wsMessage struct {
+	ID    uint64      `json:"id,omitempty"`
+	Type  string      `json:"type"`
+	Topic string      `json:"topic,omitempty"`
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// wsConn is a single websocket client along with the topics it subscribes to.
+type wsConn struct {
+	conn *websocket.Conn
+	send chan []byte
+
+	mu     sync.Mutex
+	topics map[string]bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (c *wsConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// queue hands msg to the writer without blocking. A client that can not keep
+// up with its messages is disconnected.
+func (c *wsConn) queue(msg *wsMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	select {
+	case c.send <- b:
+	case <-c.done:
+	default:
+		c.close()
+	}
+}
+
+// wants reports whether any of the client's topics match the event.
+func (c *wsConn) wants(ev *Event) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.topics["all"] {
+		return true
+	}
+	if ev.Block != nil {
+		return c.topics["blocks"]
+	}
+	if ev.Reorg != nil {
+		if c.topics["blocks"] {
+			return true
+		}
+		for _, bltn := range ev.Reorg.Reverted {
+			if c.topics["board:"+bltn.Board] || c.topics["author:"+bltn.Author] {
+				return true
+			}
+		}
+		return false
+	}
+	return c.topics["board:"+ev.Bltn.Board] || c.topics["author:"+ev.Bltn.Author]
+}
+
+func validTopic(topic string) bool {
+	if topic == "all" || topic == "blocks" {
+		return true
+	}
+	return strings.HasPrefix(topic, "board:") ||
+		(strings.HasPrefix(topic, "author:") && len(topic) > len("author:"))
+}
+
+// Serves the events of the feed over a websocket. Clients send subscribe and
+// unsubscribe requests for topics and are only sent the events that match.
+func WebSocketHandler(f *Feed) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+
+		if f == nil {
+			writeError(w, request, 501, "This api does not push events")
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, request, nil)
+		if err != nil {
+			// The upgrader has already responded to the client.
+			return
+		}
+
+		c := &wsConn{
+			conn:   conn,
+			send:   make(chan []byte, wsSendBuffer),
+			topics: make(map[string]bool),
+			done:   make(chan struct{}),
+		}
+		defer c.close()
+
+		sub := f.Subscribe(0)
+		defer f.Unsubscribe(sub)
+
+		go c.writePump()
+		go c.eventPump(sub)
+
+		c.readPump()
+	}
+}
+
+// readPump handles subscription requests until the connection fails or the
+// client stops answering pings.
+func (c *wsConn) readPump() {
+
+	c.conn.SetReadLimit(wsMaxMessage)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+
+	for {
+		req := wsRequest{}
+		if err := c.conn.ReadJSON(&req); err != nil {
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				c.queue(&wsMessage{Type: "error", Error: "Request is not valid json"})
+				continue
+			}
+			return
+		}
+
+		if !validTopic(req.Topic) {
+			c.queue(&wsMessage{Type: "error", Topic: req.Topic, Error: "Unknown topic"})
+			continue
+		}
+
+		c.mu.Lock()
+		switch req.Op {
+		case "subscribe":
+			c.topics[req.Topic] = true
+		case "unsubscribe":
+			delete(c.topics, req.Topic)
+		}
+		c.mu.Unlock()
+
+		switch req.Op {
+		case "subscribe":
+			c.queue(&wsMessage{Type: "subscribed", Topic: req.Topic})
+		case "unsubscribe":
+			c.queue(&wsMessage{Type: "unsubscribed", Topic: req.Topic})
+		default:
+			c.queue(&wsMessage{Type: "error", Topic: req.Topic, Error: "Unknown op"})
+		}
+	}
+}
+
+// eventPump filters the events of the feed for the client.
+func (c *wsConn) eventPump(sub *Subscription) {
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				// The feed dropped this connection for falling behind.
+				c.close()
+				return
+			}
+			if c.wants(ev) {
+				c.queue(&wsMessage{ID: ev.ID, Type: ev.Kind, Data: ev.Payload()})
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// writePump is the only writer to the connection. It drains the send buffer
+// and pings the client as a heartbeat.
+func (c *wsConn) writePump() {
+
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case b := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				c.close()
+				return
+			}
+		case <-ticker.C:
+			deadline := time.Now().Add(wsWriteWait)
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package ahimsarest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/soapboxsys/ombudslib/ombjson"
+)
+
+func TestWebSocketTopics(t *testing.T) {
+
+	f := newIdleFeed()
+	ts := httptest.NewServer(http.HandlerFunc(WebSocketHandler(f)))
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := wsMessage{}
+	conn.WriteJSON(wsRequest{Op: "subscribe", Topic: "bogus"})
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "error" {
+		t.Errorf("Expected an error for an unknown topic, got: %v", msg)
+	}
+
+	conn.WriteJSON(wsRequest{Op: "subscribe", Topic: "board:ahimsa-dev"})
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "subscribed" || msg.Topic != "board:ahimsa-dev" {
+		t.Fatalf("Subscription was not acknowledged: %v", msg)
+	}
+
+	// Only the bulletin posted to the subscribed board should come through.
+	f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "a", Board: "elsewhere"}}, nil)
+	f.publish(EventBlock, nil, &ombjson.JsonBlkHead{Hash: "b"})
+	f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "c", Board: "ahimsa-dev"}}, nil)
+
+	msg = wsMessage{}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := msg.Data.(map[string]interface{})
+	if msg.ID != 3 || msg.Type != EventBulletin || data["txid"] != "c" {
+		t.Errorf("Received the wrong event: %v", msg)
+	}
+
+	// Swap the board for an author and blocks.
+	var requests = []wsRequest{
+		{Op: "unsubscribe", Topic: "board:ahimsa-dev"},
+		{Op: "subscribe", Topic: "author:miUD"},
+		{Op: "subscribe", Topic: "blocks"},
+	}
+	for _, req := range requests {
+		conn.WriteJSON(req)
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != req.Op+"d" || msg.Topic != req.Topic {
+			t.Fatalf("%s %s was not acknowledged: %v", req.Op, req.Topic, msg)
+		}
+	}
+
+	f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "d", Board: "ahimsa-dev", Author: "mraY"}}, nil)
+	f.publish(EventBulletin, &Bulletin{JsonBltn: &ombjson.JsonBltn{Txid: "e", Board: "elsewhere", Author: "miUD"}}, nil)
+	f.publish(EventBlock, nil, &ombjson.JsonBlkHead{Hash: "f"})
+
+	var wantTests = []struct {
+		id   uint64
+		kind string
+		key  string
+		val  string
+	}{
+		{5, EventBulletin, "txid", "e"},
+		{6, EventBlock, "hash", "f"},
+	}
+	for _, want := range wantTests {
+		msg = wsMessage{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := msg.Data.(map[string]interface{})
+		if msg.ID != want.id || msg.Type != want.kind || data[want.key] != want.val {
+			t.Errorf("Received %v wanted event %d", msg, want.id)
+		}
+	}
+}
+
+// Asserts that a client whose messages pile up is disconnected rather than
+// holding up the others.
+func TestWebSocketSlowConsumer(t *testing.T) {
+
+	conns := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Nothing drains the send buffer, as if the client stopped reading.
+	c := &wsConn{
+		conn:   <-conns,
+		send:   make(chan []byte, 2),
+		topics: map[string]bool{"all": true},
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < 2; i++ {
+		c.queue(&wsMessage{ID: uint64(i + 1), Type: EventBlock})
+	}
+	select {
+	case <-c.done:
+		t.Fatalf("Closed a client before its buffer filled up")
+	default:
+	}
+
+	c.queue(&wsMessage{ID: 3, Type: EventBlock})
+	select {
+	case <-c.done:
+	default:
+		t.Fatalf("A client with a full buffer was kept")
+	}
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Errorf("The connection of the slow client is still open")
+	}
+
+	// Queueing on a closed client neither blocks nor panics.
+	c.queue(&wsMessage{ID: 4, Type: EventBlock})
+}